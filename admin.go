@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chain/txvm/errors"
+)
+
+// adminScopes are the scopes an admin token may carry,
+// each naming a group of operations it authorizes.
+var adminScopes = map[string]bool{
+	"read":  true, // GET /admin/status and /admin/builds
+	"halt":  true, // POST /admin/halt and /admin/resume
+	"hooks": true, // managing webhooks at /hooks
+}
+
+// adminToken is a bearer token that authorizes the operations in its scopes.
+type adminToken struct {
+	token  string
+	scopes map[string]bool
+}
+
+// readAdminTokens reads the -admin-tokens-file file.
+// Each line holds a token and a comma-separated list of its scopes,
+// separated by whitespace.
+// Blank lines and lines beginning with # are ignored.
+func readAdminTokens(filename string) ([]adminToken, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening admin tokens file")
+	}
+	defer f.Close()
+
+	var (
+		tokens []adminToken
+		lineno int
+	)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lineno++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want TOKEN SCOPE,SCOPE,...", filename, lineno)
+		}
+		tok := adminToken{token: fields[0], scopes: make(map[string]bool)}
+		for _, scope := range strings.Split(fields[1], ",") {
+			if !adminScopes[scope] {
+				return nil, fmt.Errorf("%s:%d: unknown scope %q", filename, lineno, scope)
+			}
+			tok.scopes[scope] = true
+		}
+		for _, other := range tokens {
+			if other.token == tok.token {
+				return nil, fmt.Errorf("%s:%d: duplicate token", filename, lineno)
+			}
+		}
+		tokens = append(tokens, tok)
+	}
+	if err = sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading admin tokens file")
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens in %s", filename)
+	}
+	return tokens, nil
+}
+
+// authorize tells whether req carries an admin token with the given scope,
+// responding with an error if not.
+// Without -admin-tokens-file, nothing is allowed
+// except managing webhooks with -hooks-open,
+// which anyone may do.
+func (n *node) authorize(w http.ResponseWriter, req *http.Request, scope string) bool {
+	n.tokensMu.RLock()
+	tokens := n.adminTokens
+	n.tokensMu.RUnlock()
+
+	if tokens == nil {
+		if scope == "hooks" {
+			if n.hooksOpen {
+				return true
+			}
+			httpErrf(w, http.StatusForbidden, "managing webhooks requires an admin token or -hooks-open")
+			return false
+		}
+		httpErrf(w, http.StatusNotFound, "admin API not enabled")
+		return false
+	}
+	given := []byte(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	for _, tok := range tokens {
+		if subtle.ConstantTimeCompare(given, []byte(tok.token)) == 1 {
+			if tok.scopes[scope] {
+				return true
+			}
+			httpErrf(w, http.StatusForbidden, "token lacks scope %q", scope)
+			return false
+		}
+	}
+	httpErrf(w, http.StatusUnauthorized, "unauthorized")
+	return false
+}
+
+// adminStatus is the JSON response to /admin/status.
+type adminStatus struct {
+	Halted     bool   `json:"halted"`
+	Height     uint64 `json:"height"`
+	PendingTxs int64  `json:"pending_txs"`
+	LongPolls  int64  `json:"long_polls"`
+}
+
+func (n *node) adminStatus(w http.ResponseWriter, req *http.Request) {
+	if !n.authorize(w, req, "read") {
+		return
+	}
+	var halted bool
+	n.do(func() {
+		halted = n.halted
+	})
+	respondJSON(w, adminStatus{
+		Halted:     halted,
+		Height:     n.store.FinalHeight(),
+		PendingTxs: atomic.LoadInt64(&n.pending),
+		LongPolls:  atomic.LoadInt64(&n.longPolls),
+	})
+}
+
+// adminHalt handles /admin/halt and /admin/resume,
+// which stop and restart block production and the acceptance of submissions.
+// Transactions already pending remain in the pool while halted.
+func (n *node) adminHalt(halt bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !n.authorize(w, req, "halt") {
+			return
+		}
+		if req.Method != "POST" {
+			httpErrf(w, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+			return
+		}
+		n.do(func() {
+			n.halted = halt
+		})
+		if halt {
+			n.log.Print("halted by admin request")
+		} else {
+			n.log.Print("resumed by admin request")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.dev = true
+
+	f, err := ioutil.TempFile("", "txvmbcdadmin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("# test tokens\nreader read\n\noperator halt,read\n")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.adminTokens, err = readAdminTokens(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+
+	call := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	cases := []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/admin/status", "reader", http.StatusOK},
+		{"GET", "/admin/status", "", http.StatusUnauthorized},
+		{"GET", "/admin/status", "bogus", http.StatusUnauthorized},
+		{"POST", "/admin/halt", "reader", http.StatusForbidden},
+		{"POST", "/admin/halt", "operator", http.StatusNoContent},
+	}
+	for _, c := range cases {
+		if got := call(c.method, c.path, c.token); got != c.want {
+			t.Errorf("%s %s with token %q: got status %d, want %d", c.method, c.path, c.token, got, c.want)
+		}
+	}
+
+	// Managing webhooks requires the hooks scope.
+	req := httptest.NewRequest("GET", "/hooks", nil)
+	req.Header.Set("Authorization", "Bearer operator")
+	rec := httptest.NewRecorder()
+	n.hooksHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("got status %d from /hooks without the hooks scope, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec = httptest.NewRecorder()
+	n.enqueue(rec, httptest.NewRequest("POST", "/submit", nil), testIssuance(ctx, t, n.initialBlock, 10))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d submitting while halted, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	if got := call("POST", "/admin/resume", "operator"); got != http.StatusNoContent {
+		t.Fatalf("got status %d resuming, want %d", got, http.StatusNoContent)
+	}
+	rec = httptest.NewRecorder()
+	n.enqueue(rec, httptest.NewRequest("POST", "/submit", nil), testIssuance(ctx, t, n.initialBlock, 10))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("got status %d submitting after resuming, want %d", rec.Code, http.StatusNoContent)
+	}
+}
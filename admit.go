@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// admitTimeout bounds each run of the -admit script.
+var admitTimeout = 10 * time.Second
+
+// admission is the JSON description of a submitted transaction
+// given to the -admit script on its standard input.
+type admission struct {
+	Chain       string           `json:"chain"`
+	TxID        string           `json:"tx_id"`
+	Version     int64            `json:"version"`
+	Runlimit    int64            `json:"runlimit"`
+	Size        int              `json:"size"`
+	Issuances   []admissionFlow  `json:"issuances"`
+	Retirements []admissionFlow  `json:"retirements"`
+	Inputs      int              `json:"inputs"`
+	Outputs     int              `json:"outputs"`
+	Nonces      []admissionNonce `json:"nonces"`
+	Timeranges  [][2]int64       `json:"timeranges"`
+}
+
+type admissionFlow struct {
+	AssetID string `json:"asset_id"`
+	Amount  int64  `json:"amount"`
+}
+
+type admissionNonce struct {
+	ID      string `json:"id"`
+	BlockID string `json:"block_id"`
+	ExpMS   uint64 `json:"exp_ms"`
+}
+
+func newAdmission(chain string, tx *bc.Tx) *admission {
+	a := &admission{
+		Chain:    chain,
+		TxID:     hex.EncodeToString(tx.ID.Bytes()),
+		Version:  tx.Version,
+		Runlimit: tx.Runlimit,
+		Size:     len(tx.Program),
+		Inputs:   len(tx.Inputs),
+		Outputs:  len(tx.Outputs),
+	}
+	for _, iss := range tx.Issuances {
+		a.Issuances = append(a.Issuances, admissionFlow{AssetID: hex.EncodeToString(iss.AssetID.Bytes()), Amount: iss.Amount})
+	}
+	for _, ret := range tx.Retirements {
+		a.Retirements = append(a.Retirements, admissionFlow{AssetID: hex.EncodeToString(ret.AssetID.Bytes()), Amount: ret.Amount})
+	}
+	for _, nonce := range tx.Nonces {
+		a.Nonces = append(a.Nonces, admissionNonce{
+			ID:      hex.EncodeToString(nonce.ID.Bytes()),
+			BlockID: hex.EncodeToString(nonce.BlockID.Bytes()),
+			ExpMS:   nonce.ExpMS,
+		})
+	}
+	for _, tr := range tx.Timeranges {
+		a.Timeranges = append(a.Timeranges, [2]int64{tr.MinMS, tr.MaxMS})
+	}
+	return a
+}
+
+// errNotAdmitted is the error returned by admit
+// when the script rejects a transaction.
+type errNotAdmitted struct {
+	reason string
+}
+
+func (e errNotAdmitted) Error() string {
+	if e.reason == "" {
+		return "rejected by admission script"
+	}
+	return "rejected by admission script: " + e.reason
+}
+
+// admit runs the -admit script on tx.
+// The script receives an admission as JSON on its standard input
+// and admits tx by exiting with status 0.
+// Any other status rejects tx,
+// with the script's output (if any) as the reason.
+func (n *node) admit(ctx context.Context, tx *bc.Tx) (err error) {
+	if n.admitScript == "" {
+		return nil
+	}
+
+	ctx, sp := startSpan(ctx, "admit")
+	defer func() { sp.finish(err) }()
+
+	input, err := json.Marshal(newAdmission(n.name, tx))
+	if err != nil {
+		return errors.Wrap(err, "marshaling admission")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, admitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, n.admitScript)
+	cmd.Stdin = bytes.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+		return errNotAdmitted{reason: strings.TrimSpace(string(out))}
+	}
+	return errors.Wrapf(err, "running admission script %s", n.admitScript)
+}
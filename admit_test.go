@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdmit(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.dev = true
+
+	dir, err := ioutil.TempDir("", "txvmbcdadmit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n.admitScript = filepath.Join(dir, "admit")
+	const script = `#!/bin/sh
+if grep -q '"amount":1000'; then
+  echo too much
+  exit 1
+fi
+`
+	err = ioutil.WriteFile(n.admitScript, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	n.enqueue(rec, httptest.NewRequest("POST", "/submit", nil), testIssuance(ctx, t, n.initialBlock, 10))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("got status %d for admitted tx, want %d: %s", rec.Code, http.StatusNoContent, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	n.enqueue(rec, httptest.NewRequest("POST", "/submit", nil), testIssuance(ctx, t, n.initialBlock, 1000))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("got status %d for rejected tx, want %d", rec.Code, http.StatusForbidden)
+	}
+	if !strings.Contains(rec.Body.String(), "too much") {
+		t.Errorf("got response %q, want it to include the script's reason", rec.Body)
+	}
+	if h := n.chain.Height(); h != 2 {
+		t.Errorf("got height %d, want 2", h)
+	}
+}
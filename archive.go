@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// An archive is the portable form of a chain written by export and read by import:
+// each block in height order,
+// serialized as a protobuf
+// and preceded by its length as a uvarint.
+
+// maxArchiveBlockSize bounds the length prefix that import will believe.
+const maxArchiveBlockSize = 64 << 20
+
+// exportCmd implements "txvmbcd export -db DBFILE -o FILE".
+func exportCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dbfile := fs.String("db", "", "path to block storage db (which may be in use)")
+	out := fs.String("o", "", "file to write the archive to (gzipped if it ends in .gz)")
+	fs.Parse(args)
+
+	if *dbfile == "" || *out == "" {
+		return errors.New("export requires -db and -o")
+	}
+	if _, err := os.Stat(*dbfile); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", "file:"+*dbfile+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	n, err := exportFile(ctx, db, *out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("exported %d blocks\n", n)
+	return nil
+}
+
+// exportFile writes the blocks in db as an archive to the new file dest,
+// gzipped if dest ends in .gz,
+// and returns the number written.
+func exportFile(ctx context.Context, db *sql.DB, dest string) (int, error) {
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, err
+	}
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if strings.HasSuffix(dest, ".gz") {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+	bw := bufio.NewWriter(w)
+	n, err := exportBlocks(ctx, db, bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return 0, errors.Wrapf(err, "exporting to %s", dest)
+	}
+	return n, nil
+}
+
+// exportBlocks writes the blocks in db to w as an archive
+// and returns the number written.
+func exportBlocks(ctx context.Context, db *sql.DB, w io.Writer) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT height, bits FROM blocks ORDER BY height")
+	if err != nil {
+		return 0, errors.Wrap(err, "querying blocks")
+	}
+	defer rows.Close()
+
+	var (
+		n      int
+		prefix [binary.MaxVarintLen64]byte
+	)
+	for rows.Next() {
+		var (
+			height uint64
+			bits   []byte
+		)
+		err = rows.Scan(&height, &bits)
+		if err != nil {
+			return n, errors.Wrap(err, "scanning block row")
+		}
+		if height != uint64(n)+1 {
+			return n, fmt.Errorf("block %d is missing", n+1)
+		}
+		_, err = w.Write(prefix[:binary.PutUvarint(prefix[:], uint64(len(bits)))])
+		if err == nil {
+			_, err = w.Write(bits)
+		}
+		if err != nil {
+			return n, errors.Wrapf(err, "writing block %d", height)
+		}
+		n++
+	}
+	return n, errors.Wrap(rows.Err(), "iterating over blocks")
+}
+
+// importCmd implements "txvmbcd import -i FILE -db DBFILE".
+func importCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("i", "", "archive to import (gzipped if it ends in .gz)")
+	dbfile := fs.String("db", "", "path of the block storage db to create")
+	fs.Parse(args)
+
+	if *in == "" || *dbfile == "" {
+		return errors.New("import requires -i and -db")
+	}
+	height, err := importFile(ctx, *in, *dbfile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported blocks 1 through %d\n", height)
+	return nil
+}
+
+// importFile reads the archive in the file src,
+// gzipped if src ends in .gz,
+// into the new db file dest
+// and returns the height of the last block.
+// Dest is created only if the whole archive is valid.
+func importFile(ctx context.Context, src, dest string) (uint64, error) {
+	if _, err := os.Stat(dest); err == nil {
+		return 0, fmt.Errorf("%s already exists", dest)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(src, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, errors.Wrapf(err, "decompressing %s", src)
+		}
+		r = zr
+	}
+
+	// Import to a temporary file in dest's directory,
+	// so that the final rename is atomic.
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".txvmbcd-import")
+	if err != nil {
+		return 0, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	db, err := sql.Open("sqlite3", tmp.Name())
+	if err != nil {
+		return 0, err
+	}
+	height, err := importBlocks(ctx, bufio.NewReader(r), db)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "importing %s", src)
+	}
+	return height, os.Rename(tmp.Name(), dest)
+}
+
+// importBlocks reads an archive from r into the new db,
+// validating each block against its predecessor
+// and checking the state roots it declares,
+// and saves a snapshot of the final state.
+// It returns the height of the last block.
+func importBlocks(ctx context.Context, r *bufio.Reader, db *sql.DB) (uint64, error) {
+	var n *node
+	for {
+		b, err := readArchiveBlock(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n == nil {
+			if b.Height != 1 {
+				return 0, fmt.Errorf("archive begins at height %d, want 1", b.Height)
+			}
+			n, err = newNodeFrom(ctx, db, b)
+			if err != nil {
+				return 0, err
+			}
+			defer close(n.quit)
+			continue
+		}
+		height := n.chain.Height() + 1
+		err = n.verifyBlock(ctx, b, height)
+		if err != nil {
+			return 0, errors.Wrapf(err, "block %d", height)
+		}
+	}
+	if n == nil {
+		return 0, errors.New("archive is empty")
+	}
+	err := n.store.writeSnapshot(ctx, n.chain.State())
+	return n.chain.Height(), err
+}
+
+// readArchiveBlock reads the next block from an archive.
+// It returns io.EOF at the end of the archive.
+func readArchiveBlock(r *bufio.Reader) (*bc.Block, error) {
+	size, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading block length")
+	}
+	if size > maxArchiveBlockSize {
+		return nil, fmt.Errorf("block length %d exceeds %d", size, maxArchiveBlockSize)
+	}
+	bits := make([]byte, size)
+	_, err = io.ReadFull(r, bits)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading block")
+	}
+	b := new(bc.Block)
+	err = b.FromBytes(bits)
+	return b, errors.Wrap(err, "parsing block")
+}
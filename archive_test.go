@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, amount := range []int64{10, 20} {
+		n.do(func() {
+			_, err = n.commitNow(ctx, testIssuance(ctx, t, n.initialBlock, amount))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "txvmbcdarchive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"chain.arc", "chain.arc.gz"} {
+		t.Run(name, func(t *testing.T) {
+			archive := filepath.Join(dir, name)
+			count, err := exportFile(ctx, db, archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 3 {
+				t.Errorf("exported %d blocks, want 3", count)
+			}
+
+			imported := filepath.Join(dir, "imported-"+name+".db")
+			height, err := importFile(ctx, archive, imported)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if height != 3 {
+				t.Errorf("imported up to height %d, want 3", height)
+			}
+			if _, err = importFile(ctx, archive, imported); err == nil {
+				t.Error("got no error importing over an existing db")
+			}
+
+			idb, err := sql.Open("sqlite3", imported)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer idb.Close()
+			problems, err := fsck(ctx, idb, ioutil.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if problems > 0 {
+				t.Errorf("fsck found %d problem(s) in the imported db", problems)
+			}
+		})
+	}
+
+	// An archive that skips block 2.
+	var buf bytes.Buffer
+	for _, height := range []uint64{1, 3} {
+		b, err := n.store.GetBlock(ctx, height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bits, err := b.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var prefix [binary.MaxVarintLen64]byte
+		buf.Write(prefix[:binary.PutUvarint(prefix[:], uint64(len(bits)))])
+		buf.Write(bits)
+	}
+	gappy := filepath.Join(dir, "gappy.arc")
+	err = ioutil.WriteFile(gappy, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = importFile(ctx, gappy, filepath.Join(dir, "imported-gappy.db")); err == nil {
+		t.Error("got no error importing an archive with a missing block")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "imported-gappy.db")); err == nil {
+		t.Error("failed import left a db behind")
+	}
+
+	truncated := filepath.Join(dir, "truncated.arc")
+	err = ioutil.WriteFile(truncated, buf.Bytes()[:buf.Len()-1], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = importFile(ctx, truncated, filepath.Join(dir, "imported-truncated.db")); err == nil {
+		t.Error("got no error importing a truncated archive")
+	}
+}
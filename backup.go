@@ -0,0 +1,268 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupDB writes a consistent copy of the live db src to the new file dest
+// using sqlite's online backup API,
+// so other connections may go on writing to src meanwhile.
+// If dest ends in .gz, the copy is gzipped.
+func backupDB(ctx context.Context, src *sql.DB, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+
+	copyFile := dest
+	gz := strings.HasSuffix(dest, ".gz")
+	if gz {
+		f, err := ioutil.TempFile(filepath.Dir(dest), ".txvmbcd-backup")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		copyFile = f.Name()
+		defer os.Remove(copyFile)
+	}
+
+	destDB, err := sql.Open("sqlite3", copyFile)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "connecting to source db")
+	}
+	defer srcConn.Close()
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "connecting to backup db")
+	}
+	defer destConn.Close()
+
+	err = destConn.Raw(func(destRaw interface{}) error {
+		return srcConn.Raw(func(srcRaw interface{}) error {
+			bk, err := destRaw.(*sqlite3.SQLiteConn).Backup("main", srcRaw.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			_, err = bk.Step(-1)
+			if err != nil {
+				bk.Finish()
+				return err
+			}
+			return bk.Finish()
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "backing up db")
+	}
+	if !gz {
+		return nil
+	}
+
+	err = destConn.Close()
+	if err != nil {
+		return err
+	}
+	err = destDB.Close()
+	if err != nil {
+		return err
+	}
+	return gzipFile(copyFile, dest)
+}
+
+func gzipFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+	}
+	return errors.Wrapf(err, "compressing %s", dest)
+}
+
+// backupCmd implements "txvmbcd backup -db DBFILE -o FILE".
+func backupCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dbfile := fs.String("db", "", "path to block storage db (which may be in use)")
+	out := fs.String("o", "", "file to write the backup to (gzipped if it ends in .gz)")
+	fs.Parse(args)
+
+	if *dbfile == "" || *out == "" {
+		return errors.New("backup requires -db and -o")
+	}
+	if _, err := os.Stat(*dbfile); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", *dbfile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return backupDB(ctx, db, *out)
+}
+
+// restoreCmd implements "txvmbcd restore -i FILE -db DBFILE [-force]".
+func restoreCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("i", "", "backup file to restore (gzipped if it ends in .gz)")
+	dbfile := fs.String("db", "", "path of the block storage db to create")
+	force := fs.Bool("force", false, "replace DBFILE if it exists")
+	fs.Parse(args)
+
+	if *in == "" || *dbfile == "" {
+		return errors.New("restore requires -i and -db")
+	}
+	return restoreDB(ctx, *in, *dbfile, *force)
+}
+
+// restoreDB checks the backup in the file src with fsck
+// and, if it is sound, moves it into place as dest.
+// The server must not be running on dest.
+func restoreDB(ctx context.Context, src, dest string, force bool) error {
+	if _, err := os.Stat(dest); err == nil && !force {
+		return fmt.Errorf("%s already exists", dest)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var r io.Reader = in
+	if strings.HasSuffix(src, ".gz") {
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return errors.Wrapf(err, "decompressing %s", src)
+		}
+		r = zr
+	}
+
+	// Restore to a temporary file in dest's directory,
+	// so that the final rename is atomic.
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".txvmbcd-restore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "copying %s", src)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+tmp.Name()+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	problems, err := fsck(ctx, db, os.Stderr)
+	db.Close()
+	if err != nil {
+		return err
+	}
+	if problems > 0 {
+		return fmt.Errorf("backup %s has %d problem(s), not restored", src, problems)
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// backupTimeLayout is the format of the TIMESTAMP in backup names.
+const backupTimeLayout = "20060102T150405Z"
+
+// startBackups makes n back up its db into dir every interval,
+// keeping the latest keep backups.
+// Backups are named CHAIN-TIMESTAMP.db.gz,
+// where CHAIN is n's name (or "default").
+func (n *node) startBackups(ctx context.Context, dir string, interval time.Duration, keep int) {
+	prefix := n.name
+	if prefix == "" {
+		prefix = "default"
+	}
+	prefix += "-"
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-ticker.C:
+				dest := filepath.Join(dir, prefix+t.UTC().Format(backupTimeLayout)+".db.gz")
+				err := backupDB(ctx, n.db, dest)
+				if err != nil {
+					n.log.Print(errors.Wrap(err, "backing up db"))
+					continue
+				}
+				n.log.Printf("backed up db to %s", dest)
+				err = pruneBackups(dir, prefix, keep)
+				if err != nil {
+					n.log.Print(errors.Wrap(err, "removing old backups"))
+				}
+			}
+		}
+	}()
+}
+
+// pruneBackups removes all but the latest keep backups in dir
+// named PREFIX-TIMESTAMP.db.gz.
+// The rest of the name must be just a timestamp,
+// since a chain ID may itself begin with prefix
+// (e.g. a-b with prefix a-).
+func pruneBackups(dir, prefix string, keep int) error {
+	matches, err := filepath.Glob(filepath.Join(dir, prefix+"*.db.gz"))
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, name := range matches {
+		ts := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), prefix), ".db.gz")
+		if _, err := time.Parse(backupTimeLayout, ts); err == nil {
+			names = append(names, name)
+		}
+	}
+	// The timestamps in the names sort chronologically.
+	sort.Strings(names)
+	for len(names) > keep {
+		err = os.Remove(names[0])
+		if err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
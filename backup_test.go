@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.do(func() {
+		_, err = n.commitNow(ctx, testIssuance(ctx, t, n.initialBlock, 10))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "txvmbcdbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"backup.db", "backup.db.gz"} {
+		t.Run(name, func(t *testing.T) {
+			backup := filepath.Join(dir, name)
+			err := backupDB(ctx, n.db, backup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = backupDB(ctx, n.db, backup); err == nil {
+				t.Error("got no error overwriting a backup")
+			}
+
+			restored := filepath.Join(dir, "restored-"+name+".db")
+			err = restoreDB(ctx, backup, restored, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = restoreDB(ctx, backup, restored, false); err == nil {
+				t.Error("got no error restoring over an existing db without -force")
+			}
+
+			rdb, err := sql.Open("sqlite3", restored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rdb.Close()
+			var height uint64
+			err = rdb.QueryRow("SELECT MAX(height) FROM blocks").Scan(&height)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if height != 2 {
+				t.Errorf("got restored height %d, want 2", height)
+			}
+		})
+	}
+
+	bad := filepath.Join(dir, "bad.db")
+	err = ioutil.WriteFile(bad, []byte("not a database"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = restoreDB(ctx, bad, filepath.Join(dir, "restored-bad.db"), false); err == nil {
+		t.Error("got no error restoring a bad backup")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txvmbcdbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{
+		"a-20200101T000000Z.db.gz",
+		"a-20200102T000000Z.db.gz",
+		"a-20200103T000000Z.db.gz",
+		"b-20200101T000000Z.db.gz",
+		"a-b-20200101T000000Z.db.gz",
+		"a-b-20200102T000000Z.db.gz",
+		"a-b-20200103T000000Z.db.gz",
+	}
+	for _, name := range names {
+		err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = pruneBackups(dir, "a-", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pruneBackups(dir, "a-b-", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pruning a- must not count or remove a-b's backups.
+	want := map[string]bool{
+		"a-20200102T000000Z.db.gz":   true,
+		"a-20200103T000000Z.db.gz":   true,
+		"b-20200101T000000Z.db.gz":   true,
+		"a-b-20200103T000000Z.db.gz": true,
+	}
+	for _, name := range names {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != want[name] {
+			t.Errorf("%s: got exists=%v, want %v", name, exists, want[name])
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+type assetBalance struct {
+	AssetID string `json:"asset_id"`
+	Amount  int64  `json:"amount"`
+}
+
+// BalanceAt returns the amounts of each asset held by pubkey
+// in the indexed outputs that were unspent as of the given height.
+// An output controlled by several pubkeys counts in full for each of them.
+// Outputs whose value is unknown are omitted.
+func (ix *indexer) BalanceAt(ctx context.Context, pubkey []byte, height uint64) ([]*assetBalance, error) {
+	const q = "SELECT o.asset_id, SUM(o.amount) FROM outputs o JOIN output_pubkeys p ON p.output_id = o.output_id WHERE p.pubkey = $1 AND o.asset_id IS NOT NULL AND o.height <= $2 AND (o.spent_height IS NULL OR o.spent_height > $2) GROUP BY o.asset_id ORDER BY o.asset_id"
+	rows, err := ix.db.QueryContext(ctx, q, pubkey, height)
+	if err != nil {
+		return nil, errors.Wrapf(err, "querying balance of %x at height %d", pubkey, height)
+	}
+	defer rows.Close()
+
+	result := []*assetBalance{}
+	for rows.Next() {
+		var (
+			assetID []byte
+			bal     assetBalance
+		)
+		err = rows.Scan(&assetID, &bal.Amount)
+		if err != nil {
+			return nil, errors.Wrap(err, "scanning balance")
+		}
+		bal.AssetID = hex.EncodeToString(assetID)
+		result = append(result, &bal)
+	}
+	return result, errors.Wrap(rows.Err(), "iterating over balances")
+}
+
+// heightAt returns the height of the last block, no higher than max,
+// whose timestamp is not after t.
+// It returns 0 if the genesis block is after t.
+func (n *node) heightAt(ctx context.Context, t time.Time, max uint64) (uint64, error) {
+	var err error
+	ms := bc.Millis(t)
+	// Find the first block after t; the one before it is the answer.
+	// Block timestamps strictly increase with height.
+	after := sort.Search(int(max), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var b *bc.Block
+		b, err = n.store.GetBlock(ctx, uint64(i+1))
+		if err != nil {
+			err = errors.Wrapf(err, "getting block %d", i+1)
+			return true
+		}
+		return b.TimestampMs > ms
+	})
+	return uint64(after), err
+}
+
+// balanceHistory handles /balance/history?pubkey=P&at=A,
+// where A is a block height or an RFC3339 timestamp
+// and defaults to the highest indexed block.
+func (n *node) balanceHistory(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	pubkey, err := hexParam(req, "pubkey")
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing pubkey: %s", err)
+		return
+	}
+	if pubkey == nil {
+		httpErrf(w, http.StatusBadRequest, "must supply pubkey")
+		return
+	}
+
+	indexed, err := n.idx.Height(ctx)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	height := indexed
+	if at := req.FormValue("at"); at != "" {
+		if h, err := strconv.ParseUint(at, 10, 64); err == nil {
+			height = h
+		} else if t, err := time.Parse(time.RFC3339, at); err == nil {
+			height, err = n.heightAt(ctx, t, indexed)
+			if err != nil {
+				httpErrf(w, http.StatusInternalServerError, "finding block at %s: %s", at, err)
+				return
+			}
+		} else {
+			httpErrf(w, http.StatusBadRequest, "at must be a block height or an RFC3339 timestamp")
+			return
+		}
+	}
+	if height < 1 {
+		httpErrf(w, http.StatusNotFound, "no block at or before %s", req.FormValue("at"))
+		return
+	}
+	if height > indexed {
+		httpErrf(w, http.StatusNotFound, "block %d is not yet indexed (index height %d)", height, indexed)
+		return
+	}
+
+	b, err := n.store.GetBlock(ctx, height)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "getting block %d: %s", height, err)
+		return
+	}
+	balances, err := n.idx.BalanceAt(ctx, pubkey, height)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	respondJSON(w, struct {
+		Pubkey      string          `json:"pubkey"`
+		Height      uint64          `json:"height"`
+		TimestampMS uint64          `json:"timestamp_ms"`
+		Balances    []*assetBalance `json:"balances"`
+	}{
+		Pubkey:      hex.EncodeToString(pubkey),
+		Height:      height,
+		TimestampMS: b.TimestampMs,
+		Balances:    balances,
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+func TestBalanceHistory(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.idx, err = newIndexer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Issue 10 units at height 2 and 5 more at height 3.
+	for _, amount := range []int64{10, 5} {
+		n.do(func() {
+			_, err = n.commitNow(ctx, testIssuance(ctx, t, n.initialBlock, amount))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := n.store.GetBlock(ctx, n.chain.Height())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = n.idx.IndexBlock(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b2, err := n.store.GetBlock(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend the first output was spent at height 3.
+	_, err = db.Exec("UPDATE outputs SET spent_height = 3 WHERE height = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, pub := testKeys(t)
+	pubHex := hex.EncodeToString(pub)
+
+	cases := []struct {
+		at         string
+		wantStatus int
+		wantHeight uint64
+		wantAmount int64
+	}{
+		{"", http.StatusOK, 3, 5},
+		{"2", http.StatusOK, 2, 10},
+		{"3", http.StatusOK, 3, 5},
+		{"1", http.StatusOK, 1, 0},
+		{"4", http.StatusNotFound, 0, 0},
+		{"0", http.StatusNotFound, 0, 0},
+		{bc.FromMillis(b2.TimestampMs).UTC().Format(time.RFC3339Nano), http.StatusOK, 2, 10},
+		{bc.FromMillis(n.initialBlock.TimestampMs - 1000).UTC().Format(time.RFC3339), http.StatusNotFound, 0, 0},
+		{"yesterday", http.StatusBadRequest, 0, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.at, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			n.balanceHistory(rec, httptest.NewRequest("GET", "/balance/history?pubkey="+pubHex+"&at="+c.at, nil))
+			if rec.Code != c.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, c.wantStatus, rec.Body)
+			}
+			if c.wantStatus != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Height   uint64          `json:"height"`
+				Balances []*assetBalance `json:"balances"`
+			}
+			err := json.NewDecoder(rec.Body).Decode(&resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Height != c.wantHeight {
+				t.Errorf("got height %d, want %d", resp.Height, c.wantHeight)
+			}
+			var got int64
+			for _, bal := range resp.Balances {
+				got += bal.Amount
+			}
+			if got != c.wantAmount {
+				t.Errorf("got balance %d, want %d", got, c.wantAmount)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	n.balanceHistory(rec, httptest.NewRequest("GET", "/balance/history", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d without pubkey, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os/exec"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/golang/protobuf/proto"
+)
+
+// blockScriptTimeout bounds each run of the -block-script script.
+// The builder goroutine waits for it.
+var blockScriptTimeout = 5 * time.Second
+
+// blockRequest is the JSON description of a block being built
+// given to the -block-script script on its standard input.
+type blockRequest struct {
+	Chain       string `json:"chain"`
+	Height      uint64 `json:"height"`
+	TimestampMS uint64 `json:"timestamp_ms"`
+	PrevBlockID string `json:"prev_block_id"`
+}
+
+// blockScriptTx runs the -block-script script
+// for the block following prev with the given timestamp.
+// The script receives a blockRequest as JSON on its standard input
+// and may write a hex-encoded serialized RawTx to its standard output,
+// which blockScriptTx returns as the first tx of the block.
+// If the script writes nothing, the result is nil.
+func (n *node) blockScriptTx(ctx context.Context, prev *bc.BlockHeader, timestampMS uint64) (*bc.Tx, error) {
+	input, err := json.Marshal(blockRequest{
+		Chain:       n.name,
+		Height:      prev.Height + 1,
+		TimestampMS: timestampMS,
+		PrevBlockID: hex.EncodeToString(prev.Hash().Bytes()),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling block request")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, blockScriptTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, n.blockScript)
+	cmd.Stdin = bytes.NewReader(input)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "running block script %s", n.blockScript)
+	}
+	out = bytes.TrimSpace(out)
+	if len(out) == 0 {
+		return nil, nil
+	}
+
+	bits, err := hex.DecodeString(string(out))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding block script output")
+	}
+	var rawTx bc.RawTx
+	err = proto.Unmarshal(bits, &rawTx)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing block script tx")
+	}
+	tx, err := bc.NewTx(rawTx.Program, rawTx.Version, rawTx.Runlimit)
+	return tx, errors.Wrap(err, "building block script tx")
+}
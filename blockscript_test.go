@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestBlockScript(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "txvmbcdblockscript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	anchor := testIssuance(ctx, t, n.initialBlock, 1)
+	bits, err := proto.Marshal(&anchor.RawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		reqFile = filepath.Join(dir, "req.json")
+		txFile  = filepath.Join(dir, "tx")
+	)
+	n.blockScript = filepath.Join(dir, "block")
+	script := "#!/bin/sh\ncat > " + reqFile + "\ncat " + txFile + "\n"
+	err = ioutil.WriteFile(n.blockScript, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(txFile, []byte(hex.EncodeToString(bits)+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := func(amount int64) []string {
+		t.Helper()
+		var height uint64
+		n.do(func() {
+			height, err = n.commitNow(ctx, testIssuance(ctx, t, n.initialBlock, amount))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := n.store.GetBlock(ctx, height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, tx := range b.Transactions {
+			ids = append(ids, hex.EncodeToString(tx.ID.Bytes()))
+		}
+		return ids
+	}
+
+	ids := commit(10)
+	if len(ids) != 2 || ids[0] != hex.EncodeToString(anchor.ID.Bytes()) {
+		t.Errorf("got txs %v in the first block, want the block script's tx %x first of 2", ids, anchor.ID.Bytes())
+	}
+	reqBits, err := ioutil.ReadFile(reqFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req blockRequest
+	err = json.Unmarshal(reqBits, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Height != 2 || req.PrevBlockID != hex.EncodeToString(n.initialBlock.Hash().Bytes()) {
+		t.Errorf("got block request %+v, want height 2 following the initial block", req)
+	}
+
+	// The same tx cannot go in another block,
+	// but the block is built without it.
+	ids = commit(20)
+	if len(ids) != 1 {
+		t.Errorf("got %d txs in the second block, want 1", len(ids))
+	}
+
+	// A script that prints nothing contributes nothing.
+	err = ioutil.WriteFile(txFile, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = commit(30)
+	if len(ids) != 1 {
+		t.Errorf("got %d txs in the third block, want 1", len(ids))
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"net/http"
+	"strconv"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// Each item in a bloom filter sets bloomHashes bits.
+// Filters are sized at bloomBitsPerItem bits per item
+// (but at least bloomMinBits),
+// giving a false-positive rate of about 1%.
+const (
+	bloomHashes      = 7
+	bloomBitsPerItem = 10
+	bloomMinBits     = 64
+)
+
+// bloomHashesHeader is the HTTP header
+// reporting the number of bits set per item in a bloom filter.
+const bloomHashesHeader = "X-Txvmbcd-Bloom-Hashes"
+
+// bloomFilter is a bloom filter over 32-byte hashes.
+// The bit positions for an item are
+// successive big-endian uint32s taken from the item,
+// modulo the size of the filter in bits.
+type bloomFilter []byte
+
+func newBloomFilter(n int) bloomFilter {
+	nbits := n * bloomBitsPerItem
+	if nbits < bloomMinBits {
+		nbits = bloomMinBits
+	}
+	return make(bloomFilter, (nbits+7)/8)
+}
+
+func (f bloomFilter) positions(h bc.Hash) []uint32 {
+	var (
+		b     = h.Bytes()
+		nbits = uint32(len(f) * 8)
+		res   = make([]uint32, 0, bloomHashes)
+	)
+	for i := 0; i < bloomHashes; i++ {
+		res = append(res, binary.BigEndian.Uint32(b[4*i:])%nbits)
+	}
+	return res
+}
+
+func (f bloomFilter) add(h bc.Hash) {
+	for _, pos := range f.positions(h) {
+		f[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+func (f bloomFilter) contains(h bc.Hash) bool {
+	for _, pos := range f.positions(h) {
+		if f[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// blockBloom returns a bloom filter over the asset IDs
+// and the output IDs (created or spent) touched by the transactions in b.
+func blockBloom(b *bc.Block) bloomFilter {
+	items := make(map[bc.Hash]bool)
+	for _, tx := range b.Transactions {
+		for assetID := range txAssets(tx) {
+			items[assetID] = true
+		}
+		for _, c := range tx.Contracts {
+			items[c.ID] = true
+		}
+	}
+	f := newBloomFilter(len(items))
+	for item := range items {
+		f.add(item)
+	}
+	return f
+}
+
+// bloomStore maintains a bloom filter for each committed block.
+type bloomStore struct {
+	db *sql.DB
+}
+
+func newBloomStore(db *sql.DB) (*bloomStore, error) {
+	_, err := db.Exec(bloomSchema)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating bloom filter schema")
+	}
+	return &bloomStore{db: db}, nil
+}
+
+// Run computes and stores the bloom filter of each block of bs as it is committed,
+// starting after the highest block already done.
+// It returns only on error or context cancellation.
+func (s *bloomStore) Run(ctx context.Context, bs *blockStore) error {
+	var height uint64
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(height), 0) FROM blooms").Scan(&height)
+	if err != nil {
+		return errors.Wrap(err, "getting bloom filter height")
+	}
+	return follow(ctx, bs, height+1, func(b *bc.Block) error {
+		_, err := s.db.ExecContext(ctx, "INSERT OR IGNORE INTO blooms (height, bits) VALUES ($1, $2)", b.Height, []byte(blockBloom(b)))
+		return errors.Wrapf(err, "storing bloom filter for block %d", b.Height)
+	})
+}
+
+// Get returns the bloom filter of the block at the given height.
+// It returns sql.ErrNoRows if there is none.
+func (s *bloomStore) Get(ctx context.Context, height uint64) (bloomFilter, error) {
+	var bits []byte
+	err := s.db.QueryRowContext(ctx, "SELECT bits FROM blooms WHERE height = $1", height).Scan(&bits)
+	return bits, err
+}
+
+func (n *node) bloom(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	height, err := strconv.ParseUint(req.FormValue("height"), 10, 64)
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing height: %s", err)
+		return
+	}
+
+	f, err := n.blooms.Get(ctx, height)
+	if err == sql.ErrNoRows {
+		httpErrf(w, http.StatusNotFound, "no bloom filter for block %d", height)
+		return
+	}
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "getting bloom filter for block %d: %s", height, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(bloomHashesHeader, strconv.Itoa(bloomHashes))
+	_, err = w.Write(f)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "sending response: %s", err)
+	}
+}
+
+const bloomSchema = `
+CREATE TABLE IF NOT EXISTS blooms (
+  height INTEGER NOT NULL PRIMARY KEY,
+  bits BLOB NOT NULL
+);
+`
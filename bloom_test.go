@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/protocol"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txbuilder/standard"
+)
+
+func TestBlockBloom(t *testing.T) {
+	ctx := context.Background()
+
+	b1, err := protocol.NewInitialBlock(nil, 0, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := testIssuance(ctx, t, b1, 10)
+	b2 := testBlock(t, b1, tx)
+
+	f := blockBloom(b2)
+
+	_, pub := testKeys(t)
+	assetID := standard.AssetID(2, 1, []ed25519.PublicKey{pub}, nil)
+	if !f.contains(bc.NewHash(assetID)) {
+		t.Error("filter does not contain asset ID")
+	}
+	if !f.contains(tx.Outputs[0].ID) {
+		t.Error("filter does not contain output ID")
+	}
+
+	var falsePositives int
+	for i := 0; i < 1000; i++ {
+		h := sha256.Sum256([]byte{byte(i), byte(i >> 8)})
+		if f.contains(bc.NewHash(h)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 100 {
+		t.Errorf("%d false positives in 1000 lookups", falsePositives)
+	}
+}
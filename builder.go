@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// submission asks n's builder goroutine to accept a transaction.
+type submission struct {
+	ctx    context.Context
+	tx     *bc.Tx
+	bundle []*bc.Tx // instead of tx, for a bundle
+	key    string   // Idempotency-Key header, if any
+	done   chan submitted
+}
+
+// submitted is the builder goroutine's answer to a submission.
+type submitted struct {
+	status *txStatus // non-nil if the tx was already pending or recently committed
+	height uint64    // in -dev mode, the height of the block committing the tx
+
+	// For a bundle, non-nil if every tx in it was already pending or recently committed.
+	bundleStatus []*txStatus
+
+	// If the tx was rejected,
+	// err says why and code is the HTTP status to report.
+	code int
+	err  error
+}
+
+// runBuilder is n's builder goroutine.
+// It alone touches n.pool, n.txs, n.closed, and n.halted.
+// It accepts submissions,
+// builds a block blockInterval after a transaction arrives in an empty schedule,
+// and runs the functions passed to do,
+// until n.quit is closed.
+func (n *node) runBuilder() {
+	var (
+		timer         *time.Timer
+		timerC        <-chan time.Time // nil when no block is scheduled
+		nextBlockTime time.Time
+	)
+	schedule := func() {
+		nextBlockTime = time.Now().Add(blockInterval())
+		n.infof("starting new block, will commit at %s", nextBlockTime)
+		timer = time.NewTimer(blockInterval())
+		timerC = timer.C
+	}
+
+	for {
+		select {
+		case s := <-n.submissions:
+			next := nextBlockTime
+			switch {
+			case n.dev:
+				next = n.nowTimestamp()
+			case timerC == nil:
+				next = time.Now().Add(blockInterval())
+			}
+			var sp *span
+			s.ctx, sp = startSpan(s.ctx, "pool.accept")
+			res := n.accept(s, next)
+			sp.finish(res.err)
+			s.done <- res
+
+		case <-timerC:
+			timerC = nil
+			if !n.closed && !n.halted && n.pool.len() > 0 {
+				n.buildBlock(context.Background(), nextBlockTime)
+			}
+
+		case f := <-n.calls:
+			f()
+
+		case <-n.quit:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+
+		if timerC == nil && !n.closed && !n.halted && !n.dev && n.pool.len() > 0 {
+			schedule()
+		}
+		atomic.StoreInt64(&n.pending, int64(n.pool.len()))
+	}
+}
+
+// accept adds s.tx to the pool
+// (or in -dev mode commits it immediately),
+// unless it is already pending or recently committed,
+// its timerange excludes next
+// (the expected timestamp of the next block),
+// or n is shutting down.
+// It runs in the builder goroutine.
+func (n *node) accept(s *submission, next time.Time) submitted {
+	if n.closed {
+		return submitted{code: http.StatusServiceUnavailable, err: errors.New("shutting down")}
+	}
+	if n.halted {
+		return submitted{code: http.StatusServiceUnavailable, err: errors.New("halted")}
+	}
+	if s.bundle != nil {
+		return n.acceptBundle(s, next)
+	}
+	if s.key != "" {
+		if txID, ok := n.txs.key(s.key); ok && txID != s.tx.ID {
+			return submitted{code: http.StatusUnprocessableEntity, err: fmt.Errorf("idempotency key %q was used for tx %x", s.key, txID.Bytes())}
+		}
+	}
+	if st := n.status(s.tx.ID); st != nil {
+		return submitted{status: st}
+	}
+	if code, err := checkTxTime(s.tx, bc.Millis(next)); err != nil {
+		return submitted{code: code, err: err}
+	}
+
+	var res submitted
+	if n.dev {
+		height, err := n.commitNow(s.ctx, s.tx)
+		if err != nil {
+			return submitted{code: http.StatusBadRequest, err: errors.Wrapf(err, "committing tx %x", s.tx.ID.Bytes())}
+		}
+		res.height = height
+	} else {
+		n.pool.add(s.tx)
+	}
+	if s.key != "" {
+		n.txs.setKey(s.key, s.tx.ID)
+	}
+	return res
+}
+
+// offer submits tx to n's builder goroutine and waits for its answer.
+// Key is the submission's Idempotency-Key, if any.
+func (n *node) offer(ctx context.Context, tx *bc.Tx, key string) submitted {
+	return n.send(&submission{ctx: ctx, tx: tx, key: key, done: make(chan submitted, 1)})
+}
+
+// send sends s to n's builder goroutine and waits for its answer.
+func (n *node) send(s *submission) submitted {
+	ctx := s.ctx
+	select {
+	case n.submissions <- s:
+	case <-ctx.Done():
+		return submitted{code: http.StatusServiceUnavailable, err: ctx.Err()}
+	}
+	return <-s.done
+}
+
+// do runs f in n's builder goroutine and waits for it to finish.
+func (n *node) do(f func()) {
+	done := make(chan struct{})
+	n.calls <- func() {
+		f()
+		close(done)
+	}
+	<-done
+}
+
+// errBuilderStopped is returned by doContext
+// once the builder goroutine has stopped.
+var errBuilderStopped = errors.New("builder stopped")
+
+// doContext is like do
+// but gives up without running f
+// if ctx is canceled or the builder goroutine has stopped
+// before f starts.
+func (n *node) doContext(ctx context.Context, f func()) error {
+	done := make(chan struct{})
+	select {
+	case n.calls <- func() {
+		f()
+		close(done)
+	}:
+	case <-n.quit:
+		return errBuilderStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-done
+	return nil
+}
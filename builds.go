@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chain/txvm/errors"
+)
+
+// maxBuildRecords is the number of build records kept in the db.
+var maxBuildRecords = 10000
+
+// buildRecord describes one run of buildBlock.
+type buildRecord struct {
+	ID         int64        `json:"id"`
+	StartedMS  uint64       `json:"started_ms"`
+	Height     uint64       `json:"height,omitempty"` // 0 if no block was committed
+	Considered int          `json:"considered"`       // txs in the pool at the start
+	Included   int          `json:"included"`
+	Deferred   int          `json:"deferred"` // txs left in the pool for lack of room
+	Rejected   []rejectedTx `json:"rejected,omitempty"`
+	BuildUS    int64        `json:"build_us"`  // time spent building the block, in microseconds
+	CommitUS   int64        `json:"commit_us"` // time spent committing it
+	Error      string       `json:"error,omitempty"`
+}
+
+// recordBuild stores rec,
+// discarding the oldest records beyond maxBuildRecords.
+// Failures are logged, not returned:
+// the record is a diagnostic aid and must not stop block production.
+func (n *node) recordBuild(ctx context.Context, rec *buildRecord) {
+	rejected, err := json.Marshal(rec.Rejected)
+	if err != nil {
+		n.log.Print(errors.Wrap(err, "marshaling rejected txs"))
+		return
+	}
+	q := "INSERT INTO builds (started_ms, height, considered, included, deferred, rejected, build_us, commit_us, error) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+	res, err := n.db.ExecContext(ctx, q, rec.StartedMS, rec.Height, rec.Considered, rec.Included, rec.Deferred, rejected, rec.BuildUS, rec.CommitUS, rec.Error)
+	if err != nil {
+		n.log.Print(errors.Wrap(err, "recording block build"))
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		n.log.Print(errors.Wrap(err, "getting build record ID"))
+		return
+	}
+	_, err = n.db.ExecContext(ctx, "DELETE FROM builds WHERE id <= $1", id-int64(maxBuildRecords))
+	if err != nil {
+		n.log.Print(errors.Wrap(err, "discarding old build records"))
+	}
+}
+
+// builds returns up to limit build records with IDs less than before
+// (or the latest, if before is 0), newest first.
+func builds(ctx context.Context, db *sql.DB, before int64, limit int) ([]*buildRecord, error) {
+	q := "SELECT id, started_ms, height, considered, included, deferred, rejected, build_us, commit_us, error FROM builds"
+	var args []interface{}
+	if before > 0 {
+		q += " WHERE id < $1"
+		args = append(args, before)
+	}
+	q += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit)
+
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying build records")
+	}
+	defer rows.Close()
+
+	var recs []*buildRecord
+	for rows.Next() {
+		var (
+			rec      buildRecord
+			rejected []byte
+		)
+		err = rows.Scan(&rec.ID, &rec.StartedMS, &rec.Height, &rec.Considered, &rec.Included, &rec.Deferred, &rejected, &rec.BuildUS, &rec.CommitUS, &rec.Error)
+		if err != nil {
+			return nil, errors.Wrap(err, "scanning build record")
+		}
+		err = json.Unmarshal(rejected, &rec.Rejected)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing rejected txs of build %d", rec.ID)
+		}
+		recs = append(recs, &rec)
+	}
+	return recs, errors.Wrap(rows.Err(), "iterating over build records")
+}
+
+// adminBuilds handles /admin/builds?limit=N&before=ID,
+// returning the latest build records
+// (or those before the given ID, for paging),
+// newest first.
+func (n *node) adminBuilds(w http.ResponseWriter, req *http.Request) {
+	if !n.authorize(w, req, "read") {
+		return
+	}
+	limit := 100
+	if s := req.FormValue("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > 1000 {
+			httpErrf(w, http.StatusBadRequest, "limit must be an integer from 1 to 1000")
+			return
+		}
+	}
+	var before int64
+	if s := req.FormValue("before"); s != "" {
+		var err error
+		before, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			httpErrf(w, http.StatusBadRequest, "parsing before: %s", err)
+			return
+		}
+	}
+	recs, err := builds(req.Context(), n.db, before, limit)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "getting build records: %s", err)
+		return
+	}
+	if recs == nil {
+		recs = []*buildRecord{}
+	}
+	respondJSON(w, recs)
+}
+
+func microsSince(t time.Time) int64 {
+	return int64(time.Since(t) / time.Microsecond)
+}
+
+const buildsSchema = `
+CREATE TABLE IF NOT EXISTS builds (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  started_ms INTEGER NOT NULL,
+  height INTEGER NOT NULL,
+  considered INTEGER NOT NULL,
+  included INTEGER NOT NULL,
+  deferred INTEGER NOT NULL,
+  rejected TEXT NOT NULL,
+  build_us INTEGER NOT NULL,
+  commit_us INTEGER NOT NULL,
+  error TEXT NOT NULL
+);
+`
+
+// migrateBuilds adds the builds table (schema version 2).
+func migrateBuilds(dbtx *sql.Tx) error {
+	_, err := dbtx.Exec(buildsSchema)
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBuilds(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.adminTokens = []adminToken{{token: "reader", scopes: map[string]bool{"read": true}}}
+
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
+	n.do(func() {
+		// The second copy fails as a duplicate.
+		n.pool.add(tx)
+		n.pool.add(tx)
+		n.buildBlock(ctx, time.Now().Add(time.Second))
+		n.buildBlock(ctx, time.Now().Add(2*time.Second))
+	})
+
+	req := httptest.NewRequest("GET", "/admin/builds?limit=10", nil)
+	req.Header.Set("Authorization", "Bearer reader")
+	rec := httptest.NewRecorder()
+	n.adminBuilds(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got []*buildRecord
+	err = json.NewDecoder(rec.Body).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d build records, want 2", len(got))
+	}
+
+	// Newest first: an empty build, then the one that committed block 2.
+	if got[0].Height != 0 || got[0].Considered != 0 {
+		t.Errorf("got latest build %+v, want an empty one", got[0])
+	}
+	b := got[1]
+	if b.Height != 2 || b.Considered != 2 || b.Included != 1 || b.Deferred != 0 || len(b.Rejected) != 1 {
+		t.Errorf("got build %+v, want block 2 with 2 considered, 1 included, and 1 rejected", b)
+	}
+
+	page, err := builds(ctx, db, got[0].ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].ID != b.ID {
+		t.Errorf("got %d records before build %d, want only build %d", len(page), got[0].ID, b.ID)
+	}
+}
+
+func TestMigrateBuilds(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	// A version-1 db.
+	_, err := db.Exec("CREATE TABLE blocks (height INTEGER NOT NULL PRIMARY KEY, hash BLOB NOT NULL UNIQUE, bits BLOB NOT NULL); CREATE TABLE snapshots (height INTEGER NOT NULL PRIMARY KEY, bits BLOB NOT NULL); PRAGMA user_version = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = initSchema(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := dbSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != schemaVersion {
+		t.Errorf("got schema version %d, want %d", version, schemaVersion)
+	}
+	_, err = db.Exec("SELECT COUNT(*) FROM builds")
+	if err != nil {
+		t.Errorf("builds table missing after migration: %s", err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/golang/protobuf/proto"
+)
+
+// maxBundleTxs bounds the size of a /submit/bundle request body
+// when there is no -max-block-bytes:
+// it may hold this many txs of -max-tx-bytes.
+const maxBundleTxs = 16
+
+// submitBundle handles /submit/bundle,
+// which accepts a JSON array of transactions
+// (each in the JSON form of a /submit request body)
+// that must all be included in the same block or none at all.
+// The bundle is validated as a whole against the pending state
+// when a block is built:
+// if any of its transactions fails,
+// all of them are dropped.
+func (n *node) submitBundle(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		httpErrf(w, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+		return
+	}
+	if n.readonly {
+		httpErrf(w, http.StatusForbidden, "read-only node does not accept transactions")
+		return
+	}
+
+	var maxTxs, maxBytes int
+	n.do(func() {
+		maxTxs, maxBytes = n.blockBuilder().MaxBlockTxs, n.pool.MaxBlockBytes
+	})
+
+	// A bundle can be no bigger than a block,
+	// or without -max-block-bytes,
+	// than maxBundleTxs txs of the largest size.
+	maxBody := jsonTxLimit(maxBytes)
+	if maxBytes <= 0 {
+		maxBody = int64(maxBundleTxs) * jsonTxLimit(n.maxTxBytes)
+	}
+	if !limitBody(w, req, maxBody) {
+		return
+	}
+	var subs []jsonSubmission
+	err := json.NewDecoder(req.Body).Decode(&subs)
+	if err != nil {
+		readErrf(w, err, maxBody)
+		return
+	}
+	if len(subs) == 0 {
+		httpErrf(w, http.StatusBadRequest, "empty bundle")
+		return
+	}
+	if len(subs) > maxTxs {
+		httpErrf(w, http.StatusBadRequest, "bundle has %d txs, more than the %d allowed in a block", len(subs), maxTxs)
+		return
+	}
+
+	var (
+		txs  []*bc.Tx
+		size int
+		seen = make(map[bc.Hash]bool)
+	)
+	for i, sub := range subs {
+		bits, err := sub.rawTx()
+		if err != nil {
+			httpErrf(w, http.StatusBadRequest, "parsing bundle tx %d: %s", i, err)
+			return
+		}
+		var rawTx bc.RawTx
+		err = proto.Unmarshal(bits, &rawTx)
+		if err != nil {
+			httpErrf(w, http.StatusBadRequest, "parsing bundle tx %d: %s", i, err)
+			return
+		}
+		if code, err := n.checkTxLimits(req.Context(), rawTx.Version, len(bits), rawTx.Runlimit); err != nil {
+			httpErrf(w, code, "bundle tx %d: %s", i, err)
+			return
+		}
+		tx, err := bc.NewTx(rawTx.Program, rawTx.Version, rawTx.Runlimit)
+		if err != nil {
+			httpErrf(w, http.StatusBadRequest, "building bundle tx %d: %s", i, err)
+			return
+		}
+		if seen[tx.ID] {
+			httpErrf(w, http.StatusBadRequest, "tx %x appears more than once in the bundle", tx.ID.Bytes())
+			return
+		}
+		seen[tx.ID] = true
+		size += len(bits)
+		txs = append(txs, tx)
+	}
+	if maxBytes > 0 && size > maxBytes {
+		httpErrf(w, http.StatusBadRequest, "bundle is %d bytes, more than the %d allowed in a block", size, maxBytes)
+		return
+	}
+
+	for _, tx := range txs {
+		err := n.admit(req.Context(), tx)
+		if _, ok := err.(errNotAdmitted); ok {
+			httpErrf(w, http.StatusForbidden, "tx %x %s", tx.ID.Bytes(), err)
+			return
+		}
+		if err != nil {
+			httpErrf(w, http.StatusInternalServerError, "checking admission of tx %x: %s", tx.ID.Bytes(), err)
+			return
+		}
+	}
+
+	res := n.offerBundle(req.Context(), txs, req.Header.Get("Idempotency-Key"))
+	switch {
+	case res.err != nil:
+		httpErrf(w, res.code, "%s", res.err)
+	case res.bundleStatus != nil:
+		respondJSON(w, res.bundleStatus)
+	case n.dev:
+		n.infof("committed bundle of %d txs in block %d", len(txs), res.height)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		n.infof("added bundle of %d txs to the pool", len(txs))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// offerBundle submits a bundle to n's builder goroutine and waits for its answer.
+// Key is the submission's Idempotency-Key, if any;
+// it is bound to the ID of the bundle's first tx.
+func (n *node) offerBundle(ctx context.Context, txs []*bc.Tx, key string) submitted {
+	return n.send(&submission{ctx: ctx, bundle: txs, key: key, done: make(chan submitted, 1)})
+}
+
+// acceptBundle is accept for a bundle.
+// A bundle whose txs are all already pending or recently committed
+// gets their statuses;
+// one with only some of them is rejected.
+// It runs in the builder goroutine.
+func (n *node) acceptBundle(s *submission, next time.Time) submitted {
+	first := s.bundle[0]
+	if s.key != "" {
+		if txID, ok := n.txs.key(s.key); ok && txID != first.ID {
+			return submitted{code: http.StatusUnprocessableEntity, err: fmt.Errorf("idempotency key %q was used for tx %x", s.key, txID.Bytes())}
+		}
+	}
+
+	var statuses []*txStatus
+	for _, tx := range s.bundle {
+		if st := n.status(tx.ID); st != nil {
+			statuses = append(statuses, st)
+		}
+	}
+	switch len(statuses) {
+	case 0:
+	case len(s.bundle):
+		return submitted{bundleStatus: statuses}
+	default:
+		return submitted{code: http.StatusConflict, err: fmt.Errorf("tx %s is already %s outside this bundle", statuses[0].TxID, statuses[0].Status)}
+	}
+	for _, tx := range s.bundle {
+		if code, err := checkTxTime(tx, bc.Millis(next)); err != nil {
+			return submitted{code: code, err: err}
+		}
+	}
+
+	var res submitted
+	if n.dev {
+		height, err := n.commitNow(s.ctx, s.bundle...)
+		if err != nil {
+			return submitted{code: http.StatusBadRequest, err: errors.Wrap(err, "committing bundle")}
+		}
+		res.height = height
+	} else {
+		n.pool.add(s.bundle...)
+	}
+	if s.key != "" {
+		n.txs.setKey(s.key, first.ID)
+	}
+	return res
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestSubmitBundle(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx1 := testIssuance(ctx, t, n.initialBlock, 10)
+	tx2 := testIssuance(ctx, t, n.initialBlock, 20)
+	tx3 := testIssuance(ctx, t, n.initialBlock, 30)
+
+	submit := func(txs ...*bc.Tx) *httptest.ResponseRecorder {
+		var subs []jsonSubmission
+		for _, tx := range txs {
+			bits, err := proto.Marshal(&tx.RawTx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subs = append(subs, jsonSubmission{Hex: hex.EncodeToString(bits)})
+		}
+		body, err := json.Marshal(subs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		n.submitBundle(rec, httptest.NewRequest("POST", "/submit/bundle", bytes.NewReader(body)))
+		return rec
+	}
+
+	n.enqueue(httptest.NewRecorder(), httptest.NewRequest("POST", "/submit", nil), tx1)
+
+	if rec := submit(); rec.Code != http.StatusBadRequest {
+		t.Errorf("got status code %d for empty bundle, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := submit(tx2, tx2); rec.Code != http.StatusBadRequest {
+		t.Errorf("got status code %d for bundle with a repeated tx, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := submit(tx2, tx1); rec.Code != http.StatusConflict {
+		t.Errorf("got status code %d for bundle with a pending tx, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := submit(tx2, tx3); rec.Code != http.StatusNoContent {
+		t.Fatalf("got status code %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if pending := atomic.LoadInt64(&n.pending); pending != 3 {
+		t.Errorf("got pool size %d, want 3", pending)
+	}
+
+	var ub *bc.UnsignedBlock
+	n.do(func() {
+		ub = n.buildBlock(ctx, time.Now().Add(time.Second))
+	})
+	if ub == nil || len(ub.Transactions) != 3 {
+		t.Fatalf("got block %v, want one with 3 txs", ub)
+	}
+
+	rec := submit(tx2, tx3)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status code %d resubmitting committed bundle, want %d", rec.Code, http.StatusOK)
+	}
+	var statuses []txStatus
+	err = json.NewDecoder(rec.Body).Decode(&statuses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[0].Status != "committed" || statuses[1].Status != "committed" {
+		t.Errorf("got statuses %+v, want two committed", statuses)
+	}
+}
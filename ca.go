@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chain/txvm/errors"
+)
+
+// Files in a CA directory.
+const (
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca.key"
+)
+
+// caCmd implements "txvmbcd ca init|issue|bundle -dir DIR ...",
+// a minimal certificate authority for the mutual TLS
+// of a small federation of servers and clients.
+func caCmd(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: txvmbcd ca init|issue|bundle -dir DIR ...")
+	}
+	fs := flag.NewFlagSet("ca "+args[0], flag.ExitOnError)
+	dir := fs.String("dir", "", "directory holding the CA's certificate and key")
+
+	switch args[0] {
+	case "init":
+		name := fs.String("name", "txvmbcd CA", "name of the CA")
+		days := fs.Int("days", 3650, "validity period of the CA certificate in days")
+		fs.Parse(args[1:])
+		if *dir == "" {
+			return errors.New("ca init requires -dir")
+		}
+		return caInit(*dir, *name, *days)
+
+	case "issue":
+		name := fs.String("name", "", "name of the member (the certificate's common name)")
+		hosts := fs.String("host", "", "comma-separated host names and IP addresses, to issue a server certificate instead of a client one")
+		days := fs.Int("days", 365, "validity period of the certificate in days")
+		fs.Parse(args[1:])
+		if *dir == "" || *name == "" {
+			return errors.New("ca issue requires -dir and -name")
+		}
+		var hostList []string
+		if *hosts != "" {
+			hostList = strings.Split(*hosts, ",")
+		}
+		return caIssue(*dir, *name, hostList, *days)
+
+	case "bundle":
+		fs.Parse(args[1:])
+		if *dir == "" {
+			return errors.New("ca bundle requires -dir")
+		}
+		bits, err := ioutil.ReadFile(filepath.Join(*dir, caCertFile))
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(bits)
+		return err
+	}
+	return fmt.Errorf("unknown ca command %q", args[0])
+}
+
+// caInit creates a self-signed CA certificate and its key in dir.
+func caInit(dir, name string, days int) error {
+	if _, err := os.Stat(filepath.Join(dir, caKeyFile)); err == nil {
+		return fmt.Errorf("%s already holds a CA", dir)
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := certTemplate(name, days)
+	if err != nil {
+		return err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "generating CA key")
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return errors.Wrap(err, "creating CA certificate")
+	}
+	return writeCertAndKey(dir, "ca", der, key)
+}
+
+// caIssue issues a certificate signed by the CA in dir,
+// writing it and its key to NAME.pem and NAME.key in dir.
+// With hosts, it is a server certificate for those hosts;
+// otherwise it is a client certificate.
+func caIssue(dir, name string, hosts []string, days int) error {
+	if strings.ContainsAny(name, `/\`) || name == "ca" {
+		return fmt.Errorf("bad member name %q", name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, name+".pem")); err == nil {
+		return fmt.Errorf("a certificate for %s already exists", name)
+	}
+
+	ca, err := tls.LoadX509KeyPair(filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile))
+	if err != nil {
+		return errors.Wrap(err, "loading CA")
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return errors.Wrap(err, "parsing CA certificate")
+	}
+
+	tmpl, err := certTemplate(name, days)
+	if err != nil {
+		return err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	if len(hosts) > 0 {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		}
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "generating key")
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return errors.Wrap(err, "creating certificate")
+	}
+	return writeCertAndKey(dir, name, der, key)
+}
+
+func certTemplate(name string, days int) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "generating serial number")
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(0, 0, days),
+	}, nil
+}
+
+func writeCertAndKey(dir, name string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return errors.Wrap(err, "marshaling key")
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// serverTLSConfig loads the server's certificate and key.
+// If clientCAFile is not empty,
+// clients must present certificates signed by a CA in that file.
+func serverTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading TLS certificate")
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAFile != "" {
+		bundle, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading client CA bundle")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates in %s", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
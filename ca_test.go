@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txvmbcdca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = caInit(dir, "test CA", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = caInit(dir, "test CA", 1); err == nil {
+		t.Error("got no error reinitializing the CA")
+	}
+	err = caIssue(dir, "server", []string{"127.0.0.1"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = caIssue(dir, "member", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tlsConfig, err := serverTLSConfig(path("server.pem"), path("server.key"), path(caCertFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	caBundle, err := ioutil.ReadFile(path(caCertFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caBundle)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+
+	member, err := tls.LoadX509KeyPair(path("member.pem"), path("member.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client(member).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "member" {
+		t.Errorf("got client name %q, want member", body)
+	}
+
+	if resp, err = client().Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Error("got no error connecting without a client certificate")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/golang/protobuf/proto"
+)
+
+// capacityWindow is the default number of recent blocks summarized by /stats/capacity,
+// and maxCapacityWindow the most a caller may request.
+const (
+	capacityWindow    = 100
+	maxCapacityWindow = 1000
+)
+
+// capacityVars publishes each chain's capacityStats (over the default window)
+// at /debug/vars, keyed by chain ID.
+var capacityVars = expvar.NewMap("capacity")
+
+// blockCapacity describes how full one block is.
+type blockCapacity struct {
+	Height      uint64 `json:"height"`
+	TimestampMS uint64 `json:"timestamp_ms"`
+	Txs         int    `json:"txs"`
+	Bytes       int    `json:"bytes"`
+	Runlimit    int64  `json:"runlimit"`
+}
+
+// capacityStats summarizes the fullness of recent blocks
+// relative to the limits in force.
+// The fullness values are fractions between 0 and 1;
+// ByteFullness is omitted when there is no -max-block-bytes limit.
+type capacityStats struct {
+	Blocks        int `json:"blocks"`
+	MaxBlockTxs   int `json:"max_block_txs"`
+	MaxBlockBytes int `json:"max_block_bytes"`
+	PendingTxs    int `json:"pending_txs"`
+
+	MeanTxs          float64  `json:"mean_txs"`
+	MeanTxFullness   float64  `json:"mean_tx_fullness"`
+	MaxTxFullness    float64  `json:"max_tx_fullness"`
+	MeanByteFullness *float64 `json:"mean_byte_fullness,omitempty"`
+	MaxByteFullness  *float64 `json:"max_byte_fullness,omitempty"`
+	MeanRunlimit     float64  `json:"mean_runlimit"`
+	MaxRunlimit      int64    `json:"max_runlimit"`
+
+	Recent []blockCapacity `json:"recent,omitempty"`
+}
+
+// capacity computes capacityStats for the most recent window blocks
+// (not counting the initial block).
+func (n *node) capacity(ctx context.Context, window int) (*capacityStats, error) {
+	stats := &capacityStats{
+		PendingTxs: int(atomic.LoadInt64(&n.pending)),
+	}
+	err := n.doContext(ctx, func() {
+		stats.MaxBlockTxs = n.blockBuilder().MaxBlockTxs
+		stats.MaxBlockBytes = n.pool.MaxBlockBytes
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting block limits")
+	}
+
+	var from uint64 = 2
+	if height := n.store.FinalHeight(); height > uint64(window) {
+		from = height - uint64(window) + 1
+	}
+
+	rows, err := n.db.QueryContext(ctx, "SELECT bits FROM blocks WHERE height >= $1 ORDER BY height", from)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying recent blocks")
+	}
+	defer rows.Close()
+
+	var totalTxs, totalRunlimit int64
+	for rows.Next() {
+		var bits []byte
+		err = rows.Scan(&bits)
+		if err != nil {
+			return nil, errors.Wrap(err, "scanning block")
+		}
+
+		// Parsing the raw block is much cheaper than bc.Block.FromBytes,
+		// which runs every transaction.
+		var rb bc.RawBlock
+		err = proto.Unmarshal(bits, &rb)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing block")
+		}
+		bcap := blockCapacity{
+			Height:      rb.Header.Height,
+			TimestampMS: rb.Header.TimestampMs,
+			Txs:         len(rb.Transactions),
+			Runlimit:    rb.Header.Runlimit,
+		}
+		for _, tx := range rb.Transactions {
+			bcap.Bytes += proto.Size(tx)
+		}
+		stats.Recent = append(stats.Recent, bcap)
+
+		totalTxs += int64(bcap.Txs)
+		totalRunlimit += bcap.Runlimit
+		if bcap.Runlimit > stats.MaxRunlimit {
+			stats.MaxRunlimit = bcap.Runlimit
+		}
+		if f := float64(bcap.Txs) / float64(stats.MaxBlockTxs); f > stats.MaxTxFullness {
+			stats.MaxTxFullness = f
+		}
+		if stats.MaxBlockBytes > 0 {
+			f := float64(bcap.Bytes) / float64(stats.MaxBlockBytes)
+			if stats.MeanByteFullness == nil {
+				stats.MeanByteFullness, stats.MaxByteFullness = new(float64), new(float64)
+			}
+			*stats.MeanByteFullness += f
+			if f > *stats.MaxByteFullness {
+				*stats.MaxByteFullness = f
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating over blocks")
+	}
+
+	stats.Blocks = len(stats.Recent)
+	if stats.Blocks > 0 {
+		stats.MeanTxs = float64(totalTxs) / float64(stats.Blocks)
+		stats.MeanTxFullness = stats.MeanTxs / float64(stats.MaxBlockTxs)
+		stats.MeanRunlimit = float64(totalRunlimit) / float64(stats.Blocks)
+		if stats.MeanByteFullness != nil {
+			*stats.MeanByteFullness /= float64(stats.Blocks)
+		}
+	}
+	return stats, nil
+}
+
+// statsCapacity handles /stats/capacity,
+// which reports how full recent blocks have been
+// so that clients can adjust their fees to congestion.
+// The optional "blocks" parameter sets the number of recent blocks to summarize.
+func (n *node) statsCapacity(w http.ResponseWriter, req *http.Request) {
+	window := capacityWindow
+	if s := req.FormValue("blocks"); s != "" {
+		var err error
+		window, err = strconv.Atoi(s)
+		if err != nil || window < 1 || window > maxCapacityWindow {
+			httpErrf(w, http.StatusBadRequest, "blocks must be an integer from 1 to %d", maxCapacityWindow)
+			return
+		}
+	}
+	stats, err := n.capacity(req.Context(), window)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "computing capacity stats: %s", err)
+		return
+	}
+	respondJSON(w, stats)
+}
+
+// publishCapacity adds n's capacity stats to capacityVars.
+// They are recomputed after each block until ctx is canceled,
+// so that scrapes of /debug/vars only copy the latest result
+// (with a current PendingTxs).
+func (n *node) publishCapacity(ctx context.Context) {
+	var latest atomic.Value // *capacityStats
+	capacityVars.Set(n.name, expvar.Func(func() interface{} {
+		stats, _ := latest.Load().(*capacityStats)
+		if stats == nil {
+			return nil
+		}
+		cp := *stats
+		cp.PendingTxs = int(atomic.LoadInt64(&n.pending))
+		return cp
+	}))
+
+	go func() {
+		for {
+			height := n.store.FinalHeight()
+			stats, err := n.capacity(ctx, capacityWindow)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				n.log.Printf("computing capacity stats: %s", err)
+			} else {
+				stats.Recent = nil
+				latest.Store(stats)
+			}
+			select {
+			case <-n.store.BlockWaiter(ctx, height+1):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatsCapacity(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(0); i < 3; i++ {
+		n.do(func() {
+			_, err = n.commitNow(ctx, testIssuance(ctx, t, n.initialBlock, 10+i))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	n.pool.MaxBlockBytes = 100000
+
+	rec := httptest.NewRecorder()
+	n.statsCapacity(rec, httptest.NewRequest("GET", "/stats/capacity?blocks=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got capacityStats
+	err = json.NewDecoder(rec.Body).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Blocks != 2 || len(got.Recent) != 2 {
+		t.Fatalf("got %d blocks (%d recent), want 2", got.Blocks, len(got.Recent))
+	}
+	if got.Recent[0].Height != 3 || got.Recent[1].Height != 4 {
+		t.Errorf("got heights %d and %d, want 3 and 4", got.Recent[0].Height, got.Recent[1].Height)
+	}
+	if got.MeanTxs != 1 {
+		t.Errorf("got mean txs %f, want 1", got.MeanTxs)
+	}
+	if want := 1 / float64(got.MaxBlockTxs); got.MaxTxFullness != want {
+		t.Errorf("got max tx fullness %f, want %f", got.MaxTxFullness, want)
+	}
+	if got.Recent[0].Bytes == 0 || got.Recent[0].Runlimit == 0 {
+		t.Errorf("got block %+v, want nonzero bytes and runlimit", got.Recent[0])
+	}
+	if got.MeanByteFullness == nil || *got.MeanByteFullness <= 0 {
+		t.Errorf("got mean byte fullness %v, want a positive value", got.MeanByteFullness)
+	}
+
+	rec = httptest.NewRecorder()
+	n.statsCapacity(rec, httptest.NewRequest("GET", "/stats/capacity?blocks=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for blocks=0, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPublishCapacity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "publish-capacity-test", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.publishCapacity(ctx)
+
+	blocks := func() int {
+		var stats capacityStats
+		json.Unmarshal([]byte(capacityVars.Get(n.name).String()), &stats)
+		return stats.Blocks
+	}
+
+	n.do(func() {
+		_, err = n.commitNow(ctx, testIssuance(ctx, t, n.initialBlock, 10))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for blocks() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d blocks in the published stats, want 1", blocks())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Once the builder stops,
+	// scrapes still return the last stats and the endpoint does not hang.
+	close(n.quit)
+	if got := blocks(); got != 1 {
+		t.Errorf("after stopping, got %d blocks in the published stats, want 1", got)
+	}
+	rec := httptest.NewRecorder()
+	n.statsCapacity(rec, httptest.NewRequest("GET", "/stats/capacity", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("after stopping, got status %d from /stats/capacity, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// certExpiry describes the validity period of a TLS certificate the server uses.
+type certExpiry struct {
+	File        string  `json:"file"`
+	Subject     string  `json:"subject"`
+	NotBeforeMS uint64  `json:"not_before_ms"`
+	NotAfterMS  uint64  `json:"not_after_ms"`
+	DaysLeft    float64 `json:"days_left"`
+}
+
+// loadCertExpiries reads the certificates in the PEM file named by file.
+func loadCertExpiries(file string) ([]*certExpiry, error) {
+	bits, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", file)
+	}
+	var res []*certExpiry
+	for {
+		var block *pem.Block
+		block, bits = pem.Decode(bits)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing certificate in %s", file)
+		}
+		res = append(res, &certExpiry{
+			File:        file,
+			Subject:     cert.Subject.String(),
+			NotBeforeMS: bc.Millis(cert.NotBefore),
+			NotAfterMS:  bc.Millis(cert.NotAfter),
+		})
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no certificates in %s", file)
+	}
+	return res, nil
+}
+
+// expiring sets each cert's DaysLeft as of now
+// and returns those with less than warn left.
+func expiring(certs []*certExpiry, now time.Time, warn time.Duration) []*certExpiry {
+	var res []*certExpiry
+	for _, c := range certs {
+		left := bc.FromMillis(c.NotAfterMS).Sub(now)
+		c.DaysLeft = left.Hours() / 24
+		if left < warn {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// watchCertExpiry publishes certs, with their days left, under tls_certs at /debug/vars,
+// and logs a warning for each one expiring within warn,
+// at startup and then daily until ctx is canceled,
+// so that certificates are rotated before clients start refusing them.
+func watchCertExpiry(ctx context.Context, certs []*certExpiry, warn time.Duration) {
+	expvar.Publish("tls_certs", expvar.Func(func() interface{} {
+		res := make([]certExpiry, 0, len(certs))
+		for _, c := range certs {
+			c2 := *c
+			expiring([]*certExpiry{&c2}, time.Now(), 0)
+			res = append(res, c2)
+		}
+		return res
+	}))
+
+	check := func() {
+		for _, c := range expiring(certs, time.Now(), warn) {
+			if c.DaysLeft < 0 {
+				log.Printf("WARNING: TLS certificate %q in %s expired at %s", c.Subject, c.File, bc.FromMillis(c.NotAfterMS).UTC().Format(time.RFC3339))
+			} else {
+				log.Printf("WARNING: TLS certificate %q in %s expires in %.1f days, at %s", c.Subject, c.File, c.DaysLeft, bc.FromMillis(c.NotAfterMS).UTC().Format(time.RFC3339))
+			}
+		}
+	}
+	check()
+
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+}
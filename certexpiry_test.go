@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txvmbcdcerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = caInit(dir, "test CA", 365)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = caIssue(dir, "server", []string{"127.0.0.1"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := loadCertExpiries(filepath.Join(dir, "server.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCerts, err := loadCertExpiries(filepath.Join(dir, caCertFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs = append(certs, caCerts...)
+	if len(certs) != 2 {
+		t.Fatalf("got %d certificates, want 2", len(certs))
+	}
+
+	now := time.Now()
+	day := 24 * time.Hour
+	cases := []struct {
+		now  time.Time
+		warn time.Duration
+		want int
+	}{
+		{now, 5 * day, 0},
+		{now, 30 * day, 1},
+		{now, 400 * day, 2},
+		{now.Add(20 * day), 0, 1}, // the server certificate has expired
+	}
+	for _, c := range cases {
+		got := expiring(certs, c.now, c.warn)
+		if len(got) != c.want {
+			t.Errorf("at %s with warning %s, got %d expiring certificates, want %d", c.now, c.warn, len(got), c.want)
+		}
+	}
+
+	if _, err := loadCertExpiries(filepath.Join(dir, "server.key")); err == nil {
+		t.Error("got no error loading certificates from a key file")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/gomodule/redigo/redis"
+)
+
+// serverConfig holds the settings that -check-config verifies
+// beyond what flag parsing already checks.
+type serverConfig struct {
+	addr        string
+	dbfiles     map[string]string // chain ID ("" for -db) -> db file
+	redisAddr   string
+	admitScript string
+	blockScript string
+	backupDir   string
+}
+
+// checkConfig verifies cfg without changing anything or binding any listeners:
+// that the listen address resolves
+// (or, for a Unix domain socket, that its directory exists),
+// that each existing db has a compatible schema and a valid initial block,
+// that no two chains share a db,
+// that the Redis server (if any) responds,
+// that the admission and block scripts (if any) are executable,
+// and that the backup directory (if any) can be written.
+// It reports on each item to w and returns the number of problems found.
+func checkConfig(ctx context.Context, cfg serverConfig, w io.Writer) int {
+	var problems int
+	check := func(what string, err error) {
+		if err != nil {
+			problems++
+			fmt.Fprintf(w, "FAIL %s: %s\n", what, err)
+		} else {
+			fmt.Fprintf(w, "ok   %s\n", what)
+		}
+	}
+
+	if path := strings.TrimPrefix(cfg.addr, unixPrefix); path != cfg.addr {
+		_, err := os.Stat(filepath.Dir(path))
+		check("listen address "+cfg.addr, err)
+	} else {
+		_, err := net.ResolveTCPAddr("tcp", cfg.addr)
+		check("listen address "+cfg.addr, err)
+	}
+
+	var ids []string
+	for id := range cfg.dbfiles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	seen := make(map[string]string)
+	for _, id := range ids {
+		dbfile := cfg.dbfiles[id]
+		what := "chain " + id
+		if id == "" {
+			what = "default chain"
+		}
+		if dbfile == "" {
+			check(what+": temporary db", nil)
+			continue
+		}
+		if other, ok := seen[dbfile]; ok {
+			check(what, fmt.Errorf("db %s is also used by chain %q", dbfile, other))
+			continue
+		}
+		seen[dbfile] = id
+		if _, err := os.Stat(dbfile); os.IsNotExist(err) {
+			check(what+": db "+dbfile+" will be created", nil)
+			continue
+		}
+		info, err := checkDB(ctx, dbfile)
+		check(what+": db "+dbfile+info, err)
+	}
+
+	if cfg.redisAddr != "" {
+		check("redis "+cfg.redisAddr, checkRedis(ctx, cfg.redisAddr))
+	}
+
+	if cfg.backupDir != "" {
+		info, err := checkBackupDir(cfg.backupDir)
+		check("backup directory "+cfg.backupDir+info, err)
+	}
+
+	for _, script := range []struct{ what, filename string }{
+		{"admission script", cfg.admitScript},
+		{"block script", cfg.blockScript},
+	} {
+		if script.filename == "" {
+			continue
+		}
+		info, err := os.Stat(script.filename)
+		if err == nil && (info.IsDir() || info.Mode()&0111 == 0) {
+			err = errors.New("not an executable file")
+		}
+		check(script.what+" "+script.filename, err)
+	}
+
+	return problems
+}
+
+// checkDB opens an existing db read-only
+// and checks its schema version and initial block.
+// On success it returns a description of the chain it holds.
+func checkDB(ctx context.Context, dbfile string) (string, error) {
+	db, err := sql.Open("sqlite3", "file:"+dbfile+"?mode=ro")
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	version, err := dbSchemaVersion(db)
+	if err != nil {
+		return "", err
+	}
+	if version > schemaVersion {
+		return "", fmt.Errorf("db schema version %d is newer than this program's version %d", version, schemaVersion)
+	}
+
+	var (
+		hash, bits []byte
+		height     uint64
+	)
+	err = db.QueryRowContext(ctx, "SELECT hash, bits FROM blocks WHERE height = 1").Scan(&hash, &bits)
+	if err != nil {
+		return "", errors.Wrap(err, "reading initial block")
+	}
+	b := new(bc.Block)
+	err = b.FromBytes(bits)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing initial block")
+	}
+	if b.Height != 1 || b.PreviousBlockId != nil {
+		return "", errors.New("block 1 is not an initial block")
+	}
+	if id := b.Hash().Bytes(); !bytes.Equal(id, hash) {
+		return "", fmt.Errorf("initial block is stored under hash %x but has hash %x", hash, id)
+	}
+	err = db.QueryRowContext(ctx, "SELECT MAX(height) FROM blocks").Scan(&height)
+	if err != nil {
+		return "", errors.Wrap(err, "getting height")
+	}
+	return fmt.Sprintf(" (schema version %d, initial block ID %x, height %d)", version, hash, height), nil
+}
+
+func checkRedis(ctx context.Context, addr string) error {
+	conn, err := redis.DialContext(ctx, "tcp", addr,
+		redis.DialConnectTimeout(5*time.Second),
+		redis.DialReadTimeout(5*time.Second),
+		redis.DialWriteTimeout(5*time.Second),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("PING")
+	return err
+}
+
+// checkBackupDir checks that dir is a writable directory
+// or, if it does not exist yet,
+// that its parent is one in which it can be created.
+// Writability is tested by creating and removing a temporary file.
+func checkBackupDir(dir string) (string, error) {
+	target, info := dir, ""
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		target, info = filepath.Dir(dir), " will be created"
+	}
+	fi, err := os.Stat(target)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", target)
+	}
+	f, err := ioutil.TempFile(target, ".txvmbcd-check")
+	if err != nil {
+		return "", errors.Wrapf(err, "writing in %s", target)
+	}
+	f.Close()
+	return info, os.Remove(f.Name())
+}
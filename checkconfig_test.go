@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "txvmbcdcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	good := filepath.Join(dir, "good.db")
+	db, err := sql.Open("sqlite3", good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newBlockStore(db, nil)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bad := filepath.Join(dir, "bad.db")
+	err = ioutil.WriteFile(bad, []byte("not a database"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	problems := checkConfig(ctx, serverConfig{
+		addr: "localhost:0",
+		dbfiles: map[string]string{
+			"":    good,
+			"new": filepath.Join(dir, "new.db"),
+		},
+		backupDir: filepath.Join(dir, "backups"),
+	}, buf)
+	if problems != 0 {
+		t.Errorf("got %d problems in a good config, want 0:\n%s", problems, buf)
+	}
+	if !strings.Contains(buf.String(), "height 1") {
+		t.Errorf("got report:\n%s\nwant it to describe %s", buf, good)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.db")); !os.IsNotExist(err) {
+		t.Error("checkConfig created a db")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "backups")); !os.IsNotExist(err) {
+		t.Error("checkConfig created the backup directory")
+	}
+
+	buf.Reset()
+	problems = checkConfig(ctx, serverConfig{
+		addr: "localhost:0",
+		dbfiles: map[string]string{
+			"":  good,
+			"a": good,
+			"b": bad,
+		},
+		admitScript: bad,
+		blockScript: bad,
+		backupDir:   filepath.Join(bad, "backups"),
+	}, buf)
+	if problems != 5 {
+		t.Errorf("got %d problems in a bad config, want 5:\n%s", problems, buf)
+	}
+}
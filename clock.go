@@ -0,0 +1,49 @@
+package main
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// clockSkewVars counts, for each chain (keyed by chain ID),
+// the blocks whose timestamp had to be moved forward
+// because the system clock was behind the previous block's timestamp.
+// It is published at /debug/vars.
+var clockSkewVars = expvar.NewMap("clock_skew")
+
+// blockTimestamp returns the timestamp to use for the block after st's latest:
+// t, or if that is not later than the latest block's timestamp,
+// 1ms after it,
+// so that the new block satisfies the protocol's rule
+// that block timestamps strictly increase.
+func (n *node) blockTimestamp(st *bc.BlockHeader, t time.Time) time.Time {
+	prevMS := n.prevTimestampMS(st)
+	if bc.Millis(t) > prevMS {
+		return t
+	}
+	return bc.FromMillis(prevMS + 1)
+}
+
+// checkClock logs and counts in clockSkewVars
+// a system clock that is behind the timestamp of st's latest block.
+// That means the clock has stepped backward
+// (or another server with a faster clock built that block).
+// It compares the current time, not the new block's timestamp,
+// which may be scheduled in the future or already moved forward by blockTimestamp.
+func (n *node) checkClock(st *bc.BlockHeader) {
+	prevMS := n.prevTimestampMS(st)
+	now := time.Now()
+	if ms := bc.Millis(now); ms < prevMS {
+		n.log.Printf("clock skew: system clock %s is %s behind the latest block's timestamp", now.Format(time.RFC3339Nano), time.Duration(prevMS-ms)*time.Millisecond)
+		clockSkewVars.Add(n.name, 1)
+	}
+}
+
+func (n *node) prevTimestampMS(st *bc.BlockHeader) uint64 {
+	if st != nil {
+		return st.TimestampMs
+	}
+	return n.initialBlock.TimestampMs
+}
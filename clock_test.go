@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+func TestClockSkew(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "clock-skew-test", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx1 := testIssuance(ctx, t, n.initialBlock, 10)
+	tx2 := testIssuance(ctx, t, n.initialBlock, 20)
+	tx3 := testIssuance(ctx, t, n.initialBlock, 30)
+
+	skew := func() int64 {
+		if v, ok := clockSkewVars.Get("clock-skew-test").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	// The counter is global and outlives this test (e.g. with -count),
+	// so count from its value now.
+	base := skew()
+
+	var b2, b3 *bc.UnsignedBlock
+	n.do(func() {
+		// Block 2 is stamped 10s ahead,
+		// so the clock appears to step back 10s after it.
+		n.pool.add(tx1)
+		b2 = n.buildBlock(ctx, time.Now().Add(10*time.Second))
+
+		// Block 3 is scheduled 1s from now, still before block 2.
+		n.pool.add(tx2)
+		b3 = n.buildBlock(ctx, time.Now().Add(time.Second))
+	})
+	if b2 == nil || b3 == nil {
+		t.Fatal("block not built")
+	}
+	if b3.TimestampMs != b2.TimestampMs+1 {
+		t.Errorf("got block 3 timestamp %d, want %d", b3.TimestampMs, b2.TimestampMs+1)
+	}
+	if got := skew() - base; got != 1 {
+		t.Errorf("after block 3, got skew count %d, want 1", got)
+	}
+
+	// In dev mode, blocks are stamped with nowTimestamp.
+	var (
+		height uint64
+		ts     uint64
+	)
+	n.do(func() {
+		n.dev = true
+		height, err = n.commitNow(ctx, tx3)
+		ts = n.chain.State().Header.TimestampMs
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 4 {
+		t.Fatalf("committed at height %d, want 4", height)
+	}
+	if ts != b3.TimestampMs+1 {
+		t.Errorf("got block 4 timestamp %d, want %d", ts, b3.TimestampMs+1)
+	}
+	if got := skew() - base; got != 2 {
+		t.Errorf("after block 4, got skew count %d, want 2", got)
+	}
+}
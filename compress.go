@@ -0,0 +1,117 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// gzipHandler wraps h,
+// decompressing request bodies sent with Content-Encoding: gzip
+// and gzipping responses at the given compression level
+// for clients that send Accept-Encoding: gzip.
+// A level of 0 leaves responses uncompressed.
+func gzipHandler(level int, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Header.Get("Content-Encoding") {
+		case "":
+		case "gzip":
+			zr, err := gzip.NewReader(req.Body)
+			if err != nil {
+				httpErrf(w, http.StatusBadRequest, "decompressing request body: %s", err)
+				return
+			}
+			defer zr.Close()
+			req.Body = zr
+			req.Header.Del("Content-Encoding")
+			req.ContentLength = -1
+		default:
+			httpErrf(w, http.StatusUnsupportedMediaType, "unsupported content encoding %s", req.Header.Get("Content-Encoding"))
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		if level == 0 || !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, level: level}
+		defer gw.close()
+		h.ServeHTTP(gw, req)
+	})
+}
+
+// acceptsGzip tells whether an Accept-Encoding header
+// allows gzip (or anything, with "*"),
+// ignoring entries with a quality of 0.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, enc := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(enc, ";")
+		name := strings.TrimSpace(parts[0])
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		refused := false
+		for _, param := range parts[1:] {
+			if q := strings.Replace(param, " ", "", -1); q == "q=0" || strings.HasPrefix(q, "q=0.") && strings.Trim(q[4:], "0") == "" {
+				refused = true
+			}
+		}
+		if !refused {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter gzips a response body,
+// if there is one.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	level       int
+	zw          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code != http.StatusNoContent && code != http.StatusNotModified {
+		hdr := w.Header()
+		hdr.Set("Content-Encoding", "gzip")
+		hdr.Del("Content-Length")
+		// The compressed body differs byte for byte from the uncompressed one.
+		if etag := hdr.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			hdr.Set("ETag", "W/"+etag)
+		}
+		w.zw, _ = gzip.NewWriterLevel(w.ResponseWriter, w.level) // level is checked at startup
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.zw == nil {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.zw.Write(p)
+}
+
+// Flush sends what has been written so far,
+// for responses that stream.
+func (w *gzipResponseWriter) Flush() {
+	if w.zw != nil {
+		w.zw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.zw != nil {
+		w.zw.Close()
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, gzip;q=1.0": true,
+		"br, *":               true,
+		"gzip;q=0":            false,
+		"gzip; q=0.000":       false,
+		"identity":            false,
+	}
+	for hdr, want := range cases {
+		if got := acceptsGzip(hdr); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", hdr, got, want)
+		}
+	}
+}
+
+func TestGzipHandler(t *testing.T) {
+	body := bytes.Repeat([]byte("block "), 1000)
+	h := gzipHandler(gzip.BestSpeed, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reqBody, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reqBody) > 0 && !bytes.Equal(reqBody, body) {
+			t.Error("handler got a garbled request body")
+		}
+		if req.FormValue("empty") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"abc"`)
+		w.Write(body)
+	}))
+
+	req := httptest.NewRequest("GET", "/get", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", got)
+	}
+	if got := rec.Header().Get("ETag"); got != `W/"abc"` {
+		t.Errorf("got ETag %s, want a weak one", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Error("decompressed response differs from the original")
+	}
+
+	req = httptest.NewRequest("GET", "/get?empty=1", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("got status %d, %d body bytes, and Content-Encoding %q for an empty response, want %d, 0, and none", rec.Code, rec.Body.Len(), rec.Header().Get("Content-Encoding"), http.StatusNotModified)
+	}
+
+	req = httptest.NewRequest("GET", "/get", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !bytes.Equal(rec.Body.Bytes(), body) {
+		t.Error("got a compressed response without Accept-Encoding")
+	}
+
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	zw.Write(body)
+	zw.Close()
+	req = httptest.NewRequest("POST", "/submit", buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d for a gzipped request, want %d", rec.Code, http.StatusOK)
+	}
+
+	req = httptest.NewRequest("POST", "/submit", bytes.NewReader(body))
+	req.Header.Set("Content-Encoding", "br")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("got status %d for an unsupported encoding, want %d", rec.Code, http.StatusUnsupportedMediaType)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chain/txvm/errors"
+)
+
+// readConfigFile reads the -config file,
+// a YAML mapping of flag names (without the leading -) to values:
+//
+//	addr: localhost:2423
+//	max-block-txs: 500
+//	hooks: true
+//	chain:
+//	  - a=a.db
+//	  - b=b.db
+//
+// Only this simple form of YAML is understood:
+// one "name: value" pair per line,
+// or for a repeatable flag, a "name:" line followed by indented "- value" lines.
+// Values may be quoted.
+// Blank lines and comments beginning with # are ignored.
+func readConfigFile(filename string) (map[string][]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening config file")
+	}
+	defer f.Close()
+
+	var (
+		cfg    = make(map[string][]string)
+		list   string // name of the flag whose "- value" lines follow, if any
+		lineno int
+	)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lineno++
+		line := sc.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") && list != "" && trimmed != line {
+			cfg[list] = append(cfg[list], unquote(strings.TrimSpace(trimmed[2:])))
+			continue
+		}
+		if trimmed != line {
+			return nil, fmt.Errorf("%s:%d: unexpected indentation", filename, lineno)
+		}
+		parts := strings.SplitN(strings.TrimRight(line, " \t")+" ", ": ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: want NAME: VALUE", filename, lineno)
+		}
+		name, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if _, ok := cfg[name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate setting %q", filename, lineno, name)
+		}
+		list = ""
+		if val == "" {
+			list = name
+			cfg[name] = nil
+			continue
+		}
+		cfg[name] = []string{unquote(val)}
+	}
+	if err = sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading config file")
+	}
+	return cfg, nil
+}
+
+// unquote removes matching single or double quotes from around s.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// applyConfig sets the flags in fs named in cfg,
+// except those in given (the flags set on the command line, which take precedence).
+func applyConfig(fs *flag.FlagSet, cfg map[string][]string, given map[string]bool) error {
+	for name, vals := range cfg {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown config setting %q", name)
+		}
+		if given[name] {
+			continue
+		}
+		for _, val := range vals {
+			err := fs.Set(name, val)
+			if err != nil {
+				return errors.Wrapf(err, "config setting %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// settingsFlags are the flags whose values a SIGHUP reloads.
+type settingsFlags struct {
+	maxBlockTxs      *int
+	maxBlockBytes    *int
+	poolTTL          *time.Duration
+	blockInterval    *time.Duration
+	logLevel         *string
+	adminTokensFile  *string
+	trustedTokenFile *string
+}
+
+// defineSettingsFlags defines the reloadable flags in fs.
+func defineSettingsFlags(fs *flag.FlagSet) *settingsFlags {
+	return &settingsFlags{
+		maxBlockTxs:      fs.Int("max-block-txs", 0, "maximum number of txs in a block (0 for the protocol default)"),
+		maxBlockBytes:    fs.Int("max-block-bytes", 0, "maximum total size in bytes of the txs in a block (0 for no limit)"),
+		poolTTL:          fs.Duration("pool-ttl", 0, "evict pending txs that have waited this long for a block (0 for no limit)"),
+		blockInterval:    fs.Duration("block-interval", 5*time.Second, "time from the arrival of a tx to the commit of the block containing it"),
+		logLevel:         fs.String("log-level", "info", "info (log each tx and block) or warn (log only problems and rarer events)"),
+		adminTokensFile:  fs.String("admin-tokens-file", "", "file of admin bearer tokens and their scopes, one \"TOKEN SCOPE,...\" per line, enabling /admin/..."),
+		trustedTokenFile: fs.String("trusted-token-file", "", "file containing the bearer token that enables /submit/trusted"),
+	}
+}
+
+// settings are the reloadable parts of the server's configuration.
+type settings struct {
+	maxBlockTxs   int
+	maxBlockBytes int
+	poolTTL       time.Duration
+	blockInterval time.Duration
+	quiet         bool // log level warn
+	adminTokens   []adminToken
+	trustedToken  string
+}
+
+// load validates the flag values in sf
+// and reads the token files they name.
+func (sf *settingsFlags) load() (*settings, error) {
+	s := &settings{
+		maxBlockTxs:   *sf.maxBlockTxs,
+		maxBlockBytes: *sf.maxBlockBytes,
+		poolTTL:       *sf.poolTTL,
+		blockInterval: *sf.blockInterval,
+	}
+	if s.blockInterval <= 0 {
+		return nil, errors.New("-block-interval must be positive")
+	}
+	switch *sf.logLevel {
+	case "info":
+	case "warn":
+		s.quiet = true
+	default:
+		return nil, fmt.Errorf("unknown -log-level %q", *sf.logLevel)
+	}
+	if *sf.adminTokensFile != "" {
+		var err error
+		s.adminTokens, err = readAdminTokens(*sf.adminTokensFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if *sf.trustedTokenFile != "" {
+		tokenBytes, err := ioutil.ReadFile(*sf.trustedTokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading trusted token")
+		}
+		s.trustedToken = strings.TrimSpace(string(tokenBytes))
+		if s.trustedToken == "" {
+			return nil, fmt.Errorf("trusted token file %s is empty", *sf.trustedTokenFile)
+		}
+	}
+	return s, nil
+}
+
+// reloadSettings rereads the reloadable settings
+// from the config file (if any) and the token files.
+// The flags in given, which were set on the command line,
+// keep their values in flags.
+func reloadSettings(flags *flag.FlagSet, configFile string, given map[string]bool) (*settings, error) {
+	var cfg map[string][]string
+	if configFile != "" {
+		var err error
+		cfg, err = readConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		for name := range cfg {
+			if flags.Lookup(name) == nil || name == "config" {
+				return nil, fmt.Errorf("unknown config setting %q", name)
+			}
+		}
+	}
+
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	sf := defineSettingsFlags(fs)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		switch vals := cfg[f.Name]; {
+		case given[f.Name]:
+			err = fs.Set(f.Name, flags.Lookup(f.Name).Value.String())
+		case len(vals) > 0:
+			err = errors.Wrapf(fs.Set(f.Name, vals[len(vals)-1]), "config setting %s", f.Name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sf.load()
+}
+
+// apply puts the settings in s into effect for the server as a whole.
+func (s *settings) apply() {
+	atomic.StoreInt64(&blockIntervalNS, int64(s.blockInterval))
+	var quiet int32
+	if s.quiet {
+		quiet = 1
+	}
+	atomic.StoreInt32(&quietLogs, quiet)
+}
+
+// reload puts the settings in s into effect for n.
+// The admin and trusted-submission APIs cannot be enabled or disabled this way,
+// only given new tokens.
+func (n *node) reload(s *settings) {
+	n.do(func() {
+		n.pool.MaxBlockTxs = s.maxBlockTxs
+		n.pool.MaxBlockBytes = s.maxBlockBytes
+		n.pool.TTL = s.poolTTL
+	})
+
+	n.tokensMu.Lock()
+	defer n.tokensMu.Unlock()
+	if (s.adminTokens == nil) != (n.adminTokens == nil) {
+		n.log.Print("enabling or disabling the admin API requires a restart; keeping the old admin tokens")
+	} else {
+		n.adminTokens = s.adminTokens
+	}
+	if (s.trustedToken == "") != (n.trustedToken == "") {
+		n.log.Print("enabling or disabling /submit/trusted requires a restart; keeping the old trusted token")
+	} else {
+		n.trustedToken = s.trustedToken
+	}
+}
+
+// blockIntervalNS is the -block-interval in nanoseconds, accessed atomically.
+var blockIntervalNS = int64(5 * time.Second)
+
+func blockInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&blockIntervalNS))
+}
+
+// quietLogs is nonzero (with -log-level warn) to suppress the messages logged by infof.
+// It is accessed atomically.
+var quietLogs int32
+
+// infof logs a routine message about the progress of a tx or block.
+func (n *node) infof(format string, args ...interface{}) {
+	if atomic.LoadInt32(&quietLogs) == 0 {
+		n.log.Printf(format, args...)
+	}
+}
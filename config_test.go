@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txvmbcdconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name, text string
+		want       map[string][]string
+		wantErr    bool
+	}{
+		{
+			name: "ok",
+			text: "# server\naddr: localhost:9999 # comment\n\nhooks: true\nadmin-tokens-file: \"tokens file\"\nchain:\n  - a=a.db\n  - 'b=b.db'\n",
+			want: map[string][]string{
+				"addr":              {"localhost:9999"},
+				"hooks":             {"true"},
+				"admin-tokens-file": {"tokens file"},
+				"chain":             {"a=a.db", "b=b.db"},
+			},
+		},
+		{name: "no colon", text: "addr localhost:9999\n", wantErr: true},
+		{name: "duplicate", text: "addr: a\naddr: b\n", wantErr: true},
+		{name: "indented", text: "addr: a\n  hooks: true\n", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filename := filepath.Join(dir, c.name+".yaml")
+			err := ioutil.WriteFile(filename, []byte(c.text), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := readConfigFile(filename)
+			if c.wantErr {
+				if err == nil {
+					t.Errorf("got %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:2423", "")
+	sf := defineSettingsFlags(fs)
+	fs.Parse([]string{"-max-block-txs", "3"})
+	given := map[string]bool{"max-block-txs": true}
+
+	err := applyConfig(fs, map[string][]string{
+		"addr":          {"localhost:9999"},
+		"max-block-txs": {"9"},
+		"pool-ttl":      {"1m"},
+	}, given)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *addr != "localhost:9999" || *sf.maxBlockTxs != 3 || *sf.poolTTL != time.Minute {
+		t.Errorf("got addr %s, max-block-txs %d, pool-ttl %s; want localhost:9999, 3, 1m0s", *addr, *sf.maxBlockTxs, *sf.poolTTL)
+	}
+
+	err = applyConfig(fs, map[string][]string{"bogus": {"1"}}, given)
+	if err == nil {
+		t.Error("got no error for unknown setting")
+	}
+	err = applyConfig(fs, map[string][]string{"pool-ttl": {"soon"}}, given)
+	if err == nil {
+		t.Error("got no error for bad value")
+	}
+}
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "txvmbcdconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		configFile = filepath.Join(dir, "txvmbcd.yaml")
+		tokensFile = filepath.Join(dir, "tokens")
+	)
+	write := func(filename, text string) {
+		t.Helper()
+		err := ioutil.WriteFile(filename, []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(configFile, "max-block-txs: 9\nmax-block-bytes: 1000\nblock-interval: 2s\nadmin-tokens-file: "+tokensFile+"\n")
+	write(tokensFile, "old read\n")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("addr", "localhost:2423", "")
+	defineSettingsFlags(fs)
+	fs.Parse([]string{"-max-block-txs", "3"})
+	given := map[string]bool{"max-block-txs": true}
+
+	s, err := reloadSettings(fs, configFile, given)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.maxBlockTxs != 3 || s.maxBlockBytes != 1000 || s.blockInterval != 2*time.Second || len(s.adminTokens) != 1 {
+		t.Fatalf("got settings %+v, want max-block-txs 3, max-block-bytes 1000, block-interval 2s, and one admin token", s)
+	}
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.adminTokens = s.adminTokens
+
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+	status := func(token string) int {
+		req := httptest.NewRequest("GET", "/admin/status", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if got := status("old"); got != http.StatusOK {
+		t.Fatalf("got status %d with the old token before reloading, want %d", got, http.StatusOK)
+	}
+
+	// Rotate the token.
+	write(tokensFile, "new read\n")
+	s, err = reloadSettings(fs, configFile, given)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.reload(s)
+
+	if got := status("old"); got != http.StatusUnauthorized {
+		t.Errorf("got status %d with the old token after reloading, want %d", got, http.StatusUnauthorized)
+	}
+	if got := status("new"); got != http.StatusOK {
+		t.Errorf("got status %d with the new token after reloading, want %d", got, http.StatusOK)
+	}
+	n.do(func() {
+		if n.pool.MaxBlockTxs != 3 || n.pool.MaxBlockBytes != 1000 {
+			t.Errorf("got pool limits %d txs and %d bytes, want 3 and 1000", n.pool.MaxBlockTxs, n.pool.MaxBlockBytes)
+		}
+	})
+
+	// A bad config leaves the old settings in place.
+	write(configFile, "log-level: loud\n")
+	_, err = reloadSettings(fs, configFile, given)
+	if err == nil {
+		t.Error("got no error for unknown log level")
+	}
+}
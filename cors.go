@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsHandler wraps h with CORS headers,
+// allowing browser pages served from any of the given origins
+// (or from anywhere, if origins contains "*")
+// to call the server directly.
+// It answers preflight requests itself.
+func corsHandler(origins []string, h http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, o := range origins {
+		allowed[strings.TrimSuffix(strings.TrimSpace(o), "/")] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" || (!allowed["*"] && !allowed[origin]) {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		hdr := w.Header()
+		hdr.Add("Vary", "Origin")
+		hdr.Set("Access-Control-Allow-Origin", origin)
+		hdr.Set("Access-Control-Expose-Headers", bloomHashesHeader)
+
+		if req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != "" {
+			hdr.Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+			if reqHeaders := req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+				hdr.Set("Access-Control-Allow-Headers", reqHeaders)
+			}
+			hdr.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.ServeHTTP(w, req)
+	})
+}
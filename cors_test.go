@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestCORS(t *testing.T) {
+	var called bool
+	h := corsHandler([]string{"https://wallet.example", "http://localhost:3000/"}, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+	}))
+
+	cases := []struct {
+		name, method, origin string
+		wantAllow            string
+		wantCalled           bool
+	}{
+		{name: "no origin", method: "GET", wantCalled: true},
+		{name: "allowed", method: "GET", origin: "https://wallet.example", wantAllow: "https://wallet.example", wantCalled: true},
+		{name: "trailing slash", method: "POST", origin: "http://localhost:3000", wantAllow: "http://localhost:3000", wantCalled: true},
+		{name: "disallowed", method: "GET", origin: "https://evil.example", wantCalled: true},
+		{name: "preflight", method: "OPTIONS", origin: "https://wallet.example", wantAllow: "https://wallet.example"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			called = false
+			req := httptest.NewRequest(c.method, "/submit", nil)
+			if c.origin != "" {
+				req.Header.Set("Origin", c.origin)
+			}
+			if c.method == "OPTIONS" {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+				req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != c.wantAllow {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, c.wantAllow)
+			}
+			if called != c.wantCalled {
+				t.Errorf("got called=%v, want %v", called, c.wantCalled)
+			}
+			if c.method == "OPTIONS" {
+				if rec.Code != http.StatusNoContent {
+					t.Errorf("got status %d for preflight, want %d", rec.Code, http.StatusNoContent)
+				}
+				if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type" {
+					t.Errorf("got Access-Control-Allow-Headers %q, want Content-Type", got)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitJSON(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.dev = true
+
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for i, encode := range []func([]byte) jsonSubmission{
+		func(b []byte) jsonSubmission { return jsonSubmission{Hex: hex.EncodeToString(b)} },
+		func(b []byte) jsonSubmission { return jsonSubmission{Base64: base64.StdEncoding.EncodeToString(b)} },
+	} {
+		tx := testIssuance(ctx, t, n.initialBlock, int64(10+i))
+		txbits, err := proto.Marshal(&tx.RawTx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := json.Marshal(encode(txbits))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(server.URL+"/submit", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			t.Fatalf("status code %d from POST /submit", resp.StatusCode)
+		}
+		if want := uint64(2 + i); n.chain.Height() != want {
+			t.Errorf("got height %d, want %d", n.chain.Height(), want)
+		}
+	}
+
+	resp, err := http.Post(server.URL+"/submit", "application/json", bytes.NewReader([]byte(`{}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d for empty JSON submission, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
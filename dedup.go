@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// recentTxBlocks is the number of recent blocks
+// whose transaction IDs are remembered for deduplicating submissions,
+// recentRejected is the number of rejected transactions kept in the db for /tx,
+// and idempotencyTTL is how long an Idempotency-Key is remembered.
+var (
+	recentTxBlocks = 100
+	recentRejected = 10000
+	idempotencyTTL = 24 * time.Hour
+)
+
+// txTracker remembers the transactions committed in recent blocks
+// and the Idempotency-Key headers of recent submissions.
+// It belongs to the builder goroutine.
+// Its memory starts empty when the server starts.
+// (The transactions recently dropped from the pool
+// are instead kept in the db; see recordRejected.)
+type txTracker struct {
+	committed map[bc.Hash]uint64 // tx ID -> block height
+	blocks    [][]bc.Hash        // IDs of the txs in each remembered block, oldest first
+	keys      map[string]idempotencyKey
+}
+
+type idempotencyKey struct {
+	txID    bc.Hash
+	expires time.Time
+}
+
+// txStatus is the JSON response to the submission of a transaction
+// that is already pending or committed,
+// and to /tx.
+// A tx dropped from the pool is "rejected" (with the reason in Error).
+// With -verify-workers,
+// a submission may also be "verifying" (not yet run, so with no TxID)
+// or rejected before reaching the pool.
+type txStatus struct {
+	SubmissionID string `json:"submission_id,omitempty"`
+	TxID         string `json:"tx_id,omitempty"`
+	Status       string `json:"status"` // "verifying", "rejected", "pending", or "committed"
+	Height       uint64 `json:"height,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+func newTxTracker() *txTracker {
+	return &txTracker{
+		committed: make(map[bc.Hash]uint64),
+		keys:      make(map[string]idempotencyKey),
+	}
+}
+
+// recordBlock remembers the txs in b,
+// forgetting those in blocks more than recentTxBlocks old
+// and any expired idempotency keys.
+func (t *txTracker) recordBlock(b *bc.UnsignedBlock) {
+	ids := make([]bc.Hash, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		t.committed[tx.ID] = b.Height
+		ids = append(ids, tx.ID)
+	}
+	t.blocks = append(t.blocks, ids)
+	for len(t.blocks) > recentTxBlocks {
+		for _, id := range t.blocks[0] {
+			delete(t.committed, id)
+		}
+		t.blocks = t.blocks[1:]
+	}
+
+	now := time.Now()
+	for key, k := range t.keys {
+		if now.After(k.expires) {
+			delete(t.keys, key)
+		}
+	}
+}
+
+// recordRejected stores the txs in rs,
+// dropped from the pool while building a block,
+// in the rejected_txs table,
+// discarding the oldest beyond recentRejected.
+// As with recordBuild, failures are logged, not returned.
+func (n *node) recordRejected(ctx context.Context, rs []rejectedTx) {
+	if len(rs) == 0 {
+		return
+	}
+	err := func() error {
+		dbtx, err := n.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer dbtx.Rollback()
+
+		var last int64
+		for _, r := range rs {
+			id, err := hex.DecodeString(r.TxID)
+			if err != nil {
+				continue
+			}
+			// Replacing a tx's earlier rejection makes it the newest.
+			res, err := dbtx.ExecContext(ctx, "INSERT OR REPLACE INTO rejected_txs (tx_id, reason) VALUES ($1, $2)", id, r.Reason)
+			if err != nil {
+				return err
+			}
+			last, err = res.LastInsertId()
+			if err != nil {
+				return err
+			}
+		}
+		_, err = dbtx.ExecContext(ctx, "DELETE FROM rejected_txs WHERE id <= $1", last-int64(recentRejected))
+		if err != nil {
+			return err
+		}
+		return dbtx.Commit()
+	}()
+	if err != nil {
+		n.log.Print(errors.Wrap(err, "recording rejected txs"))
+	}
+}
+
+// key returns the ID of the tx submitted with the given idempotency key, if any.
+func (t *txTracker) key(key string) (bc.Hash, bool) {
+	k, ok := t.keys[key]
+	if !ok || time.Now().After(k.expires) {
+		return bc.Hash{}, false
+	}
+	return k.txID, true
+}
+
+func (t *txTracker) setKey(key string, txID bc.Hash) {
+	t.keys[key] = idempotencyKey{txID: txID, expires: time.Now().Add(idempotencyTTL)}
+}
+
+// status reports whether the tx with the given ID is pending or recently committed.
+// It returns nil if neither.
+// It runs in the builder goroutine.
+func (n *node) status(txID bc.Hash) *txStatus {
+	if height, ok := n.txs.committed[txID]; ok {
+		return &txStatus{TxID: hex.EncodeToString(txID.Bytes()), Status: "committed", Height: height}
+	}
+	if n.pool.has(txID) {
+		return &txStatus{TxID: hex.EncodeToString(txID.Bytes()), Status: "pending"}
+	}
+	return nil
+}
+
+// lookup is like status,
+// but also reports a tx recently dropped from the pool as "rejected",
+// with the reason.
+// It must not be called from the builder goroutine.
+func (n *node) lookup(ctx context.Context, txID bc.Hash) (*txStatus, error) {
+	var st *txStatus
+	err := n.doContext(ctx, func() {
+		st = n.status(txID)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up tx %x", txID.Bytes())
+	}
+	if st != nil {
+		return st, nil
+	}
+	var reason string
+	err = n.db.QueryRowContext(ctx, "SELECT reason FROM rejected_txs WHERE tx_id = $1", txID.Bytes()).Scan(&reason)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up rejected tx %x", txID.Bytes())
+	}
+	return &txStatus{TxID: hex.EncodeToString(txID.Bytes()), Status: "rejected", Error: reason}, nil
+}
+
+// txHandler handles /tx?id=ID,
+// reporting whether the tx with the hex-encoded ID is pending, recently committed, or recently rejected,
+// and, with -verify-workers, /tx?submission=ID,
+// reporting the outcome of a /submit.
+func (n *node) txHandler(w http.ResponseWriter, req *http.Request) {
+	if req.FormValue("submission") != "" {
+		n.submissionHandler(w, req)
+		return
+	}
+	id, err := hexParam(req, "id")
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing id: %s", err)
+		return
+	}
+	if len(id) != 32 {
+		httpErrf(w, http.StatusBadRequest, "must supply a 32-byte hex id")
+		return
+	}
+	st, err := n.lookup(req.Context(), bc.HashFromBytes(id))
+	if errors.Root(err) == errBuilderStopped || req.Context().Err() != nil {
+		httpErrf(w, http.StatusServiceUnavailable, "%s", err)
+		return
+	}
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	if st == nil {
+		httpErrf(w, http.StatusNotFound, "tx %x is not pending, recently committed, or recently rejected", id)
+		return
+	}
+	respondJSON(w, st)
+}
+
+func (n *node) submissionHandler(w http.ResponseWriter, req *http.Request) {
+	if n.verifier == nil {
+		httpErrf(w, http.StatusNotFound, "submissions are not verified asynchronously (see -verify-workers)")
+		return
+	}
+	id, err := hexParam(req, "submission")
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing submission: %s", err)
+		return
+	}
+	if len(id) != 32 {
+		httpErrf(w, http.StatusBadRequest, "must supply a 32-byte hex submission ID")
+		return
+	}
+	var subID [32]byte
+	copy(subID[:], id)
+	st := n.submissionStatus(req.Context(), subID)
+	if st == nil {
+		httpErrf(w, http.StatusNotFound, "submission %x is not known", id)
+		return
+	}
+	respondJSON(w, st)
+}
+
+const rejectedTxsSchema = `
+CREATE TABLE IF NOT EXISTS rejected_txs (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  tx_id BLOB NOT NULL UNIQUE,
+  reason TEXT NOT NULL
+);
+`
+
+// migrateRejectedTxs adds the rejected_txs table (schema version 4).
+func migrateRejectedTxs(dbtx *sql.Tx) error {
+	_, err := dbtx.Exec(rejectedTxsSchema)
+	return err
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+func TestDedup(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx1 := testIssuance(ctx, t, n.initialBlock, 10)
+	tx2 := testIssuance(ctx, t, n.initialBlock, 11)
+
+	submit := func(key string, tx *bc.Tx) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/submit", nil)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		n.enqueue(rec, req, tx)
+		return rec
+	}
+	wantStatus := func(rec *httptest.ResponseRecorder, status string, height uint64) {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status code %d, want %d", rec.Code, http.StatusOK)
+		}
+		var got txStatus
+		err := json.NewDecoder(rec.Body).Decode(&got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != status || got.Height != height {
+			t.Errorf("got status %s at height %d, want %s at height %d", got.Status, got.Height, status, height)
+		}
+	}
+
+	if rec := submit("k1", tx1); rec.Code != http.StatusNoContent {
+		t.Fatalf("got status code %d for first submission, want %d", rec.Code, http.StatusNoContent)
+	}
+	wantStatus(submit("", tx1), "pending", 0)
+	wantStatus(submit("k1", tx1), "pending", 0)
+	if pending := atomic.LoadInt64(&n.pending); pending != 1 {
+		t.Errorf("got pool size %d, want 1", pending)
+	}
+
+	if rec := submit("k1", tx2); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status code %d reusing idempotency key, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+
+	n.do(func() {
+		n.buildBlock(ctx, time.Now().Add(time.Second))
+	})
+
+	wantStatus(submit("", tx1), "committed", 2)
+	if pending := atomic.LoadInt64(&n.pending); pending != 0 {
+		t.Errorf("got pool size %d after resubmitting a committed tx, want 0", pending)
+	}
+}
+
+func TestRejectedStatus(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
+	txID := hex.EncodeToString(tx.ID.Bytes())
+
+	submit := func() int {
+		rec := httptest.NewRecorder()
+		n.enqueue(rec, httptest.NewRequest("POST", "/submit", nil), tx)
+		return rec.Code
+	}
+	lookup := func() *txStatus {
+		rec := httptest.NewRecorder()
+		n.txHandler(rec, httptest.NewRequest("GET", "/tx?id="+txID, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status code %d from /tx, want %d", rec.Code, http.StatusOK)
+		}
+		var st txStatus
+		err := json.NewDecoder(rec.Body).Decode(&st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &st
+	}
+
+	if code := submit(); code != http.StatusNoContent {
+		t.Fatalf("got status code %d, want %d", code, http.StatusNoContent)
+	}
+	time.Sleep(time.Millisecond)
+	n.do(func() {
+		n.pool.TTL = time.Nanosecond
+		n.buildBlock(ctx, time.Now().Add(time.Second))
+		n.pool.TTL = 0
+	})
+	if st := lookup(); st.Status != "rejected" || !strings.Contains(st.Error, "pending longer than") {
+		t.Errorf("got %+v, want rejected for pending too long", st)
+	}
+
+	// A rejected tx may be submitted again.
+	if code := submit(); code != http.StatusNoContent {
+		t.Fatalf("got status code %d resubmitting a rejected tx, want %d", code, http.StatusNoContent)
+	}
+	if st := lookup(); st.Status != "pending" {
+		t.Errorf("got status %s after resubmitting, want pending", st.Status)
+	}
+	n.do(func() {
+		n.buildBlock(ctx, time.Now().Add(time.Second))
+	})
+	if st := lookup(); st.Status != "committed" || st.Height != 2 {
+		t.Errorf("got %+v after building, want committed at height 2", st)
+	}
+}
+
+func TestRejectedPersisted(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(old int) { recentRejected = old }(recentRejected)
+	recentRejected = 2
+
+	var ids []bc.Hash
+	for i := byte(1); i <= 3; i++ {
+		id := bc.HashFromBytes(bytes.Repeat([]byte{i}, 32))
+		ids = append(ids, id)
+		n.recordRejected(ctx, []rejectedTx{{TxID: hex.EncodeToString(id.Bytes()), Reason: fmt.Sprintf("reason %d", i)}})
+	}
+
+	// A restarted server still knows the recent rejections.
+	n, err = newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := n.lookup(ctx, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != nil {
+		t.Errorf("got %+v for the oldest rejection, want it forgotten", st)
+	}
+	st, err = n.lookup(ctx, ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st == nil || st.Status != "rejected" || st.Error != "reason 3" {
+		t.Errorf("got %+v for the newest rejection, want rejected with reason 3", st)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txbuilder"
+	"github.com/chain/txvm/protocol/txbuilder/standard"
+	"github.com/chain/txvm/protocol/txbuilder/txresult"
+)
+
+// devIssuer returns the key pair that signs -dev mode test issuances.
+// It is derived from a fixed seed,
+// so the asset it issues has the same ID on every run
+// (for a given initial block).
+func devIssuer() (ed25519.PrivateKey, ed25519.PublicKey) {
+	seed := sha256.Sum256([]byte("txvmbcd dev issuer"))
+	pub, prv, err := ed25519.GenerateKey(bytes.NewReader(seed[:]))
+	if err != nil {
+		panic(err)
+	}
+	return prv, pub
+}
+
+// commitNow adds txs to the pool (as a bundle, if there is more than one)
+// and immediately commits a block containing them.
+// It is the -dev mode replacement for waiting on the block timer.
+// It runs in the builder goroutine.
+func (n *node) commitNow(ctx context.Context, txs ...*bc.Tx) (uint64, error) {
+	n.pool.add(txs...)
+	ub := n.buildBlock(ctx, n.nowTimestamp())
+	if ub != nil {
+		for _, btx := range ub.Transactions {
+			if btx.ID == txs[0].ID {
+				return ub.Height, nil
+			}
+		}
+	}
+	return 0, errors.New("tx was not included in a block, see the server log")
+}
+
+// issuance builds a transaction issuing amount units of the asset controlled by pub
+// (whose private key is prv)
+// to recipient.
+// It returns the transaction and the asset ID.
+func (n *node) issuance(ctx context.Context, prv ed25519.PrivateKey, pub, recipient ed25519.PublicKey, amount int64) (*bc.Tx, bc.Hash, error) {
+	// A random nonce keeps otherwise-identical issuances distinct.
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, bc.Hash{}, errors.Wrap(err, "generating nonce")
+	}
+
+	tpl := txbuilder.NewTemplate(time.Now().Add(time.Minute), nil)
+	tpl.AddIssuance(2, n.initialBlock.Hash().Bytes(), nil, 1, [][]byte{prv}, nil, []ed25519.PublicKey{pub}, amount, nil, nonce)
+	assetID := bc.NewHash(standard.AssetID(2, 1, []ed25519.PublicKey{pub}, nil))
+	tpl.AddOutput(1, []ed25519.PublicKey{recipient}, amount, assetID, nil, nil)
+	err = tpl.Sign(ctx, func(_ context.Context, msg []byte, keyID []byte, path [][]byte) ([]byte, error) {
+		return ed25519.Sign(prv, msg), nil
+	})
+	if err != nil {
+		return nil, bc.Hash{}, errors.Wrap(err, "signing issuance")
+	}
+	tx, err := tpl.Tx()
+	return tx, assetID, err
+}
+
+// nowTimestamp returns the current time,
+// or if that is not later than the latest block's timestamp,
+// the earliest time that is.
+func (n *node) nowTimestamp() time.Time {
+	return n.blockTimestamp(n.chain.State().Header, time.Now())
+}
+
+// devIssuance is the JSON response to a /dev/issue request.
+type devIssuance struct {
+	TxID     string `json:"tx_id"`
+	Height   uint64 `json:"height"`
+	OutputID string `json:"output_id"`
+	AssetID  string `json:"asset_id"`
+	Amount   int64  `json:"amount"`
+	Pubkey   string `json:"pubkey"`
+}
+
+// devIssue handles /dev/issue?amount=N&pubkey=P,
+// committing a transaction that issues N units of the -dev asset
+// to the hex-encoded pubkey P
+// (or to the issuer's own pubkey if P is absent).
+func (n *node) devIssue(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if req.Method != "POST" {
+		httpErrf(w, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+		return
+	}
+	amount, err := strconv.ParseInt(req.FormValue("amount"), 10, 64)
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing amount: %s", err)
+		return
+	}
+	if amount <= 0 {
+		httpErrf(w, http.StatusBadRequest, "amount must be positive")
+		return
+	}
+
+	prv, pub := devIssuer()
+	recipient := pub
+	pubkey, err := hexParam(req, "pubkey")
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing pubkey: %s", err)
+		return
+	}
+	if pubkey != nil {
+		if len(pubkey) != ed25519.PublicKeySize {
+			httpErrf(w, http.StatusBadRequest, "pubkey is %d bytes long, want %d", len(pubkey), ed25519.PublicKeySize)
+			return
+		}
+		recipient = ed25519.PublicKey(pubkey)
+	}
+
+	tx, assetID, err := n.issuance(ctx, prv, pub, recipient, amount)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "building issuance: %s", err)
+		return
+	}
+
+	var (
+		height uint64
+		code   = http.StatusInternalServerError
+	)
+	doErr := n.doContext(ctx, func() {
+		switch {
+		case n.closed:
+			code, err = http.StatusServiceUnavailable, errors.New("shutting down")
+		case n.halted:
+			code, err = http.StatusServiceUnavailable, errors.New("halted")
+		default:
+			height, err = n.commitNow(ctx, tx)
+		}
+	})
+	if doErr != nil {
+		code, err = http.StatusServiceUnavailable, doErr
+	}
+	if err != nil {
+		httpErrf(w, code, "committing issuance %x: %s", tx.ID.Bytes(), err)
+		return
+	}
+
+	res := devIssuance{
+		TxID:    hex.EncodeToString(tx.ID.Bytes()),
+		Height:  height,
+		AssetID: hex.EncodeToString(assetID.Bytes()),
+		Amount:  amount,
+		Pubkey:  hex.EncodeToString(recipient),
+	}
+	for _, out := range txresult.New(tx).Outputs {
+		res.OutputID = hex.EncodeToString(out.OutputID.Bytes())
+	}
+	respondJSON(w, res)
+}
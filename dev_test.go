@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestDev(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.dev = true
+
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
+	txbits, err := proto.Marshal(&tx.RawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(server.URL+"/submit", "application/octet-stream", bytes.NewReader(txbits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		t.Fatalf("status code %d from POST /submit", resp.StatusCode)
+	}
+	if h := n.chain.Height(); h != 2 {
+		t.Fatalf("got height %d after submit, want 2", h)
+	}
+
+	var assetID string
+	for i := 0; i < 2; i++ {
+		resp, err = http.Post(server.URL+"/dev/issue?amount=5", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var iss devIssuance
+		err = json.NewDecoder(resp.Body).Decode(&iss)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode/100 != 2 {
+			t.Fatalf("status code %d from POST /dev/issue", resp.StatusCode)
+		}
+		if want := uint64(3 + i); iss.Height != want {
+			t.Errorf("got issuance in block %d, want %d", iss.Height, want)
+		}
+		if iss.Amount != 5 {
+			t.Errorf("got amount %d, want 5", iss.Amount)
+		}
+		if i > 0 && iss.AssetID != assetID {
+			t.Errorf("got asset ID %s, want %s", iss.AssetID, assetID)
+		}
+		assetID = iss.AssetID
+	}
+
+	// A halted node commits nothing, even in dev mode.
+	n.do(func() { n.halted = true })
+	resp, err = http.Post(server.URL+"/dev/issue?amount=5", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status code %d from POST /dev/issue while halted, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if h := n.chain.Height(); h != 4 {
+		t.Errorf("got height %d after issuing while halted, want 4", h)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/golang/protobuf/proto"
+)
+
+// header handles /header?height=N,
+// responding with the serialized BlockHeader of the block at height N
+// (0, the default, for the latest),
+// or status 404 if there is no such block yet.
+// Unlike /get it does not wait for a future block.
+func (n *node) header(w http.ResponseWriter, req *http.Request) {
+	var (
+		want uint64
+		err  error
+	)
+	if s := req.FormValue("height"); s != "" {
+		want, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			httpErrf(w, http.StatusBadRequest, "parsing height: %s", err)
+			return
+		}
+	}
+	height := n.store.FinalHeight()
+	if want == 0 {
+		want = height
+	}
+	if want > height {
+		httpErrf(w, http.StatusNotFound, "no block at height %d", want)
+		return
+	}
+
+	b, err := n.chain.GetBlock(req.Context(), want)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "getting block %d: %s", want, err)
+		return
+	}
+	bits, err := proto.Marshal(b.BlockHeader)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "serializing header %d: %s", want, err)
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, b.Hash().Bytes()))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(bits)
+}
+
+// hashSource gives the block hashes of a chain,
+// for finding where two copies of it diverge.
+type hashSource interface {
+	height(context.Context) (uint64, error)
+	blockHash(context.Context, uint64) (bc.Hash, error)
+}
+
+// dbHashes is a hashSource reading a server's db.
+type dbHashes struct {
+	db *sql.DB
+}
+
+func (d dbHashes) height(ctx context.Context) (uint64, error) {
+	var height uint64
+	err := d.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(height), 0) FROM blocks").Scan(&height)
+	return height, errors.Wrap(err, "getting height")
+}
+
+func (d dbHashes) blockHash(ctx context.Context, height uint64) (bc.Hash, error) {
+	var hash []byte
+	err := d.db.QueryRowContext(ctx, "SELECT hash FROM blocks WHERE height = $1", height).Scan(&hash)
+	if err != nil {
+		return bc.Hash{}, errors.Wrapf(err, "getting hash of block %d", height)
+	}
+	return bc.HashFromBytes(hash), nil
+}
+
+// peerHashes is a hashSource querying a server's /header.
+type peerHashes struct {
+	url    string
+	client *http.Client
+}
+
+func (p peerHashes) get(ctx context.Context, height uint64) (*bc.BlockHeader, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/header?height=%d", p.url, height), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting header %d from %s: status %d: %s", height, p.url, resp.StatusCode, bytes.TrimSpace(body))
+	}
+	h := new(bc.BlockHeader)
+	err = proto.Unmarshal(body, h)
+	return h, errors.Wrapf(err, "parsing header %d from %s", height, p.url)
+}
+
+func (p peerHashes) height(ctx context.Context) (uint64, error) {
+	h, err := p.get(ctx, 0)
+	if err != nil {
+		return 0, err
+	}
+	return h.Height, nil
+}
+
+func (p peerHashes) blockHash(ctx context.Context, height uint64) (bc.Hash, error) {
+	h, err := p.get(ctx, height)
+	if err != nil {
+		return bc.Hash{}, err
+	}
+	if h.Height != height {
+		return bc.Hash{}, fmt.Errorf("asked %s for header %d, got %d", p.url, height, h.Height)
+	}
+	return h.Hash(), nil
+}
+
+// divergence compares the block hashes of a and b
+// up to the lower of their two heights,
+// returning that height
+// and the first height at which they differ (0 if they do not).
+// Since each block commits to its predecessor,
+// two chains that differ at one height differ at every height after,
+// so this is a binary search.
+func divergence(ctx context.Context, a, b hashSource) (common, diverged uint64, err error) {
+	ha, err := a.height(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	hb, err := b.height(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	common = ha
+	if hb < common {
+		common = hb
+	}
+	if common == 0 {
+		return 0, 0, nil
+	}
+
+	same := func(height uint64) (bool, error) {
+		x, err := a.blockHash(ctx, height)
+		if err != nil {
+			return false, err
+		}
+		y, err := b.blockHash(ctx, height)
+		if err != nil {
+			return false, err
+		}
+		return x == y, nil
+	}
+
+	ok, err := same(common)
+	if err != nil || ok {
+		return common, 0, err
+	}
+	ok, err = same(1)
+	if err != nil {
+		return common, 0, err
+	}
+	if !ok {
+		return common, 1, nil
+	}
+
+	// Heights through lo agree; hi differs.
+	lo, hi := uint64(1), common
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err = same(mid)
+		if err != nil {
+			return common, 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return common, hi, nil
+}
+
+// verifyCmd implements "txvmbcd verify (-db DBFILE | -node URL) -peer URL".
+// It compares the block headers of the chain in DBFILE (or served at -node)
+// with those served at -peer,
+// reporting the first height at which they diverge
+// and exiting with an error if they do.
+func verifyCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	dbfile := fs.String("db", "", "path to the block storage db to compare")
+	nodeURL := fs.String("node", "", "base URL of a server whose chain to compare, instead of -db")
+	peer := fs.String("peer", "", "base URL of the server to compare against (e.g. http://host:2423 or http://host:2423/chains/ID)")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout for each request")
+	fs.Parse(args)
+
+	if *peer == "" || (*dbfile == "") == (*nodeURL == "") {
+		return errors.New("verify requires -peer and one of -db or -node")
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	var local hashSource
+	if *dbfile != "" {
+		if _, err := os.Stat(*dbfile); err != nil {
+			return err
+		}
+		db, err := sql.Open("sqlite3", "file:"+*dbfile+"?mode=ro")
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		local = dbHashes{db: db}
+	} else {
+		local = peerHashes{url: strings.TrimSuffix(*nodeURL, "/"), client: client}
+	}
+
+	common, diverged, err := divergence(ctx, local, peerHashes{url: strings.TrimSuffix(*peer, "/"), client: client})
+	if err != nil {
+		return err
+	}
+	if diverged > 0 {
+		return fmt.Errorf("chains diverge at height %d", diverged)
+	}
+	fmt.Printf("chains agree through height %d\n", common)
+	return nil
+}
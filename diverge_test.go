@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// fakeHashes is a hashSource whose block at height h has hash hashes[h-1].
+type fakeHashes []bc.Hash
+
+func (f fakeHashes) height(context.Context) (uint64, error) {
+	return uint64(len(f)), nil
+}
+
+func (f fakeHashes) blockHash(_ context.Context, height uint64) (bc.Hash, error) {
+	return f[height-1], nil
+}
+
+func TestDivergence(t *testing.T) {
+	ctx := context.Background()
+
+	chain := func(n, from int) fakeHashes {
+		var res fakeHashes
+		for i := 1; i <= n; i++ {
+			var h [32]byte
+			h[0] = byte(i)
+			if from > 0 && i >= from {
+				h[1] = 1
+			}
+			res = append(res, bc.NewHash(h))
+		}
+		return res
+	}
+
+	cases := []struct {
+		a, b         fakeHashes
+		wantCommon   uint64
+		wantDiverged uint64
+	}{
+		{chain(10, 0), chain(10, 0), 10, 0},
+		{chain(10, 0), chain(7, 0), 7, 0},
+		{chain(10, 0), chain(10, 1), 10, 1},
+		{chain(10, 0), chain(10, 2), 10, 2},
+		{chain(10, 0), chain(10, 7), 10, 7},
+		{chain(10, 0), chain(12, 10), 10, 10},
+		{chain(100, 0), chain(100, 38), 100, 38},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case_%d", i), func(t *testing.T) {
+			common, diverged, err := divergence(ctx, c.a, c.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if common != c.wantCommon || diverged != c.wantDiverged {
+				t.Errorf("got common height %d and divergence at %d, want %d and %d", common, diverged, c.wantCommon, c.wantDiverged)
+			}
+		})
+	}
+}
+
+func TestHeaderDivergence(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Another copy of the chain with the same genesis block.
+	otherDB, cleanup2 := testDB(t)
+	defer cleanup2()
+	other, err := newNodeFrom(ctx, otherDB, n.initialBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := peerHashes{url: server.URL, client: new(http.Client)}
+	common, diverged, err := divergence(ctx, dbHashes{db: otherDB}, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if common != 1 || diverged != 0 {
+		t.Errorf("got common height %d and divergence at %d, want 1 and 0", common, diverged)
+	}
+
+	// Each commits a different block 2.
+	for i, node := range []*node{n, other} {
+		node.do(func() {
+			_, err = node.commitNow(ctx, testIssuance(ctx, t, node.initialBlock, int64(10*(i+1))))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	common, diverged, err = divergence(ctx, dbHashes{db: otherDB}, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if common != 2 || diverged != 2 {
+		t.Errorf("got common height %d and divergence at %d, want 2 and 2", common, diverged)
+	}
+
+	if _, err = peer.blockHash(ctx, 3); err == nil {
+		t.Error("got no error getting a header beyond the peer's height")
+	}
+}
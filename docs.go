@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// endpoint describes one of a node's HTTP endpoints, for /docs.
+type endpoint struct {
+	Path    string `json:"path"`
+	Methods string `json:"methods"`
+	Doc     string `json:"doc"`
+}
+
+// flagDoc describes a command-line flag, for /docs.
+type flagDoc struct {
+	Name    string `json:"name"`
+	Usage   string `json:"usage"`
+	Default string `json:"default"`
+	Value   string `json:"value"`
+}
+
+// statusDoc explains an HTTP status the server responds with, for /docs.
+type statusDoc struct {
+	Code    int    `json:"code"`
+	Text    string `json:"text"`
+	Meaning string `json:"meaning"`
+}
+
+// statusMeanings lists the statuses of the server's responses
+// and what they mean.
+var statusMeanings = []struct {
+	code    int
+	meaning string
+}{
+	{http.StatusOK, "success, with a JSON response (or, for a tx already pending or recently committed, its status)"},
+	{http.StatusAccepted, "with -verify-workers, a tx queued to be run, with its submission ID for /tx?submission=ID"},
+	{http.StatusNoContent, "success, with no response body (e.g. a tx added to the pool)"},
+	{http.StatusNotModified, "the block requested from /get matches If-None-Match"},
+	{http.StatusBadRequest, "a malformed request or invalid tx, or a runlimit over -max-tx-runlimit"},
+	{http.StatusUnauthorized, "a missing or unknown bearer token"},
+	{http.StatusForbidden, "a token lacking the needed scope, a tx refused by the -admit script, or a submission to a -readonly server"},
+	{http.StatusNotFound, "no such block, chain, or index entry, or the feature is not enabled"},
+	{http.StatusMethodNotAllowed, "the wrong HTTP method for the endpoint"},
+	{http.StatusRequestTimeout, "a long poll on /get ended before the block arrived"},
+	{http.StatusConflict, "a bundle with some (but not all) txs already pending or committed"},
+	{http.StatusGone, "a tx whose timerange ends before the next block"},
+	{http.StatusRequestEntityTooLarge, "a request body or tx larger than -max-tx-bytes allows"},
+	{http.StatusUnsupportedMediaType, "a request body in a Content-Encoding other than gzip"},
+	{http.StatusUnprocessableEntity, "an Idempotency-Key already used for a different tx"},
+	{http.StatusInternalServerError, "a server-side failure; see the server log"},
+	{http.StatusServiceUnavailable, "the chain is halted, the server is shutting down, or the -verify-workers queue is full"},
+}
+
+// serverDocs is the documentation served by /docs.
+type serverDocs struct {
+	Version   string      `json:"version"`
+	Chain     *chainInfo  `json:"chain"`
+	Policy    *policy     `json:"policy"`
+	Endpoints []endpoint  `json:"endpoints"`
+	Statuses  []statusDoc `json:"statuses"`
+	Flags     []flagDoc   `json:"flags,omitempty"`
+}
+
+// docs handles /docs,
+// describing the endpoints, flags, and error statuses of this server
+// and the current parameters of n's chain,
+// as HTML or (with ?format=json) JSON.
+func (n *node) docs(w http.ResponseWriter, req *http.Request) {
+	p, err := n.currentPolicy(req.Context())
+	if err != nil {
+		httpErrf(w, http.StatusServiceUnavailable, "%s", err)
+		return
+	}
+	d := serverDocs{
+		Version:   buildVersion(),
+		Chain:     n.chainInfo(),
+		Policy:    p,
+		Endpoints: n.endpoints,
+	}
+	for _, s := range statusMeanings {
+		d.Statuses = append(d.Statuses, statusDoc{Code: s.code, Text: http.StatusText(s.code), Meaning: s.meaning})
+	}
+	if n.flags != nil {
+		n.flags.VisitAll(func(f *flag.Flag) {
+			d.Flags = append(d.Flags, flagDoc{Name: f.Name, Usage: f.Usage, Default: f.DefValue, Value: f.Value.String()})
+		})
+	}
+
+	if req.FormValue("format") == "json" {
+		respondJSON(w, d)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = docsTemplate.Execute(w, d)
+	if err != nil {
+		log.Printf("rendering /docs: %s", err)
+	}
+}
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>txvmbcd {{.Version}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+code { white-space: nowrap; }
+</style>
+</head>
+<body>
+<h1>txvmbcd {{.Version}}</h1>
+
+<h2>Chain</h2>
+<table>
+<tr><th>Initial block ID</th><td><code>{{.Chain.InitialBlockID}}</code></td></tr>
+<tr><th>Height</th><td>{{.Chain.Height}}</td></tr>
+<tr><th>Latest block ID</th><td><code>{{.Chain.LatestBlockID}}</code></td></tr>
+<tr><th>Read-only</th><td>{{.Chain.ReadOnly}}</td></tr>
+<tr><th>Tx version</th><td>{{.Policy.TxVersion}}</td></tr>
+<tr><th>Priority</th><td>{{.Policy.Priority}}{{with .Policy.FeeAsset}} (fee asset <code>{{.}}</code>){{end}}</td></tr>
+<tr><th>Block interval (ms)</th><td>{{.Policy.BlockIntervalMS}}</td></tr>
+<tr><th>Max block txs</th><td>{{.Policy.MaxBlockTxs}}</td></tr>
+<tr><th>Max block bytes</th><td>{{or .Policy.MaxBlockBytes "no limit"}}</td></tr>
+<tr><th>Max tx bytes</th><td>{{or .Policy.MaxTxBytes "no limit"}}</td></tr>
+<tr><th>Max tx runlimit</th><td>{{or .Policy.MaxTxRunlimit "no limit"}}</td></tr>
+<tr><th>Max nonce window (ms)</th><td>{{.Policy.MaxNonceWindowMS}}</td></tr>
+<tr><th>Max block window</th><td>{{.Policy.MaxBlockWindow}}</td></tr>
+<tr><th>Pool TTL (ms)</th><td>{{or .Policy.PoolTTLMS "no limit"}}</td></tr>
+</table>
+
+<h2>Endpoints</h2>
+<table>
+<tr><th>Path</th><th>Methods</th><th></th></tr>
+{{range .Endpoints}}<tr><td><code>{{.Path}}</code></td><td>{{.Methods}}</td><td>{{.Doc}}</td></tr>
+{{end}}</table>
+
+<h2>Error statuses</h2>
+<table>
+{{range .Statuses}}<tr><td>{{.Code}} {{.Text}}</td><td>{{.Meaning}}</td></tr>
+{{end}}</table>
+{{if .Flags}}
+<h2>Flags</h2>
+<table>
+<tr><th>Flag</th><th>Value</th><th>Default</th><th></th></tr>
+{{range .Flags}}<tr><td><code>-{{.Name}}</code></td><td><code>{{.Value}}</code></td><td><code>{{.Default}}</code></td><td>{{.Usage}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
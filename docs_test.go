@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDocs(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.maxTxBytes = 1000
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("max-tx-bytes", 1<<20, "maximum size in bytes of a submitted tx")
+	fs.Set("max-tx-bytes", "1000")
+	n.flags = fs
+
+	mux := http.NewServeMux()
+	n.handle(mux, "/chains/x")
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/chains/x/docs?format=json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got serverDocs
+	err = json.NewDecoder(rec.Body).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := make(map[string]bool)
+	for _, e := range got.Endpoints {
+		paths[e.Path] = true
+	}
+	for _, want := range []string{"/chains/x/submit", "/chains/x/docs"} {
+		if !paths[want] {
+			t.Errorf("endpoint %s missing from %v", want, got.Endpoints)
+		}
+	}
+	if paths["/chains/x/faucet"] {
+		t.Error("/faucet documented though not enabled")
+	}
+	if got.Policy.MaxTxBytes != 1000 {
+		t.Errorf("got max tx bytes %d, want 1000", got.Policy.MaxTxBytes)
+	}
+	if got.Chain.Height != 1 {
+		t.Errorf("got height %d, want 1", got.Chain.Height)
+	}
+	if len(got.Flags) != 1 || got.Flags[0].Value != "1000" || got.Flags[0].Default != "1048576" {
+		t.Errorf("got flags %+v, want max-tx-bytes 1000 (default 1048576)", got.Flags)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/chains/x/docs", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("got content type %q, want text/html", ct)
+	}
+	for _, want := range []string{"/chains/x/submit", "413 Request Entity Too Large", "-max-tx-bytes"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("HTML docs lack %q", want)
+		}
+	}
+}
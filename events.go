@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txbuilder/txresult"
+	"github.com/chain/txvm/protocol/txvm"
+)
+
+// eventTypes maps each txvm log entry type code that eventStore indexes
+// to the name of its event type.
+var eventTypes = map[byte]string{
+	txvm.InputCode:  "input",
+	txvm.OutputCode: "output",
+	txvm.IssueCode:  "issuance",
+	txvm.RetireCode: "retirement",
+	txvm.LogCode:    "log",
+}
+
+// eventStore maintains an index of the log entries of committed transactions.
+type eventStore struct {
+	db *sql.DB
+}
+
+// event is an indexed log entry.
+type event struct {
+	Height  uint64 `json:"height"`
+	TxIndex int    `json:"tx_index"`
+	TxID    string `json:"tx_id"`
+	LogPos  int    `json:"log_pos"`
+	Type    string `json:"type"`
+
+	// Seed is the seed of the input or output contract,
+	// or for other entries, of the contract that made the entry.
+	Seed string `json:"seed,omitempty"`
+
+	// ContractID is the ID of the input or output.
+	ContractID string `json:"contract_id,omitempty"`
+
+	// AssetID and Amount are the value of an issuance or retirement,
+	// or of an input or output whose value is known from standard annotations
+	// (or for a log entry of an integer, Amount is the integer).
+	AssetID string `json:"asset_id,omitempty"`
+	Amount  *int64 `json:"amount,omitempty"`
+
+	// Data is the reference data of an input, output, issuance, or retirement,
+	// or the data of a log entry of a byte string.
+	Data string `json:"data,omitempty"`
+
+	// Pubkeys are the pubkeys controlling an output,
+	// or the output spent by an input,
+	// if it is a standard contract.
+	Pubkeys []string `json:"pubkeys,omitempty"`
+}
+
+func newEventStore(db *sql.DB) (*eventStore, error) {
+	_, err := db.Exec(eventsSchema)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating event index schema")
+	}
+	return &eventStore{db: db}, nil
+}
+
+// Run indexes the log entries of each block of bs as it is committed,
+// starting after the highest block already indexed.
+// It returns only on error or context cancellation.
+func (s *eventStore) Run(ctx context.Context, bs *blockStore) error {
+	var height uint64
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(height), 0) FROM events_indexed").Scan(&height)
+	if err != nil {
+		return errors.Wrap(err, "getting event index height")
+	}
+	return follow(ctx, bs, height+1, func(b *bc.Block) error {
+		return s.IndexBlock(ctx, b)
+	})
+}
+
+// IndexBlock adds the log entries of the transactions in b to the index.
+func (s *eventStore) IndexBlock(ctx context.Context, b *bc.Block) error {
+	dbtx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrapf(err, "beginning db transaction for indexing events of block %d", b.Height)
+	}
+	defer dbtx.Rollback()
+
+	for i, tx := range b.Transactions {
+		for _, ev := range txEvents(tx) {
+			var amount sql.NullInt64
+			if ev.Amount != nil {
+				amount = sql.NullInt64{Int64: *ev.Amount, Valid: true}
+			}
+			_, err = dbtx.ExecContext(ctx, "INSERT OR IGNORE INTO events (height, tx_index, tx_id, log_pos, type, seed, contract_id, asset_id, amount, data) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)", b.Height, i, tx.ID.Bytes(), ev.LogPos, ev.Type, unhex(ev.Seed), unhex(ev.ContractID), unhex(ev.AssetID), amount, unhex(ev.Data))
+			if err != nil {
+				return errors.Wrapf(err, "indexing event %d of tx %x", ev.LogPos, tx.ID.Bytes())
+			}
+			for _, pubkey := range ev.Pubkeys {
+				_, err = dbtx.ExecContext(ctx, "INSERT OR IGNORE INTO event_pubkeys (height, tx_index, log_pos, pubkey) VALUES ($1, $2, $3, $4)", b.Height, i, ev.LogPos, unhex(pubkey))
+				if err != nil {
+					return errors.Wrapf(err, "indexing pubkey of event %d of tx %x", ev.LogPos, tx.ID.Bytes())
+				}
+			}
+			if ev.Type == "input" {
+				// An input is controlled by the pubkeys of the output it spends.
+				const q = "INSERT OR IGNORE INTO event_pubkeys (height, tx_index, log_pos, pubkey) SELECT $1, $2, $3, p.pubkey FROM events e JOIN event_pubkeys p ON p.height = e.height AND p.tx_index = e.tx_index AND p.log_pos = e.log_pos WHERE e.type = 'output' AND e.contract_id = $4"
+				_, err = dbtx.ExecContext(ctx, q, b.Height, i, ev.LogPos, unhex(ev.ContractID))
+				if err != nil {
+					return errors.Wrapf(err, "indexing pubkeys of input event %d of tx %x", ev.LogPos, tx.ID.Bytes())
+				}
+			}
+		}
+	}
+
+	_, err = dbtx.ExecContext(ctx, "INSERT INTO events_indexed (height) VALUES ($1)", b.Height)
+	if err != nil {
+		return errors.Wrapf(err, "recording event index height %d", b.Height)
+	}
+	return errors.Wrapf(dbtx.Commit(), "committing event index of block %d", b.Height)
+}
+
+// unhex decodes the hex string s,
+// which txEvents produced,
+// returning nil (for SQL NULL) if it is empty.
+func unhex(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// txEvents decodes the log entries of tx that eventStore indexes.
+// The result lacks block coordinates.
+func txEvents(tx *bc.Tx) []*event {
+	var (
+		res    = txresult.New(tx)
+		events = make(map[int]*event) // by log position
+	)
+	get := func(pos int) *event {
+		ev := events[pos]
+		if ev == nil {
+			ev = &event{LogPos: pos}
+			events[pos] = ev
+		}
+		return ev
+	}
+	setValue := func(ev *event, v *txresult.Value, refdata []byte) {
+		if v != nil {
+			amount := int64(v.Amount)
+			ev.AssetID = hex.EncodeToString(v.AssetID.Bytes())
+			ev.Amount = &amount
+		}
+		if len(refdata) > 0 {
+			ev.Data = hex.EncodeToString(refdata)
+		}
+	}
+	for i, out := range tx.Outputs {
+		ev := get(out.LogPos)
+		ev.ContractID = hex.EncodeToString(out.ID.Bytes())
+		ev.Seed = hex.EncodeToString(out.Seed.Bytes())
+		setValue(ev, res.Outputs[i].Value, res.Outputs[i].RefData)
+		for _, pubkey := range res.Outputs[i].Pubkeys {
+			ev.Pubkeys = append(ev.Pubkeys, hex.EncodeToString(pubkey))
+		}
+	}
+	for i, inp := range tx.Inputs {
+		ev := get(inp.LogPos)
+		ev.ContractID = hex.EncodeToString(inp.ID.Bytes())
+		ev.Seed = hex.EncodeToString(inp.Seed.Bytes())
+		setValue(ev, res.Inputs[i].Value, res.Inputs[i].RefData)
+	}
+	for i, iss := range tx.Issuances {
+		setValue(get(iss.LogPos), res.Issuances[i].Value, res.Issuances[i].RefData)
+	}
+	for i, ret := range tx.Retirements {
+		setValue(get(ret.LogPos), res.Retirements[i].Value, res.Retirements[i].RefData)
+	}
+
+	var result []*event
+	for pos, entry := range tx.Log {
+		if len(entry) < 2 {
+			continue
+		}
+		code, ok := entry[0].(txvm.Bytes)
+		if !ok || len(code) != 1 {
+			continue
+		}
+		typ, ok := eventTypes[code[0]]
+		if !ok {
+			continue
+		}
+		ev := get(pos)
+		ev.Type = typ
+		if seed, ok := entry[1].(txvm.Bytes); ok && ev.Seed == "" {
+			ev.Seed = hex.EncodeToString(seed)
+		}
+		if code[0] == txvm.LogCode && len(entry) > 2 {
+			switch data := entry[2].(type) {
+			case txvm.Bytes:
+				ev.Data = hex.EncodeToString(data)
+			case txvm.Int:
+				amount := int64(data)
+				ev.Amount = &amount
+			}
+		}
+		result = append(result, ev)
+	}
+	return result
+}
+
+// eventFilter selects indexed events.
+// Empty fields match all events.
+// An event matches a list if it matches any item in it.
+type eventFilter struct {
+	Type     string
+	AssetIDs [][]byte
+	Pubkeys  [][]byte
+	Seeds    [][]byte
+	From, To uint64 // block heights, inclusive
+	Limit    int
+}
+
+// maxEventFilterItems is the longest list of each kind that an eventFilter may have.
+const maxEventFilterItems = 100
+
+// Events returns the indexed events matching f,
+// in order of block height, position in the block, and position in the tx log.
+func (s *eventStore) Events(ctx context.Context, f eventFilter) ([]*event, error) {
+	var typ sql.NullString
+	if f.Type != "" {
+		typ = sql.NullString{String: f.Type, Valid: true}
+	}
+	q := "SELECT height, tx_index, tx_id, log_pos, type, seed, contract_id, asset_id, amount, data FROM events e WHERE ($1 IS NULL OR type = $1) AND height >= $2 AND height <= $3"
+	args := []interface{}{typ, f.From, f.To}
+	in := func(items [][]byte) string {
+		var placeholders []string
+		for _, item := range items {
+			args = append(args, item)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		}
+		return "(" + strings.Join(placeholders, ", ") + ")"
+	}
+	if len(f.AssetIDs) > 0 {
+		q += " AND asset_id IN " + in(f.AssetIDs)
+	}
+	if len(f.Seeds) > 0 {
+		q += " AND seed IN " + in(f.Seeds)
+	}
+	if len(f.Pubkeys) > 0 {
+		q += " AND EXISTS (SELECT 1 FROM event_pubkeys p WHERE p.height = e.height AND p.tx_index = e.tx_index AND p.log_pos = e.log_pos AND p.pubkey IN " + in(f.Pubkeys) + ")"
+	}
+	args = append(args, f.Limit)
+	q += fmt.Sprintf(" ORDER BY height, tx_index, log_pos LIMIT $%d", len(args))
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying events")
+	}
+	defer rows.Close()
+
+	result := []*event{}
+	for rows.Next() {
+		var (
+			txID, seed, contractID, assetID, data []byte
+			amount                                sql.NullInt64
+			ev                                    event
+		)
+		err = rows.Scan(&ev.Height, &ev.TxIndex, &txID, &ev.LogPos, &ev.Type, &seed, &contractID, &assetID, &amount, &data)
+		if err != nil {
+			return nil, errors.Wrap(err, "scanning event")
+		}
+		ev.TxID = hex.EncodeToString(txID)
+		ev.Seed = hex.EncodeToString(seed)
+		ev.ContractID = hex.EncodeToString(contractID)
+		ev.AssetID = hex.EncodeToString(assetID)
+		ev.Data = hex.EncodeToString(data)
+		if amount.Valid {
+			ev.Amount = &amount.Int64
+		}
+		result = append(result, &ev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating over events")
+	}
+
+	for _, ev := range result {
+		ev.Pubkeys, err = s.eventPubkeys(ctx, ev)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (s *eventStore) eventPubkeys(ctx context.Context, ev *event) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT pubkey FROM event_pubkeys WHERE height = $1 AND tx_index = $2 AND log_pos = $3 ORDER BY pubkey", ev.Height, ev.TxIndex, ev.LogPos)
+	if err != nil {
+		return nil, errors.Wrapf(err, "querying pubkeys of event %d of tx %s", ev.LogPos, ev.TxID)
+	}
+	defer rows.Close()
+
+	var pubkeys []string
+	for rows.Next() {
+		var pubkey []byte
+		err = rows.Scan(&pubkey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "scanning pubkey of event %d of tx %s", ev.LogPos, ev.TxID)
+		}
+		pubkeys = append(pubkeys, hex.EncodeToString(pubkey))
+	}
+	return pubkeys, errors.Wrapf(rows.Err(), "iterating over pubkeys of event %d of tx %s", ev.LogPos, ev.TxID)
+}
+
+// eventsHandler handles /events?type=T&asset=A&pubkey=P&seed=S&from=H1&to=H2&limit=N.
+// All parameters are optional.
+// Each of asset, pubkey, and seed may be repeated
+// (or hold a comma-separated list)
+// to match any of several values.
+func (n *node) eventsHandler(w http.ResponseWriter, req *http.Request) {
+	f := eventFilter{
+		Type:  req.FormValue("type"),
+		From:  1,
+		To:    n.store.FinalHeight(),
+		Limit: 100,
+	}
+	if f.Type != "" {
+		var known bool
+		for _, typ := range eventTypes {
+			known = known || typ == f.Type
+		}
+		if !known {
+			httpErrf(w, http.StatusBadRequest, "unknown event type %q", f.Type)
+			return
+		}
+	}
+
+	var err error
+	for _, p := range []struct {
+		name string
+		dest *[][]byte
+	}{{"asset", &f.AssetIDs}, {"pubkey", &f.Pubkeys}, {"seed", &f.Seeds}} {
+		*p.dest, err = hexListParam(req, p.name)
+		if err != nil {
+			httpErrf(w, http.StatusBadRequest, "parsing %s: %s", p.name, err)
+			return
+		}
+		if len(*p.dest) > maxEventFilterItems {
+			httpErrf(w, http.StatusBadRequest, "more than %d values of %s", maxEventFilterItems, p.name)
+			return
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dest *uint64
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if s := req.FormValue(p.name); s != "" {
+			*p.dest, err = strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				httpErrf(w, http.StatusBadRequest, "parsing %s: %s", p.name, err)
+				return
+			}
+		}
+	}
+	if s := req.FormValue("limit"); s != "" {
+		f.Limit, err = strconv.Atoi(s)
+		if err != nil || f.Limit < 1 || f.Limit > 1000 {
+			httpErrf(w, http.StatusBadRequest, "limit must be from 1 to 1000")
+			return
+		}
+	}
+
+	events, err := n.events.Events(req.Context(), f)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "getting events: %s", err)
+		return
+	}
+	respondJSON(w, events)
+}
+
+// hexListParam parses the hex-encoded values of the request parameter with the given name,
+// which may be repeated or hold a comma-separated list.
+func hexListParam(req *http.Request, name string) ([][]byte, error) {
+	req.ParseForm()
+	var result [][]byte
+	for _, val := range req.Form[name] {
+		for _, s := range strings.Split(val, ",") {
+			if s == "" {
+				continue
+			}
+			b, err := hex.DecodeString(s)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, b)
+		}
+	}
+	return result, nil
+}
+
+const eventsSchema = `
+CREATE TABLE IF NOT EXISTS events_indexed (
+  height INTEGER NOT NULL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS events (
+  height INTEGER NOT NULL,
+  tx_index INTEGER NOT NULL,
+  tx_id BLOB NOT NULL,
+  log_pos INTEGER NOT NULL,
+  type TEXT NOT NULL,
+  seed BLOB,
+  contract_id BLOB,
+  asset_id BLOB,
+  amount INTEGER,
+  data BLOB,
+  PRIMARY KEY (height, tx_index, log_pos)
+);
+
+CREATE INDEX IF NOT EXISTS events_type ON events (type, height);
+
+CREATE INDEX IF NOT EXISTS events_asset_id ON events (asset_id, height);
+
+CREATE INDEX IF NOT EXISTS events_seed ON events (seed, height);
+
+CREATE INDEX IF NOT EXISTS events_contract_id ON events (contract_id);
+
+CREATE TABLE IF NOT EXISTS event_pubkeys (
+  height INTEGER NOT NULL,
+  tx_index INTEGER NOT NULL,
+  log_pos INTEGER NOT NULL,
+  pubkey BLOB NOT NULL,
+  PRIMARY KEY (height, tx_index, log_pos, pubkey)
+);
+
+CREATE INDEX IF NOT EXISTS event_pubkeys_pubkey ON event_pubkeys (pubkey, height);
+`
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chain/txvm/protocol/txbuilder/standard"
+)
+
+func TestEvents(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.events, err = newEventStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
+	n.do(func() {
+		_, err = n.commitNow(ctx, tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := n.store.GetBlock(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.events.IndexBlock(ctx, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assetID := hex.EncodeToString(tx.Issuances[0].AssetID.Bytes())
+	_, pub := testKeys(t)
+	get := func(query string) []*event {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		n.eventsHandler(rec, httptest.NewRequest("GET", "/events?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d for %s: %s", rec.Code, query, rec.Body)
+		}
+		var got []*event
+		err := json.NewDecoder(rec.Body).Decode(&got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	all := get("")
+	types := make(map[string]int)
+	for _, ev := range all {
+		if ev.Height != 2 || ev.TxIndex != 0 || ev.TxID != hex.EncodeToString(tx.ID.Bytes()) {
+			t.Errorf("got event at height %d, tx %d (%s), want height 2, tx 0 (%x)", ev.Height, ev.TxIndex, ev.TxID, tx.ID.Bytes())
+		}
+		types[ev.Type]++
+	}
+	if types["issuance"] != 1 || types["output"] != 1 {
+		t.Errorf("got event types %v, want one issuance and one output", types)
+	}
+
+	outs := get("type=output&asset=" + assetID)
+	if len(outs) != 1 {
+		t.Fatalf("got %d output events, want 1", len(outs))
+	}
+	if out := outs[0]; out.Amount == nil || *out.Amount != 10 || out.ContractID != hex.EncodeToString(tx.Outputs[0].ID.Bytes()) {
+		t.Errorf("got output event %+v, want output %x of 10", out, tx.Outputs[0].ID.Bytes())
+	}
+
+	if out := outs[0]; len(out.Pubkeys) != 1 || out.Pubkeys[0] != hex.EncodeToString(pub) {
+		t.Errorf("got output pubkeys %v, want [%x]", out.Pubkeys, pub)
+	}
+
+	other := strings.Repeat("01", 32)
+	filters := []struct {
+		query string
+		want  int
+	}{
+		{"pubkey=" + hex.EncodeToString(pub), 1},
+		{"pubkey=" + other, 0},
+		{"pubkey=" + other + "," + hex.EncodeToString(pub), 1},
+		{"type=output&seed=" + hex.EncodeToString(standard.PayToMultisigSeed2[:]), 1},
+		{"seed=" + other, 0},
+		{"asset=" + other + "&asset=" + assetID, 2},
+		{"type=output&asset=" + other, 0},
+	}
+	for _, f := range filters {
+		if got := get(f.query); len(got) != f.want {
+			t.Errorf("got %d events for %s, want %d", len(got), f.query, f.want)
+
+		}
+	}
+
+	if got := get("from=3"); len(got) != 0 {
+		t.Errorf("got %d events from height 3, want 0", len(got))
+	}
+	if got := get("limit=1"); len(got) != 1 {
+		t.Errorf("got %d events with limit 1, want 1", len(got))
+	}
+
+	rec := httptest.NewRecorder()
+	n.eventsHandler(rec, httptest.NewRequest("GET", "/events?type=bogus", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for unknown type, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
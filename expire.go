@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// checkTxTime checks the timeranges of tx
+// against blockTimeMS,
+// the expected timestamp of the next block.
+// A tx whose timerange has already ended
+// gets status 410,
+// so that clients can tell it apart from other rejections
+// and know that resubmitting it is pointless;
+// one whose timerange has not yet begun gets status 400.
+func checkTxTime(tx *bc.Tx, blockTimeMS uint64) (int, error) {
+	for _, tr := range tx.Timeranges {
+		if tr.MaxMS > 0 && blockTimeMS > uint64(tr.MaxMS) {
+			return http.StatusGone, fmt.Errorf("tx %x expired at %d, before the next block at %d", tx.ID.Bytes(), tr.MaxMS, blockTimeMS)
+		}
+		if tr.MinMS > 0 && blockTimeMS < uint64(tr.MinMS) {
+			return http.StatusBadRequest, fmt.Errorf("tx %x is not valid until %d, after the next block at %d", tx.ID.Bytes(), tr.MinMS, blockTimeMS)
+		}
+	}
+	return 0, nil
+}
+
+// evict removes from the pool the pending transactions
+// (and the bundles containing them)
+// whose timeranges end before blockTimeMS,
+// or that have been pending longer than p.TTL as of now,
+// and returns them.
+func (p *txPool) evict(blockTimeMS uint64, now time.Time) []rejectedTx {
+	var (
+		kept    []*pendingTx
+		evicted []rejectedTx
+	)
+	for _, ptx := range p.txs {
+		reason := p.evictReason(ptx, blockTimeMS, now)
+		if reason == "" {
+			kept = append(kept, ptx)
+			continue
+		}
+		for _, tx := range ptx.txs {
+			p.logf("evicting tx %x: %s", tx.Tx.ID.Bytes(), reason)
+			evicted = append(evicted, rejectedTx{
+				TxID:   hex.EncodeToString(tx.Tx.ID.Bytes()),
+				Reason: reason,
+			})
+		}
+	}
+	p.txs = kept
+	return evicted
+}
+
+func (p *txPool) evictReason(ptx *pendingTx, blockTimeMS uint64, now time.Time) string {
+	if p.TTL > 0 && now.Sub(ptx.added) > p.TTL {
+		return fmt.Sprintf("pending longer than %s", p.TTL)
+	}
+	for _, tx := range ptx.txs {
+		for _, tr := range tx.Tx.Timeranges {
+			if tr.MaxMS > 0 && blockTimeMS > uint64(tr.MaxMS) {
+				return fmt.Sprintf("tx %x expired at %d, before block time %d", tx.Tx.ID.Bytes(), tr.MaxMS, blockTimeMS)
+			}
+		}
+	}
+	return ""
+}
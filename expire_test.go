@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+func TestCheckTxTime(t *testing.T) {
+	ctx := context.Background()
+
+	b1, err := protocol.NewInitialBlock(nil, 0, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := testIssuance(ctx, t, b1, 10) // expires in a minute
+
+	if code, err := checkTxTime(tx, bc.Millis(time.Now().Add(time.Second))); err != nil {
+		t.Errorf("got error %v (code %d) for the next block, want none", err, code)
+	}
+	if code, _ := checkTxTime(tx, bc.Millis(time.Now().Add(2*time.Minute))); code != http.StatusGone {
+		t.Errorf("got code %d for a block after the tx expires, want %d", code, http.StatusGone)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	ctx := context.Background()
+
+	b1, err := protocol.NewInitialBlock(nil, 0, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx1 := testIssuance(ctx, t, b1, 10)
+	tx2 := testIssuance(ctx, t, b1, 20)
+	tx3 := testIssuance(ctx, t, b1, 30)
+
+	logbuf := new(bytes.Buffer)
+	p := &txPool{TTL: time.Hour, log: log.New(logbuf, "chain test: ", 0)}
+	p.add(tx1)
+	p.add(tx2, tx3)
+
+	now := time.Now()
+	if evicted := p.evict(bc.Millis(now), now); len(evicted) != 0 {
+		t.Errorf("evicted %d txs before expiration, want 0", len(evicted))
+	}
+
+	p.txs[0].added = now.Add(-2 * time.Hour)
+	evicted := p.evict(bc.Millis(now), now)
+	if len(evicted) != 1 || evicted[0].TxID != hex.EncodeToString(tx1.ID.Bytes()) {
+		t.Errorf("got evicted %+v, want only tx1 for its TTL", evicted)
+	}
+	if !strings.HasPrefix(logbuf.String(), "chain test: evicting tx") {
+		t.Errorf("got log %q, want it from the pool's logger", logbuf)
+	}
+
+	evicted = p.evict(bc.Millis(now.Add(2*time.Minute)), now)
+	if len(evicted) != 2 {
+		t.Errorf("evicted %d txs after expiration, want the 2 in the bundle", len(evicted))
+	}
+	if p.len() != 0 {
+		t.Errorf("%d txs left in the pool, want 0", p.len())
+	}
+}
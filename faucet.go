@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/txbuilder/txresult"
+)
+
+// faucet issues a test asset on request.
+type faucet struct {
+	prv ed25519.PrivateKey
+	pub ed25519.PublicKey
+	max int64 // largest amount per request
+}
+
+// readFaucetKey reads the faucet's key pair
+// from a file containing a hex-encoded 32-byte seed.
+func readFaucetKey(filename string) (ed25519.PrivateKey, ed25519.PublicKey, error) {
+	seedHex, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading faucet key")
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(seedHex)))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parsing faucet key")
+	}
+	if len(seed) != 32 {
+		return nil, nil, fmt.Errorf("faucet key is %d bytes long, want 32", len(seed))
+	}
+	pub, prv, err := ed25519.GenerateKey(bytes.NewReader(seed))
+	return prv, pub, errors.Wrap(err, "deriving faucet key")
+}
+
+// faucetIssue handles /faucet?amount=N&pubkey=P,
+// submitting a transaction that issues N units of the faucet's asset
+// to the hex-encoded pubkey P.
+// The response is a devIssuance,
+// whose height is zero unless the transaction was committed immediately in -dev mode.
+func (n *node) faucetIssue(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if req.Method != "POST" {
+		httpErrf(w, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+		return
+	}
+	amount, err := strconv.ParseInt(req.FormValue("amount"), 10, 64)
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing amount: %s", err)
+		return
+	}
+	if amount <= 0 || amount > n.faucet.max {
+		httpErrf(w, http.StatusBadRequest, "amount must be from 1 to %d", n.faucet.max)
+		return
+	}
+	pubkey, err := hexParam(req, "pubkey")
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing pubkey: %s", err)
+		return
+	}
+	if len(pubkey) != ed25519.PublicKeySize {
+		httpErrf(w, http.StatusBadRequest, "pubkey is %d bytes long, want %d", len(pubkey), ed25519.PublicKeySize)
+		return
+	}
+
+	tx, assetID, err := n.issuance(ctx, n.faucet.prv, n.faucet.pub, ed25519.PublicKey(pubkey), amount)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "building issuance: %s", err)
+		return
+	}
+
+	sub := n.offer(ctx, tx, "")
+	if sub.err != nil {
+		httpErrf(w, sub.code, "submitting issuance %x: %s", tx.ID.Bytes(), sub.err)
+		return
+	}
+	n.log.Printf("faucet issued %d to %x in tx %x", amount, pubkey, tx.ID.Bytes())
+
+	res := devIssuance{
+		TxID:    hex.EncodeToString(tx.ID.Bytes()),
+		Height:  sub.height,
+		AssetID: hex.EncodeToString(assetID.Bytes()),
+		Amount:  amount,
+		Pubkey:  hex.EncodeToString(pubkey),
+	}
+	for _, out := range txresult.New(tx).Outputs {
+		res.OutputID = hex.EncodeToString(out.OutputID.Bytes())
+	}
+	respondJSON(w, res)
+}
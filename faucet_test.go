@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFaucet(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.dev = true
+
+	f, err := ioutil.TempFile("", "txvmbcdfaucet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strings.Repeat("ab", 32) + "\n")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prv, pub, err := readFaucetKey(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.faucet = &faucet{prv: prv, pub: pub, max: 100}
+
+	_, recipient := testKeys(t)
+	pubkey := hex.EncodeToString(recipient)
+
+	rec := httptest.NewRecorder()
+	n.faucetIssue(rec, httptest.NewRequest("POST", "/faucet?amount=50&pubkey="+pubkey, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var iss devIssuance
+	err = json.NewDecoder(rec.Body).Decode(&iss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iss.Height != 2 {
+		t.Errorf("got issuance in block %d, want 2", iss.Height)
+	}
+	if iss.Pubkey != pubkey {
+		t.Errorf("got recipient %s, want %s", iss.Pubkey, pubkey)
+	}
+
+	for _, query := range []string{"amount=101&pubkey=" + pubkey, "amount=50"} {
+		rec = httptest.NewRecorder()
+		n.faucetIssue(rec, httptest.NewRequest("POST", "/faucet?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("got status %d for %s, want %d", rec.Code, query, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// fsckCmd implements "txvmbcd fsck -db DBFILE".
+func fsckCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	dbfile := fs.String("db", "", "path to block storage db")
+	fs.Parse(args)
+
+	if *dbfile == "" {
+		return errors.New("fsck requires -db")
+	}
+	if _, err := os.Stat(*dbfile); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+*dbfile+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	problems, err := fsck(ctx, db, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	return nil
+}
+
+// fsck checks the blocks in db,
+// verifying that they run contiguously from a genesis block at height 1,
+// that each is stored under its own height and hash,
+// and that each names its predecessor as its previous block.
+// It reports each problem to w and returns the number found.
+func fsck(ctx context.Context, db *sql.DB, w io.Writer) (int, error) {
+	version, err := dbSchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	if version > schemaVersion {
+		return 0, fmt.Errorf("db schema version %d is newer than this program's version %d", version, schemaVersion)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT height, hash, bits FROM blocks ORDER BY height")
+	if err != nil {
+		return 0, errors.Wrap(err, "querying blocks")
+	}
+	defer rows.Close()
+
+	var (
+		problems int
+		want     uint64 = 1
+		prevHash []byte
+	)
+	report := func(format string, args ...interface{}) {
+		problems++
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+	for rows.Next() {
+		var (
+			height     uint64
+			hash, bits []byte
+		)
+		err = rows.Scan(&height, &hash, &bits)
+		if err != nil {
+			return problems, errors.Wrap(err, "scanning block row")
+		}
+		if height != want {
+			report("blocks %d through %d are missing", want, height-1)
+			prevHash = nil
+		}
+		want = height + 1
+
+		b := new(bc.Block)
+		err = b.FromBytes(bits)
+		if err != nil {
+			report("block %d does not parse: %s", height, err)
+			prevHash = nil
+			continue
+		}
+		if b.Height != height {
+			report("block %d is stored at height %d", b.Height, height)
+		}
+		actualHash := b.Hash().Bytes()
+		if !bytes.Equal(actualHash, hash) {
+			report("block %d is stored under hash %x but has hash %x", height, hash, actualHash)
+		}
+		if height > 1 && prevHash != nil {
+			if b.PreviousBlockId == nil || !bytes.Equal(b.PreviousBlockId.Bytes(), prevHash) {
+				report("block %d does not follow block %d", height, height-1)
+			}
+		}
+		prevHash = actualHash
+	}
+	if err = rows.Err(); err != nil {
+		return problems, errors.Wrap(err, "iterating over blocks")
+	}
+	if want == 1 {
+		report("no blocks")
+	}
+	return problems, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	s, err := newBlockStore(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, err := s.GetBlock(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2 := testBlock(t, b1, testIssuance(ctx, t, b1, 10))
+	err = s.SaveBlock(ctx, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := dbSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != schemaVersion {
+		t.Errorf("got schema version %d, want %d", version, schemaVersion)
+	}
+
+	buf := new(bytes.Buffer)
+	problems, err := fsck(ctx, db, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems != 0 {
+		t.Fatalf("got %d problems in a good db, want 0:\n%s", problems, buf)
+	}
+
+	_, err = db.Exec("UPDATE blocks SET hash = $1 WHERE height = 2", make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("UPDATE blocks SET height = 4 WHERE height = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	problems, err = fsck(ctx, db, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Missing blocks 2-3, wrong height, wrong hash.
+	if problems != 3 {
+		t.Errorf("got %d problems, want 3:\n%s", problems, buf)
+	}
+
+	_, err = db.Exec("PRAGMA user_version = 99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newBlockStore(db, nil)
+	if err == nil {
+		t.Error("opened a db with a newer schema version, want error")
+	}
+}
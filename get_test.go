@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetCaching(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	etag := fmt.Sprintf(`"%x"`, n.initialBlock.Hash().Bytes())
+
+	rec := httptest.NewRecorder()
+	n.get(rec, httptest.NewRequest("GET", "/get?height=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("ETag"); got != etag {
+		t.Errorf("got ETag %s, want %s", got, etag)
+	}
+
+	req := httptest.NewRequest("GET", "/get?height=1", nil)
+	req.Header.Set("If-None-Match", `"abc", `+etag)
+	rec = httptest.NewRecorder()
+	n.get(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotModified)
+	}
+	if rec.Body.Len() > 0 {
+		t.Errorf("got %d-byte body with 304 response", rec.Body.Len())
+	}
+
+	rec = httptest.NewRecorder()
+	n.get(rec, httptest.NewRequest("GET", "/get?height=2&wait=10ms", nil))
+	if rec.Code != http.StatusRequestTimeout {
+		t.Errorf("got status %d waiting for block 2, want %d", rec.Code, http.StatusRequestTimeout)
+	}
+
+	rec = httptest.NewRecorder()
+	n.get(rec, httptest.NewRequest("GET", "/get?height=2&wait=bogus", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d with bad wait, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -1,10 +1,21 @@
 module github.com/bobg/txvmbcd
 
+go 1.27.1
+
 require (
 	github.com/chain/txvm v0.0.0-20190114205213-d4707728bddc
 	github.com/davecgh/go-spew v1.1.1
 	github.com/golang/protobuf v1.2.0
+	github.com/gomodule/redigo v1.8.9
 	github.com/mattn/go-sqlite3 v1.10.0
+)
+
+require (
 	github.com/miscreant/miscreant v0.3.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.1.0 // indirect
+	github.com/stretchr/testify v1.7.0 // indirect
 	golang.org/x/sync v0.0.0-20181221193216-37e7f081c4d4 // indirect
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
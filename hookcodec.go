@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/chain/txvm/errors"
+	"github.com/golang/protobuf/proto"
+)
+
+// hookCodec is a format for webhook notification bodies.
+type hookCodec struct {
+	contentType string
+	encode      func(ctx context.Context, n *hookNotifier, ev *hookEvent) ([]byte, error)
+}
+
+// hookCodecs maps the name of each codec a hook may choose to its implementation.
+// The default is json.
+var hookCodecs = map[string]hookCodec{
+	"json":     {"application/json", encodeHookJSON},
+	"protobuf": {"application/x-protobuf", encodeHookProto},
+	"cbor":     {"application/cbor", encodeHookCBOR},
+	"avro":     {"avro/binary", encodeHookAvro},
+}
+
+func encodeHookJSON(_ context.Context, _ *hookNotifier, ev *hookEvent) ([]byte, error) {
+	return json.Marshal(ev)
+}
+
+// encodeHookProto encodes ev as this protobuf message:
+//
+//	message HookEvent {
+//	  int64 hook_id = 1;
+//	  uint64 height = 2;
+//	  string block_id = 3;
+//	  repeated string tx_ids = 4;
+//	}
+func encodeHookProto(_ context.Context, _ *hookNotifier, ev *hookEvent) ([]byte, error) {
+	const (
+		varint          = 0
+		lengthDelimited = 2
+	)
+	buf := proto.NewBuffer(nil)
+	buf.EncodeVarint(1<<3 | varint)
+	buf.EncodeVarint(uint64(ev.HookID))
+	buf.EncodeVarint(2<<3 | varint)
+	buf.EncodeVarint(ev.Height)
+	buf.EncodeVarint(3<<3 | lengthDelimited)
+	buf.EncodeStringBytes(ev.BlockID)
+	for _, txID := range ev.TxIDs {
+		buf.EncodeVarint(4<<3 | lengthDelimited)
+		buf.EncodeStringBytes(txID)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeHookCBOR encodes ev as a CBOR map with the same keys as its JSON form.
+func encodeHookCBOR(_ context.Context, _ *hookNotifier, ev *hookEvent) ([]byte, error) {
+	const (
+		uintType   = 0
+		stringType = 3
+		arrayType  = 4
+		mapType    = 5
+	)
+	var buf []byte
+	head := func(major byte, n uint64) {
+		var arg [8]byte
+		binary.BigEndian.PutUint64(arg[:], n)
+		switch {
+		case n < 24:
+			buf = append(buf, major<<5|byte(n))
+		case n <= 0xff:
+			buf = append(buf, major<<5|24)
+			buf = append(buf, arg[7:]...)
+		case n <= 0xffff:
+			buf = append(buf, major<<5|25)
+			buf = append(buf, arg[6:]...)
+		case n <= 0xffffffff:
+			buf = append(buf, major<<5|26)
+			buf = append(buf, arg[4:]...)
+		default:
+			buf = append(buf, major<<5|27)
+			buf = append(buf, arg[:]...)
+		}
+	}
+	str := func(s string) {
+		head(stringType, uint64(len(s)))
+		buf = append(buf, s...)
+	}
+
+	head(mapType, 4)
+	str("hook_id")
+	head(uintType, uint64(ev.HookID))
+	str("height")
+	head(uintType, ev.Height)
+	str("block_id")
+	str(ev.BlockID)
+	str("tx_ids")
+	head(arrayType, uint64(len(ev.TxIDs)))
+	for _, txID := range ev.TxIDs {
+		str(txID)
+	}
+	return buf, nil
+}
+
+// hookAvroSchema is the Avro schema of a notification,
+// registered with the -schema-registry under hookAvroSubject.
+const hookAvroSchema = `{"type":"record","name":"HookEvent","namespace":"txvmbcd","fields":[{"name":"hook_id","type":"long"},{"name":"height","type":"long"},{"name":"block_id","type":"string"},{"name":"tx_ids","type":{"type":"array","items":"string"}}]}`
+
+const hookAvroSubject = "txvmbcd-hook-event-value"
+
+// encodeHookAvro encodes ev in Avro binary form
+// with the schema registry's wire-format prefix:
+// a zero byte and the 4-byte big-endian ID of hookAvroSchema.
+func encodeHookAvro(ctx context.Context, n *hookNotifier, ev *hookEvent) ([]byte, error) {
+	schemaID, err := n.avroSchemaID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 5)
+	binary.BigEndian.PutUint32(buf[1:], schemaID)
+	long := func(x int64) {
+		var varint [binary.MaxVarintLen64]byte
+		buf = append(buf, varint[:binary.PutVarint(varint[:], x)]...) // zigzag, as Avro requires
+	}
+	str := func(s string) {
+		long(int64(len(s)))
+		buf = append(buf, s...)
+	}
+
+	long(ev.HookID)
+	long(int64(ev.Height))
+	str(ev.BlockID)
+	if len(ev.TxIDs) > 0 {
+		long(int64(len(ev.TxIDs)))
+		for _, txID := range ev.TxIDs {
+			str(txID)
+		}
+	}
+	long(0) // end of array
+	return buf, nil
+}
+
+// schemaRegistry holds the ID of hookAvroSchema in a schema registry,
+// registering it on first use.
+type schemaRegistry struct {
+	url string
+
+	mu sync.Mutex
+	id uint32 // 0 until registered
+}
+
+// avroSchemaID returns the registry's ID for hookAvroSchema.
+func (n *hookNotifier) avroSchemaID(ctx context.Context) (uint32, error) {
+	r := n.registry
+	if r == nil {
+		return 0, errors.New("avro requires -schema-registry")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.id != 0 {
+		return r.id, nil
+	}
+
+	reqBody, err := json.Marshal(struct {
+		Schema string `json:"schema"`
+	}{hookAvroSchema})
+	if err != nil {
+		return 0, err
+	}
+	url := strings.TrimSuffix(r.url, "/") + "/subjects/" + hookAvroSubject + "/versions"
+	req, err := http.NewRequest("POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, errors.Wrap(err, "registering avro schema")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading schema registry response")
+	}
+	if resp.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("registering avro schema: status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	var res struct {
+		ID uint32 `json:"id"`
+	}
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return 0, errors.Wrap(err, "parsing schema registry response")
+	}
+	if res.ID == 0 {
+		return 0, errors.New("schema registry returned no schema ID")
+	}
+	r.id = res.ID
+	return r.id, nil
+}
+
+// migrateHookCodecs adds the codec column to the hooks table, if there is one.
+func migrateHookCodecs(dbtx *sql.Tx) error {
+	var n int
+	err := dbtx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'hooks'").Scan(&n)
+	if err != nil || n == 0 {
+		return err
+	}
+	_, err = dbtx.Exec("ALTER TABLE hooks ADD COLUMN codec TEXT NOT NULL DEFAULT 'json'")
+	return err
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestHookCodecs(t *testing.T) {
+	ctx := context.Background()
+
+	ev := &hookEvent{
+		HookID:  7,
+		Height:  300,
+		BlockID: "b1",
+		TxIDs:   []string{"t1", "t2"},
+	}
+
+	t.Run("protobuf", func(t *testing.T) {
+		bits, err := encodeHookProto(ctx, nil, ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got hookEvent
+		buf := proto.NewBuffer(bits)
+		for {
+			key, err := buf.DecodeVarint()
+			if err != nil {
+				break // end of message
+			}
+			switch key {
+			case 1<<3 | 0:
+				x, _ := buf.DecodeVarint()
+				got.HookID = int64(x)
+			case 2<<3 | 0:
+				got.Height, _ = buf.DecodeVarint()
+			case 3<<3 | 2:
+				got.BlockID, _ = buf.DecodeStringBytes()
+			case 4<<3 | 2:
+				s, _ := buf.DecodeStringBytes()
+				got.TxIDs = append(got.TxIDs, s)
+			default:
+				t.Fatalf("unexpected key %d", key)
+			}
+		}
+		if !reflect.DeepEqual(&got, ev) {
+			t.Errorf("got %+v, want %+v", got, *ev)
+		}
+	})
+
+	t.Run("cbor", func(t *testing.T) {
+		bits, err := encodeHookCBOR(ctx, nil, ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []byte{0xa4}
+		want = append(want, 0x67)
+		want = append(want, "hook_id"...)
+		want = append(want, 0x07)
+		want = append(want, 0x66)
+		want = append(want, "height"...)
+		want = append(want, 0x19, 0x01, 0x2c)
+		want = append(want, 0x68)
+		want = append(want, "block_id"...)
+		want = append(want, 0x62, 'b', '1')
+		want = append(want, 0x66)
+		want = append(want, "tx_ids"...)
+		want = append(want, 0x82, 0x62, 't', '1', 0x62, 't', '2')
+		if !bytes.Equal(bits, want) {
+			t.Errorf("got %x, want %x", bits, want)
+		}
+	})
+
+	t.Run("avro", func(t *testing.T) {
+		var registrations int
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/subjects/"+hookAvroSubject+"/versions" {
+				http.NotFound(w, req)
+				return
+			}
+			var body struct {
+				Schema string `json:"schema"`
+			}
+			json.NewDecoder(req.Body).Decode(&body)
+			if body.Schema != hookAvroSchema {
+				t.Errorf("got schema %s, want %s", body.Schema, hookAvroSchema)
+			}
+			registrations++
+			w.Write([]byte(`{"id":42}`))
+		}))
+		defer registry.Close()
+
+		n := &hookNotifier{client: http.DefaultClient}
+		_, err := encodeHookAvro(ctx, n, ev)
+		if err == nil {
+			t.Error("got no error without a schema registry")
+		}
+
+		n.registry = &schemaRegistry{url: registry.URL}
+		for i := 0; i < 2; i++ {
+			bits, err := encodeHookAvro(ctx, n, ev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bits[0] != 0 || binary.BigEndian.Uint32(bits[1:5]) != 42 {
+				t.Fatalf("got prefix %x, want 000000002a", bits[:5])
+			}
+			r := bytes.NewReader(bits[5:])
+			long := func() int64 {
+				x, err := binary.ReadVarint(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return x
+			}
+			str := func() string {
+				b := make([]byte, long())
+				r.Read(b)
+				return string(b)
+			}
+			got := hookEvent{HookID: long(), Height: uint64(long()), BlockID: str()}
+			for n := long(); n != 0; n = long() {
+				for ; n > 0; n-- {
+					got.TxIDs = append(got.TxIDs, str())
+				}
+			}
+			if !reflect.DeepEqual(&got, ev) {
+				t.Errorf("got %+v, want %+v", got, *ev)
+			}
+		}
+		if registrations != 1 {
+			t.Errorf("registered the schema %d times, want 1", registrations)
+		}
+	})
+}
+
+func TestMigrateHookCodecs(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	// A hooks table from before codecs.
+	_, err := db.Exec("CREATE TABLE hooks (id INTEGER NOT NULL PRIMARY KEY, url TEXT NOT NULL, tx_id TEXT NOT NULL, asset_id TEXT NOT NULL, secret TEXT NOT NULL); INSERT INTO hooks (url, tx_id, asset_id, secret) VALUES ('http://example.com/', '', '', '')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = withTx(db, migrateHookCodecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := newHookNotifier(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs, err := n.all(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hs) != 1 || hs[0].Codec != "json" {
+		t.Errorf("got hooks %+v, want one with codec json", hs)
+	}
+
+	// A db without a hooks table.
+	db2, cleanup2 := testDB(t)
+	defer cleanup2()
+	err = withTx(db2, migrateHookCodecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func withTx(db *sql.DB, f func(*sql.Tx) error) error {
+	dbtx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	err = f(dbtx)
+	if err != nil {
+		dbtx.Rollback()
+		return err
+	}
+	return dbtx.Commit()
+}
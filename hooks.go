@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txbuilder/txresult"
+)
+
+// Delivery of a webhook notification is attempted up to hookAttempts times,
+// waiting hookRetryDelay after the first failure and doubling the wait after each subsequent one.
+var (
+	hookAttempts   = 5
+	hookRetryDelay = time.Second
+)
+
+// maxHookDeliveries is the number of notifications delivered (or awaiting retry) at once.
+// Further notifications wait for a free slot.
+var maxHookDeliveries = 16
+
+// hookSignatureHeader is the HTTP header carrying the hex-encoded
+// HMAC-SHA256 of a notification body, keyed with the hook's secret.
+const hookSignatureHeader = "X-Txvmbcd-Signature"
+
+// hookNotifier POSTs notifications of committed blocks
+// to the URLs registered in its hooks table.
+// Deliveries awaiting retry are kept only in memory
+// and are lost if the server restarts.
+type hookNotifier struct {
+	db       *sql.DB
+	client   *http.Client
+	registry *schemaRegistry // nil if there is no -schema-registry
+	sem      chan struct{}   // holds a token for each delivery in progress
+}
+
+func newHookNotifier(db *sql.DB) (*hookNotifier, error) {
+	_, err := db.Exec(hooksSchema)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating hooks schema")
+	}
+	return &hookNotifier{
+		db:     db,
+		client: &http.Client{Timeout: 10 * time.Second},
+		sem:    make(chan struct{}, maxHookDeliveries),
+	}, nil
+}
+
+type hook struct {
+	ID      int64  `json:"id"`
+	URL     string `json:"url"`
+	TxID    string `json:"tx_id,omitempty"`
+	AssetID string `json:"asset_id,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+	Codec   string `json:"codec,omitempty"` // a key of hookCodecs; empty means json
+}
+
+// hookEvent is the body of a notification,
+// encoded with the hook's codec.
+type hookEvent struct {
+	HookID  int64    `json:"hook_id"`
+	Height  uint64   `json:"height"`
+	BlockID string   `json:"block_id"`
+	TxIDs   []string `json:"tx_ids"`
+}
+
+// Add registers h and sets its ID.
+func (n *hookNotifier) Add(ctx context.Context, h *hook) error {
+	codec := h.Codec
+	if codec == "" {
+		codec = "json"
+	}
+	res, err := n.db.ExecContext(ctx, "INSERT INTO hooks (url, tx_id, asset_id, secret, codec) VALUES ($1, $2, $3, $4, $5)", h.URL, h.TxID, h.AssetID, h.Secret, codec)
+	if err != nil {
+		return errors.Wrap(err, "storing hook")
+	}
+	h.ID, err = res.LastInsertId()
+	return errors.Wrap(err, "getting hook ID")
+}
+
+// Remove unregisters the hook with the given ID.
+func (n *hookNotifier) Remove(ctx context.Context, id int64) error {
+	_, err := n.db.ExecContext(ctx, "DELETE FROM hooks WHERE id = $1", id)
+	return errors.Wrapf(err, "deleting hook %d", id)
+}
+
+func (n *hookNotifier) all(ctx context.Context) ([]*hook, error) {
+	rows, err := n.db.QueryContext(ctx, "SELECT id, url, tx_id, asset_id, secret, codec FROM hooks")
+	if err != nil {
+		return nil, errors.Wrap(err, "querying hooks")
+	}
+	defer rows.Close()
+
+	var result []*hook
+	for rows.Next() {
+		var h hook
+		err = rows.Scan(&h.ID, &h.URL, &h.TxID, &h.AssetID, &h.Secret, &h.Codec)
+		if err != nil {
+			return nil, errors.Wrap(err, "scanning hook")
+		}
+		result = append(result, &h)
+	}
+	return result, errors.Wrap(rows.Err(), "iterating over hooks")
+}
+
+// Run sends notifications for each block committed to s after Run is called.
+// It returns only on error or context cancellation.
+func (n *hookNotifier) Run(ctx context.Context, s *blockStore) error {
+	return follow(ctx, s, s.FinalHeight()+1, func(b *bc.Block) error {
+		return n.NotifyBlock(ctx, b)
+	})
+}
+
+// NotifyBlock starts delivery of notifications about b to each matching hook,
+// first waiting for a free delivery slot if maxHookDeliveries are already in progress.
+// A hook with neither a tx ID nor an asset ID matches every block.
+// A hook with a tx ID or asset ID matches blocks with a transaction having that ID or touching that asset.
+func (n *hookNotifier) NotifyBlock(ctx context.Context, b *bc.Block) error {
+	hs, err := n.all(ctx)
+	if err != nil {
+		return err
+	}
+	blockID := b.Hash().Bytes()
+	for _, h := range hs {
+		ev := &hookEvent{
+			HookID:  h.ID,
+			Height:  b.Height,
+			BlockID: hex.EncodeToString(blockID),
+			TxIDs:   []string{},
+		}
+		for _, tx := range b.Transactions {
+			if hookMatches(h, tx) {
+				ev.TxIDs = append(ev.TxIDs, hex.EncodeToString(tx.ID.Bytes()))
+			}
+		}
+		if (h.TxID != "" || h.AssetID != "") && len(ev.TxIDs) == 0 {
+			continue
+		}
+		select {
+		case n.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		go func(h *hook) {
+			defer func() { <-n.sem }()
+			n.deliver(h, ev)
+		}(h)
+	}
+	return nil
+}
+
+func hookMatches(h *hook, tx *bc.Tx) bool {
+	if h.TxID != "" && h.TxID != hex.EncodeToString(tx.ID.Bytes()) {
+		return false
+	}
+	if h.AssetID != "" {
+		assetID, _ := hex.DecodeString(h.AssetID)
+		if !txAssets(tx)[bc.HashFromBytes(assetID)] {
+			return false
+		}
+	}
+	return true
+}
+
+// txAssets returns the set of asset IDs issued, retired, spent, or output in tx,
+// as far as they can be determined from its log.
+func txAssets(tx *bc.Tx) map[bc.Hash]bool {
+	result := make(map[bc.Hash]bool)
+	res := txresult.New(tx)
+	for _, iss := range res.Issuances {
+		result[iss.Value.AssetID] = true
+	}
+	for _, ret := range res.Retirements {
+		result[ret.Value.AssetID] = true
+	}
+	for _, inp := range res.Inputs {
+		if inp.Value != nil {
+			result[inp.Value.AssetID] = true
+		}
+	}
+	for _, out := range res.Outputs {
+		if out.Value != nil {
+			result[out.Value.AssetID] = true
+		}
+	}
+	return result
+}
+
+// deliver sends ev to h,
+// retrying with exponential backoff up to hookAttempts times.
+func (n *hookNotifier) deliver(h *hook, ev *hookEvent) {
+	codec, ok := hookCodecs[h.Codec]
+	if !ok {
+		codec = hookCodecs["json"]
+	}
+	body, err := codec.encode(context.Background(), n, ev)
+	if err != nil {
+		log.Printf("encoding notification for hook %d: %s", h.ID, err)
+		return
+	}
+	delay := hookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = n.post(h, codec.contentType, body)
+		if err == nil {
+			return
+		}
+		if attempt >= hookAttempts {
+			log.Printf("giving up on notifying hook %d of block %d after %d attempts: %s", h.ID, ev.Height, attempt, err)
+			return
+		}
+		log.Printf("notifying hook %d of block %d (attempt %d): %s", h.ID, ev.Height, attempt, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (n *hookNotifier) post(h *hook, contentType string, body []byte) error {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if h.Secret != "" {
+		req.Header.Set(hookSignatureHeader, hookSignature(h.Secret, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func hookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *node) hooksHandler(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if !n.authorize(w, req, "hooks") {
+		return
+	}
+
+	switch req.Method {
+	case "POST":
+		var h hook
+		err := json.NewDecoder(req.Body).Decode(&h)
+		if err != nil {
+			httpErrf(w, http.StatusBadRequest, "parsing request body: %s", err)
+			return
+		}
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			httpErrf(w, http.StatusBadRequest, "hook URL must be http or https")
+			return
+		}
+		for _, id := range []*string{&h.TxID, &h.AssetID} {
+			if *id == "" {
+				continue
+			}
+			b, err := hex.DecodeString(*id)
+			if err != nil || len(b) != 32 {
+				httpErrf(w, http.StatusBadRequest, "tx_id and asset_id must be 32 hex-encoded bytes")
+				return
+			}
+			*id = hex.EncodeToString(b)
+		}
+		if _, ok := hookCodecs[h.Codec]; !ok && h.Codec != "" {
+			httpErrf(w, http.StatusBadRequest, "unknown codec %q", h.Codec)
+			return
+		}
+		if h.Codec == "avro" && n.hooks.registry == nil {
+			httpErrf(w, http.StatusBadRequest, "the avro codec requires the server to have a -schema-registry")
+			return
+		}
+		err = n.hooks.Add(ctx, &h)
+		if err != nil {
+			httpErrf(w, http.StatusInternalServerError, "registering hook: %s", err)
+			return
+		}
+		n.log.Printf("registered hook %d for %s", h.ID, h.URL)
+		respondJSON(w, struct {
+			ID int64 `json:"id"`
+		}{h.ID})
+
+	case "DELETE":
+		id, err := strconv.ParseInt(req.FormValue("id"), 10, 64)
+		if err != nil {
+			httpErrf(w, http.StatusBadRequest, "parsing id: %s", err)
+			return
+		}
+		err = n.hooks.Remove(ctx, id)
+		if err != nil {
+			httpErrf(w, http.StatusInternalServerError, "removing hook: %s", err)
+			return
+		}
+		n.log.Printf("removed hook %d", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		httpErrf(w, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+	}
+}
+
+const hooksSchema = `
+CREATE TABLE IF NOT EXISTS hooks (
+  id INTEGER NOT NULL PRIMARY KEY,
+  url TEXT NOT NULL,
+  tx_id TEXT NOT NULL,
+  asset_id TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  codec TEXT NOT NULL DEFAULT 'json'
+);
+`
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/protocol"
+	"github.com/chain/txvm/protocol/txbuilder/standard"
+)
+
+func TestHooks(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newHookNotifier(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type delivery struct {
+		body []byte
+		sig  string
+	}
+	ch := make(chan delivery, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ch <- delivery{body: body, sig: req.Header.Get(hookSignatureHeader)}
+	}))
+	defer server.Close()
+
+	b1, err := protocol.NewInitialBlock(nil, 0, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := testIssuance(ctx, t, b1, 10)
+	b2 := testBlock(t, b1, tx)
+
+	_, pub := testKeys(t)
+	assetID := standard.AssetID(2, 1, []ed25519.PublicKey{pub}, nil)
+
+	h := &hook{
+		URL:     server.URL,
+		AssetID: hex.EncodeToString(assetID[:]),
+		Secret:  "shh",
+	}
+	err = n.Add(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Add(ctx, &hook{URL: server.URL, TxID: hex.EncodeToString(make([]byte, 32))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.NotifyBlock(ctx, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d delivery
+	select {
+	case d = <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for notification")
+	}
+
+	if d.sig != hookSignature("shh", d.body) {
+		t.Errorf("got signature %s, want %s", d.sig, hookSignature("shh", d.body))
+	}
+
+	var ev hookEvent
+	err = json.Unmarshal(d.body, &ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.HookID != h.ID {
+		t.Errorf("got hook ID %d, want %d", ev.HookID, h.ID)
+	}
+	if ev.Height != 2 {
+		t.Errorf("got height %d, want 2", ev.Height)
+	}
+	if len(ev.TxIDs) != 1 || ev.TxIDs[0] != hex.EncodeToString(tx.ID.Bytes()) {
+		t.Errorf("got tx IDs %v, want [%x]", ev.TxIDs, tx.ID.Bytes())
+	}
+
+	select {
+	case d = <-ch:
+		t.Errorf("unexpected notification %s", string(d.body))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestHooksOpen(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.hooks, err = newHookNotifier(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	register := func() int {
+		rec := httptest.NewRecorder()
+		n.hooksHandler(rec, httptest.NewRequest("POST", "/hooks", strings.NewReader(`{"url": "http://example.com/hook"}`)))
+		return rec.Code
+	}
+
+	// Without admin tokens, registration requires -hooks-open.
+	if got := register(); got != http.StatusForbidden {
+		t.Errorf("got status %d registering a hook without -hooks-open, want %d", got, http.StatusForbidden)
+	}
+	n.hooksOpen = true
+	if got := register(); got != http.StatusOK {
+		t.Errorf("got status %d registering a hook with -hooks-open, want %d", got, http.StatusOK)
+	}
+}
+
+func TestHookDeliveryLimit(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newHookNotifier(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.sem = make(chan struct{}, 1)
+
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		arrived <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	b1, err := protocol.NewInitialBlock(nil, 0, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2 := testBlock(t, b1, testIssuance(ctx, t, b1, 10))
+
+	for i := 0; i < 2; i++ {
+		err = n.Add(ctx, &hook{URL: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second delivery waits for the first,
+	// which the server holds until release is closed.
+	notifyCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	err = n.NotifyBlock(notifyCtx, b2)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v notifying with no free delivery slot, want %v", err, context.DeadlineExceeded)
+	}
+	<-arrived
+	select {
+	case <-arrived:
+		t.Error("got two concurrent deliveries, want one")
+	default:
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txbuilder/txresult"
+)
+
+// indexer maintains tables of outputs by asset ID, by recipient pubkey,
+// and by contract seed, populated from the tx logs of committed blocks.
+type indexer struct {
+	db      *sql.DB
+	mirror  *redisMirror      // nil if there is no Redis mirror
+	checker *invariantChecker // nil if invariants are not checked
+}
+
+func newIndexer(db *sql.DB) (*indexer, error) {
+	_, err := db.Exec(indexSchema)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating index schema")
+	}
+	return &indexer{db: db}, nil
+}
+
+// Height returns the height of the highest indexed block.
+func (ix *indexer) Height(ctx context.Context) (uint64, error) {
+	var height uint64
+	err := ix.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(height), 0) FROM indexed_blocks").Scan(&height)
+	return height, errors.Wrap(err, "getting index height")
+}
+
+// Run indexes each block of s as it is committed,
+// starting after the highest block already indexed.
+// It returns only on error or context cancellation.
+func (ix *indexer) Run(ctx context.Context, s *blockStore) error {
+	height, err := ix.Height(ctx)
+	if err != nil {
+		return err
+	}
+	if ix.mirror != nil {
+		err = ix.mirror.Sync(ctx, ix)
+		if err != nil {
+			log.Printf("syncing redis mirror: %s", err)
+		}
+	}
+	if ix.checker != nil {
+		// Catch the checker up with the index.
+		checked, err := ix.checker.Height(ctx)
+		if err != nil {
+			return err
+		}
+		for h := checked + 1; h <= height; h++ {
+			b, err := s.GetBlock(ctx, h)
+			if err != nil {
+				return errors.Wrapf(err, "getting block %d", h)
+			}
+			err = ix.checker.CheckBlock(ctx, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return follow(ctx, s, height+1, func(b *bc.Block) error {
+		err := ix.IndexBlock(ctx, b)
+		if err != nil || ix.checker == nil {
+			return err
+		}
+		return ix.checker.CheckBlock(ctx, b)
+	})
+}
+
+// IndexBlock adds the outputs created in b to the index
+// and marks the outputs consumed in b as spent.
+func (ix *indexer) IndexBlock(ctx context.Context, b *bc.Block) error {
+	dbtx, err := ix.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrapf(err, "beginning db transaction for indexing block %d", b.Height)
+	}
+	defer dbtx.Rollback()
+
+	for _, tx := range b.Transactions {
+		res := txresult.New(tx)
+		for i, out := range res.Outputs {
+			var (
+				assetID []byte
+				amount  sql.NullInt64
+			)
+			if out.Value != nil {
+				assetID = out.Value.AssetID.Bytes()
+				amount = sql.NullInt64{Int64: int64(out.Value.Amount), Valid: true}
+			}
+			_, err = dbtx.ExecContext(ctx, "INSERT OR IGNORE INTO outputs (output_id, height, tx_id, log_pos, seed, asset_id, amount) VALUES ($1, $2, $3, $4, $5, $6, $7)", out.OutputID.Bytes(), b.Height, tx.ID.Bytes(), out.LogPos, tx.Outputs[i].Seed.Bytes(), assetID, amount)
+			if err != nil {
+				return errors.Wrapf(err, "indexing output %x", out.OutputID.Bytes())
+			}
+			for _, pubkey := range out.Pubkeys {
+				_, err = dbtx.ExecContext(ctx, "INSERT OR IGNORE INTO output_pubkeys (output_id, pubkey) VALUES ($1, $2)", out.OutputID.Bytes(), []byte(pubkey))
+				if err != nil {
+					return errors.Wrapf(err, "indexing pubkey of output %x", out.OutputID.Bytes())
+				}
+			}
+		}
+		for _, inp := range tx.Inputs {
+			_, err = dbtx.ExecContext(ctx, "UPDATE outputs SET spent_height = $1 WHERE output_id = $2", b.Height, inp.ID.Bytes())
+			if err != nil {
+				return errors.Wrapf(err, "marking output %x spent", inp.ID.Bytes())
+			}
+		}
+	}
+
+	_, err = dbtx.ExecContext(ctx, "INSERT INTO indexed_blocks (height) VALUES ($1)", b.Height)
+	if err != nil {
+		return errors.Wrapf(err, "recording index height %d", b.Height)
+	}
+	err = dbtx.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "committing index of block %d", b.Height)
+	}
+
+	if ix.mirror != nil {
+		err = ix.mirror.MirrorBlock(ctx, ix, b.Height)
+		if err != nil {
+			log.Printf("mirroring index of block %d to redis: %s", b.Height, err)
+		}
+	}
+	return nil
+}
+
+type indexedOutput struct {
+	OutputID string   `json:"output_id"`
+	Height   uint64   `json:"height"`
+	TxID     string   `json:"tx_id"`
+	LogPos   int      `json:"log_pos"`
+	Seed     string   `json:"seed"`
+	AssetID  string   `json:"asset_id,omitempty"`
+	Amount   *int64   `json:"amount,omitempty"`
+	Pubkeys  []string `json:"pubkeys"`
+}
+
+// outputFilter selects indexed outputs.
+// Each nil field matches all outputs.
+type outputFilter struct {
+	AssetID, Pubkey, Seed []byte
+}
+
+// Outputs returns the unspent outputs matching f.
+// It consults the Redis mirror if there is one,
+// falling back to the authoritative sqlite index on error.
+func (ix *indexer) Outputs(ctx context.Context, f outputFilter) ([]*indexedOutput, error) {
+	if ix.mirror != nil {
+		outs, err := ix.mirror.Outputs(ctx, f)
+		if err == nil {
+			return outs, nil
+		}
+		log.Printf("querying redis mirror, falling back to sqlite: %s", err)
+	}
+	return ix.queryOutputs(ctx, "o.spent_height IS NULL AND ($1 IS NULL OR o.asset_id = $1) AND ($2 IS NULL OR o.output_id IN (SELECT output_id FROM output_pubkeys WHERE pubkey = $2)) AND ($3 IS NULL OR o.seed = $3)", f.AssetID, f.Pubkey, f.Seed)
+}
+
+// queryOutputs returns the indexed outputs satisfying the SQL condition cond,
+// which may refer to the outputs table as o.
+func (ix *indexer) queryOutputs(ctx context.Context, cond string, args ...interface{}) ([]*indexedOutput, error) {
+	q := "SELECT o.output_id, o.height, o.tx_id, o.log_pos, o.seed, o.asset_id, o.amount FROM outputs o WHERE " + cond + " ORDER BY o.height, o.tx_id, o.log_pos"
+	rows, err := ix.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying outputs")
+	}
+	defer rows.Close()
+
+	var result []*indexedOutput
+	for rows.Next() {
+		var (
+			outputID, txID, seed, outAssetID []byte
+			amount                           sql.NullInt64
+			out                              indexedOutput
+		)
+		err = rows.Scan(&outputID, &out.Height, &txID, &out.LogPos, &seed, &outAssetID, &amount)
+		if err != nil {
+			return nil, errors.Wrap(err, "scanning output")
+		}
+		out.OutputID = hex.EncodeToString(outputID)
+		out.TxID = hex.EncodeToString(txID)
+		out.Seed = hex.EncodeToString(seed)
+		if outAssetID != nil {
+			out.AssetID = hex.EncodeToString(outAssetID)
+		}
+		if amount.Valid {
+			out.Amount = &amount.Int64
+		}
+		result = append(result, &out)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating over outputs")
+	}
+
+	for _, out := range result {
+		outputID, _ := hex.DecodeString(out.OutputID)
+		out.Pubkeys, err = ix.outputPubkeys(ctx, outputID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (ix *indexer) outputPubkeys(ctx context.Context, outputID []byte) ([]string, error) {
+	rows, err := ix.db.QueryContext(ctx, "SELECT pubkey FROM output_pubkeys WHERE output_id = $1", outputID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "querying pubkeys of output %x", outputID)
+	}
+	defer rows.Close()
+
+	pubkeys := []string{}
+	for rows.Next() {
+		var pubkey []byte
+		err = rows.Scan(&pubkey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "scanning pubkey of output %x", outputID)
+		}
+		pubkeys = append(pubkeys, hex.EncodeToString(pubkey))
+	}
+	return pubkeys, errors.Wrapf(rows.Err(), "iterating over pubkeys of output %x", outputID)
+}
+
+func (n *node) outputs(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	assetID, err := hexParam(req, "assetid")
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing assetid: %s", err)
+		return
+	}
+	pubkey, err := hexParam(req, "pubkey")
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing pubkey: %s", err)
+		return
+	}
+	if assetID == nil && pubkey == nil {
+		httpErrf(w, http.StatusBadRequest, "must supply assetid, pubkey, or both")
+		return
+	}
+
+	outs, err := n.idx.Outputs(ctx, outputFilter{AssetID: assetID, Pubkey: pubkey})
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "getting outputs: %s", err)
+		return
+	}
+	if outs == nil {
+		outs = []*indexedOutput{}
+	}
+
+	respondJSON(w, outs)
+}
+
+func (n *node) contracts(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	seed, err := hexParam(req, "seed")
+	if err != nil {
+		httpErrf(w, http.StatusBadRequest, "parsing seed: %s", err)
+		return
+	}
+	if seed == nil {
+		httpErrf(w, http.StatusBadRequest, "must supply seed")
+		return
+	}
+
+	outs, err := n.idx.Outputs(ctx, outputFilter{Seed: seed})
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "getting contracts: %s", err)
+		return
+	}
+	if outs == nil {
+		outs = []*indexedOutput{}
+	}
+
+	respondJSON(w, outs)
+}
+
+// hexParam parses the hex-encoded request parameter with the given name.
+// It returns nil if the parameter is absent.
+func hexParam(req *http.Request, name string) ([]byte, error) {
+	s := req.FormValue(name)
+	if s == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(s)
+}
+
+func respondJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "sending response: %s", err)
+	}
+}
+
+const indexSchema = `
+CREATE TABLE IF NOT EXISTS indexed_blocks (
+  height INTEGER NOT NULL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS outputs (
+  output_id BLOB NOT NULL PRIMARY KEY,
+  height INTEGER NOT NULL,
+  tx_id BLOB NOT NULL,
+  log_pos INTEGER NOT NULL,
+  seed BLOB NOT NULL,
+  asset_id BLOB,
+  amount INTEGER,
+  spent_height INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS outputs_asset_id ON outputs (asset_id);
+
+CREATE INDEX IF NOT EXISTS outputs_seed ON outputs (seed);
+
+CREATE TABLE IF NOT EXISTS output_pubkeys (
+  output_id BLOB NOT NULL,
+  pubkey BLOB NOT NULL,
+  PRIMARY KEY (output_id, pubkey)
+);
+
+CREATE INDEX IF NOT EXISTS output_pubkeys_pubkey ON output_pubkeys (pubkey);
+`
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/protocol"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/state"
+	"github.com/chain/txvm/protocol/txbuilder/standard"
+)
+
+func TestIndexer(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	bs, err := newBlockStore(db, make(chan uint64, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, err := bs.GetBlock(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := newIndexer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := testIssuance(ctx, t, b1, 10)
+	b2 := testBlock(t, b1, tx)
+	err = ix.IndexBlock(ctx, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	height, err := ix.Height(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 2 {
+		t.Errorf("got index height %d, want 2", height)
+	}
+
+	_, pub := testKeys(t)
+	assetID := standard.AssetID(2, 1, []ed25519.PublicKey{pub}, nil)
+
+	cases := []struct {
+		name string
+		f    outputFilter
+		want int
+	}{
+		{"by asset", outputFilter{AssetID: assetID[:]}, 1},
+		{"by pubkey", outputFilter{Pubkey: pub}, 1},
+		{"by asset and pubkey", outputFilter{AssetID: assetID[:], Pubkey: pub}, 1},
+		{"by seed", outputFilter{Seed: standard.PayToMultisigSeed2[:]}, 1},
+		{"other asset", outputFilter{AssetID: make([]byte, 32)}, 0},
+		{"other pubkey", outputFilter{Pubkey: make([]byte, 32)}, 0},
+		{"other seed", outputFilter{Seed: make([]byte, 32)}, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			outs, err := ix.Outputs(ctx, c.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(outs) != c.want {
+				t.Fatalf("got %d outputs, want %d", len(outs), c.want)
+			}
+			if c.want == 0 {
+				return
+			}
+			out := outs[0]
+			if out.OutputID != hex.EncodeToString(tx.Outputs[0].ID.Bytes()) {
+				t.Errorf("got output ID %s, want %x", out.OutputID, tx.Outputs[0].ID.Bytes())
+			}
+			if out.Seed != hex.EncodeToString(standard.PayToMultisigSeed2[:]) {
+				t.Errorf("got seed %s, want %x", out.Seed, standard.PayToMultisigSeed2[:])
+			}
+			if out.AssetID != hex.EncodeToString(assetID[:]) {
+				t.Errorf("got asset ID %s, want %x", out.AssetID, assetID[:])
+			}
+			if out.Amount == nil || *out.Amount != 10 {
+				t.Errorf("got amount %v, want 10", out.Amount)
+			}
+			if len(out.Pubkeys) != 1 || out.Pubkeys[0] != hex.EncodeToString(pub) {
+				t.Errorf("got pubkeys %v, want [%x]", out.Pubkeys, []byte(pub))
+			}
+		})
+	}
+}
+
+// testDB opens a new sqlite database in a temporary file.
+// Callers must call the returned function to close and remove it.
+func testDB(t *testing.T) (*sql.DB, func()) {
+	f, err := ioutil.TempFile("", "txvmbcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile := f.Name()
+	f.Close()
+
+	db, err := sql.Open("sqlite3", tmpfile)
+	if err != nil {
+		os.Remove(tmpfile)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.Remove(tmpfile)
+	}
+}
+
+// testBlock builds the block following prev containing txs.
+func testBlock(t *testing.T, prev *bc.Block, txs ...*bc.Tx) *bc.Block {
+	st := state.Empty()
+	err := st.ApplyBlock(prev.UnsignedBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := bc.Millis(time.Now())
+	if timestamp <= prev.TimestampMs {
+		timestamp = prev.TimestampMs + 1
+	}
+	bb := protocol.NewBlockBuilder()
+	err = bb.Start(st, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range txs {
+		err = bb.AddTx(bc.NewCommitmentsTx(tx))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ub, _, err := bb.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &bc.Block{UnsignedBlock: ub}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chain/txvm/errors"
+)
+
+// indexerCmd implements "txvmbcd indexer -primary URL -db DBFILE [-addr LISTENADDR]".
+// Like a standby,
+// it replays the primary's blocks into DBFILE, verifying each one.
+// It also maintains the output, event, and bloom filter indexes in DBFILE
+// and serves them (and the chain's blocks) read-only,
+// so that query load is kept off the primary.
+func indexerCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("indexer", flag.ExitOnError)
+	primary := fs.String("primary", "", "base URL of the primary (e.g. http://host:2423 or http://host:2423/chains/ID)")
+	dbfile := fs.String("db", "", "path to the indexer's block storage and index db")
+	addr := fs.String("addr", "localhost:2424", "listen address for the query endpoints")
+	wait := fs.Duration("wait", 30*time.Second, "how long each request to the primary waits for a new block")
+	invariants := fs.String("invariants", "alert", "check asset supply after each block and on violation: off, alert (log), or halt (exit)")
+	fs.Parse(args)
+
+	if *primary == "" || *dbfile == "" {
+		return errors.New("indexer requires -primary and -db")
+	}
+	switch *invariants {
+	case "off", "alert", "halt":
+	default:
+		return fmt.Errorf("unknown -invariants mode %q", *invariants)
+	}
+
+	db, err := sql.Open("sqlite3", *dbfile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	s := &standby{
+		primary: strings.TrimSuffix(*primary, "/"),
+		wait:    *wait,
+		client:  &http.Client{Timeout: *wait + 30*time.Second},
+	}
+	n, err := s.open(ctx, db)
+	if err != nil {
+		return err
+	}
+	n.readonly = true
+	err = n.startIndexes(ctx, *invariants)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+	server := &http.Server{
+		Addr:        *addr,
+		Handler:     gzipHandler(gzip.DefaultCompression, mux),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("serving indexes of %s on %s", s.primary, *addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- s.run(ctx, n)
+	}()
+
+	select {
+	case err = <-serveErr:
+		cancel()
+		<-runErr
+	case err = <-runErr:
+		server.Close()
+	}
+	return err
+}
+
+// startIndexes starts all of n's indexes:
+// outputs (with the given invariant checking), events, and bloom filters.
+func (n *node) startIndexes(ctx context.Context, invariants string) error {
+	err := n.startIndexer(ctx, nil, invariants)
+	if err != nil {
+		return errors.Wrap(err, "starting output index")
+	}
+	err = n.startEvents(ctx)
+	if err != nil {
+		return errors.Wrap(err, "starting event index")
+	}
+	return errors.Wrap(n.startBlooms(ctx), "starting bloom filters")
+}
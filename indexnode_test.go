@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestIndexerNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primaryDB, cleanup := testDB(t)
+	defer cleanup()
+	primary, err := newNode(ctx, "", primaryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primaryMux := http.NewServeMux()
+	primary.handle(primaryMux, "")
+	server := httptest.NewServer(primaryMux)
+	defer server.Close()
+
+	for _, amount := range []int64{10, 20} {
+		primary.do(func() {
+			_, err = primary.commitNow(ctx, testIssuance(ctx, t, primary.initialBlock, amount))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	indexerDB, cleanup2 := testDB(t)
+	defer cleanup2()
+	s := &standby{primary: server.URL, wait: time.Second, client: new(http.Client)}
+	n, err := s.open(ctx, indexerDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.readonly = true
+	err = n.startIndexes(ctx, "alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.run(ctx, n)
+	}()
+
+	for i := 0; ; i++ {
+		h, err := n.idx.Height(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h >= 3 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("indexer reached only height %d, want 3", h)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+
+	var events []*event
+	for i := 0; len(events) == 0; i++ {
+		if i == 100 {
+			t.Fatal("no events indexed")
+		}
+		time.Sleep(10 * time.Millisecond)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d from /events (%s)", rec.Code, rec.Body)
+		}
+		err = json.NewDecoder(rec.Body).Decode(&events)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	txbits, err := proto.Marshal(&testIssuance(ctx, t, n.initialBlock, 30).RawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/submit", bytes.NewReader(txbits)))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("got status %d from /submit on the indexer, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got error %v from run, want %s", err, context.Canceled)
+	}
+}
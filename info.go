@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// version identifies this build of txvmbcd.
+// It may be set with -ldflags "-X main.version=...";
+// otherwise it comes from the module build info.
+var version string
+
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// chainInfo is the JSON response to an /info request.
+type chainInfo struct {
+	InitialBlockID  string `json:"initial_block_id"`
+	Height          uint64 `json:"height"`
+	LatestBlockID   string `json:"latest_block_id"`
+	LatestBlockMS   uint64 `json:"latest_block_timestamp_ms"`
+	Version         string `json:"version"`
+	BlockIntervalMS uint64 `json:"block_interval_ms"`
+	PoolSize        int    `json:"pool_size"`
+	ReadOnly        bool   `json:"read_only"`
+	UptimeSecs      int64  `json:"uptime_secs"`
+}
+
+func (n *node) info(w http.ResponseWriter, req *http.Request) {
+	respondJSON(w, n.chainInfo())
+}
+
+// chainInfo returns the current chainInfo of n.
+func (n *node) chainInfo() *chainInfo {
+	header := n.initialBlock.BlockHeader
+	if h := n.chain.State().Header; h != nil {
+		header = h
+	}
+
+	res := chainInfo{
+		InitialBlockID:  hex.EncodeToString(n.initialBlock.Hash().Bytes()),
+		Height:          header.Height,
+		LatestBlockID:   hex.EncodeToString(header.Hash().Bytes()),
+		LatestBlockMS:   header.TimestampMs,
+		Version:         buildVersion(),
+		BlockIntervalMS: bc.DurationMillis(blockInterval()),
+		PoolSize:        int(atomic.LoadInt64(&n.pending)),
+		ReadOnly:        n.readonly,
+		UptimeSecs:      int64(time.Since(n.started) / time.Second),
+	}
+	if n.dev {
+		res.BlockIntervalMS = 0
+	}
+	return &res
+}
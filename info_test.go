@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.do(func() {
+		_, err = n.commitNow(ctx, testIssuance(ctx, t, n.initialBlock, 10))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := n.chain.GetBlock(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	n.info(rec, httptest.NewRequest("GET", "/info", nil))
+
+	var got chainInfo
+	err = json.NewDecoder(rec.Body).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hex.EncodeToString(n.initialBlock.Hash().Bytes()); got.InitialBlockID != want {
+		t.Errorf("got initial block ID %s, want %s", got.InitialBlockID, want)
+	}
+	if got.Height != 2 {
+		t.Errorf("got height %d, want 2", got.Height)
+	}
+	if want := hex.EncodeToString(b2.Hash().Bytes()); got.LatestBlockID != want {
+		t.Errorf("got latest block ID %s, want %s", got.LatestBlockID, want)
+	}
+	if got.LatestBlockMS != b2.TimestampMs {
+		t.Errorf("got latest block timestamp %d, want %d", got.LatestBlockMS, b2.TimestampMs)
+	}
+	if got.PoolSize != 0 {
+		t.Errorf("got pool size %d, want 0", got.PoolSize)
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.readonly = true
+
+	rec := httptest.NewRecorder()
+	n.enqueue(rec, httptest.NewRequest("POST", "/submit", nil), testIssuance(ctx, t, n.initialBlock, 10))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("got status %d submitting to a read-only node, want %d", rec.Code, http.StatusForbidden)
+	}
+	if pending := atomic.LoadInt64(&n.pending); pending != 0 {
+		t.Errorf("got %d pending txs, want 0", pending)
+	}
+
+	rec = httptest.NewRecorder()
+	n.info(rec, httptest.NewRequest("GET", "/info", nil))
+	var got chainInfo
+	err = json.NewDecoder(rec.Body).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.ReadOnly {
+		t.Error("got read_only false, want true")
+	}
+}
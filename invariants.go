@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// invariantChecker verifies, after each block is indexed,
+// that the amount of each asset held in indexed unspent outputs
+// agrees with the amount issued minus the amount retired,
+// as tallied from the tx logs in its asset_supply table.
+//
+// Outputs of nonstandard contracts cannot be attributed to an asset.
+// While any are unspent,
+// the checker can verify only that the indexed amount does not exceed the supply.
+type invariantChecker struct {
+	db   *sql.DB
+	halt bool // return an error on violation, rather than only logging it
+}
+
+func newInvariantChecker(db *sql.DB, halt bool) (*invariantChecker, error) {
+	_, err := db.Exec(invariantsSchema)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating invariant checker schema")
+	}
+	return &invariantChecker{db: db, halt: halt}, nil
+}
+
+// Height returns the height of the highest block tallied.
+func (c *invariantChecker) Height(ctx context.Context) (uint64, error) {
+	var height uint64
+	err := c.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(height), 0) FROM supply_blocks").Scan(&height)
+	return height, errors.Wrap(err, "getting invariant checker height")
+}
+
+// CheckBlock tallies the issuances and retirements in b
+// and checks the supply of each asset that b touches
+// against the outputs indexed as of b's height.
+// The index must already include b.
+// Violations are logged,
+// and also returned as an error if c.halt is true.
+// In that case the tally of b is not committed,
+// so the check is repeated (and fails again) after a restart.
+func (c *invariantChecker) CheckBlock(ctx context.Context, b *bc.Block) error {
+	dbtx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrapf(err, "beginning db transaction for checking block %d", b.Height)
+	}
+	defer dbtx.Rollback()
+
+	err = c.tally(ctx, dbtx, b)
+	if err != nil {
+		return err
+	}
+
+	var unattributed int
+	err = dbtx.QueryRowContext(ctx, "SELECT COUNT(*) FROM outputs WHERE asset_id IS NULL AND height <= $1 AND (spent_height IS NULL OR spent_height > $1)", b.Height).Scan(&unattributed)
+	if err != nil {
+		return errors.Wrapf(err, "counting unattributed outputs at height %d", b.Height)
+	}
+
+	assets := make(map[bc.Hash]bool)
+	for _, tx := range b.Transactions {
+		for assetID := range txAssets(tx) {
+			assets[assetID] = true
+		}
+	}
+
+	var violations []string
+	for assetID := range assets {
+		var supply, held int64
+		err = dbtx.QueryRowContext(ctx, "SELECT COALESCE(SUM(issued - retired), 0) FROM asset_supply WHERE asset_id = $1", assetID.Bytes()).Scan(&supply)
+		if err != nil {
+			return errors.Wrapf(err, "getting supply of asset %x", assetID.Bytes())
+		}
+		err = dbtx.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM outputs WHERE asset_id = $1 AND height <= $2 AND (spent_height IS NULL OR spent_height > $2)", assetID.Bytes(), b.Height).Scan(&held)
+		if err != nil {
+			return errors.Wrapf(err, "summing outputs of asset %x", assetID.Bytes())
+		}
+		switch {
+		case supply < 0:
+			violations = append(violations, fmt.Sprintf("asset %x: retired %d more than issued", assetID.Bytes(), -supply))
+		case held > supply:
+			violations = append(violations, fmt.Sprintf("asset %x: %d in outputs exceeds supply of %d", assetID.Bytes(), held, supply))
+		case held < supply && unattributed == 0:
+			violations = append(violations, fmt.Sprintf("asset %x: %d in outputs is less than supply of %d", assetID.Bytes(), held, supply))
+		}
+	}
+	if len(violations) > 0 {
+		msg := fmt.Sprintf("invariant violated at height %d: %s", b.Height, strings.Join(violations, "; "))
+		log.Print(msg)
+		if c.halt {
+			return errors.New(msg)
+		}
+	}
+
+	err = dbtx.Commit()
+	return errors.Wrapf(err, "committing tally of block %d", b.Height)
+}
+
+// tally adds the issuances and retirements in b to the asset_supply table in dbtx,
+// unless it has already done so.
+func (c *invariantChecker) tally(ctx context.Context, dbtx *sql.Tx, b *bc.Block) error {
+	res, err := dbtx.ExecContext(ctx, "INSERT OR IGNORE INTO supply_blocks (height) VALUES ($1)", b.Height)
+	if err != nil {
+		return errors.Wrapf(err, "recording tally of block %d", b.Height)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		// Already tallied.
+		return errors.Wrapf(err, "recording tally of block %d", b.Height)
+	}
+
+	add := func(assetID bc.Hash, col string, amount int64) error {
+		_, err := dbtx.ExecContext(ctx, "INSERT OR IGNORE INTO asset_supply (asset_id, issued, retired) VALUES ($1, 0, 0)", assetID.Bytes())
+		if err != nil {
+			return errors.Wrapf(err, "tallying asset %x", assetID.Bytes())
+		}
+		_, err = dbtx.ExecContext(ctx, "UPDATE asset_supply SET "+col+" = "+col+" + $1 WHERE asset_id = $2", amount, assetID.Bytes())
+		return errors.Wrapf(err, "tallying asset %x", assetID.Bytes())
+	}
+	for _, tx := range b.Transactions {
+		for _, iss := range tx.Issuances {
+			err = add(iss.AssetID, "issued", iss.Amount)
+			if err != nil {
+				return err
+			}
+		}
+		for _, ret := range tx.Retirements {
+			err = add(ret.AssetID, "retired", ret.Amount)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+const invariantsSchema = `
+CREATE TABLE IF NOT EXISTS supply_blocks (
+  height INTEGER NOT NULL PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS asset_supply (
+  asset_id BLOB NOT NULL PRIMARY KEY,
+  issued INTEGER NOT NULL,
+  retired INTEGER NOT NULL
+);
+`
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInvariantChecker(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	bs, err := newBlockStore(db, make(chan uint64, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, err := bs.GetBlock(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, err := newIndexer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newInvariantChecker(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b2 := testBlock(t, b1, testIssuance(ctx, t, b1, 10))
+	err = ix.IndexBlock(ctx, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.CheckBlock(ctx, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	height, err := c.Height(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 2 {
+		t.Errorf("got checker height %d, want 2", height)
+	}
+
+	// Checking the same block again must not count its issuance twice.
+	err = c.CheckBlock(ctx, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("UPDATE outputs SET amount = 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.CheckBlock(ctx, b2)
+	if err == nil {
+		t.Error("got no error after corrupting the index, want one")
+	}
+
+	c.halt = false
+	err = c.CheckBlock(ctx, b2)
+	if err != nil {
+		t.Errorf("got error %s in alert mode, want none", err)
+	}
+}
+
+func TestInvariantViolationSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	bs, err := newBlockStore(db, make(chan uint64, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, err := bs.GetBlock(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, err := newIndexer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newInvariantChecker(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b2 := testBlock(t, b1, testIssuance(ctx, t, b1, 10))
+	err = bs.SaveBlock(ctx, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ix.IndexBlock(ctx, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("UPDATE outputs SET amount = 11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.CheckBlock(ctx, b2)
+	if err == nil {
+		t.Fatal("got no error after corrupting the index, want one")
+	}
+	height, err := c.Height(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height >= 2 {
+		t.Errorf("got checker height %d after a violation at height 2, want less", height)
+	}
+
+	// After a restart,
+	// the indexer catches the checker up and finds the violation again.
+	c, err = newInvariantChecker(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.checker = c
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err = ix.Run(ctx, bs)
+	if err == nil || ctx.Err() != nil {
+		t.Errorf("got error %v from the restarted indexer, want an invariant violation", err)
+	}
+}
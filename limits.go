@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/chain/txvm/errors"
+)
+
+// errBodyTooLarge is the error from reading past the limit set by limitBody.
+var errBodyTooLarge = errors.New("request body too large")
+
+// limitBody caps the number of bytes that may be read from req.Body at max
+// (if max is positive),
+// so that an oversized request fails with errBodyTooLarge
+// instead of being read into memory.
+// It reports false, after responding with status 413,
+// if the request's Content-Length already exceeds max.
+func limitBody(w http.ResponseWriter, req *http.Request, max int64) bool {
+	if max <= 0 {
+		return true
+	}
+	if req.ContentLength > max {
+		httpErrf(w, http.StatusRequestEntityTooLarge, "request body is larger than %d bytes", max)
+		return false
+	}
+	req.Body = &limitedBody{ReadCloser: req.Body, remaining: max}
+	return true
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// A body of exactly the limit is fine; check for more.
+		var buf [1]byte
+		n, err := b.ReadCloser.Read(buf[:])
+		if n > 0 {
+			return 0, errBodyTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// readErrf responds to a request whose body could not be read or parsed,
+// with status 413 if it was too large and 400 otherwise.
+func readErrf(w http.ResponseWriter, err error, max int64) {
+	if errors.Root(err) == errBodyTooLarge {
+		httpErrf(w, http.StatusRequestEntityTooLarge, "request body is larger than %d bytes", max)
+		return
+	}
+	httpErrf(w, http.StatusBadRequest, "parsing request body: %s", err)
+}
+
+// jsonTxLimit is the largest JSON form of a /submit request body
+// (see jsonSubmission)
+// for a tx of at most max bytes:
+// hex doubles its size,
+// and the rest is allowance for the field name and whitespace.
+func jsonTxLimit(max int) int64 {
+	if max <= 0 {
+		return 0
+	}
+	return 2*int64(max) + 1024
+}
+
+// trustedTxLimit is the largest /submit/trusted request body
+// (see encodeTrustedTx)
+// for a tx of at most max bytes.
+// Besides the tx itself, the body carries the tx's parsed effects,
+// which repeat data from its program;
+// the rest is allowance for gob's type descriptions.
+func trustedTxLimit(max int) int64 {
+	if max <= 0 {
+		return 0
+	}
+	return 4*int64(max) + 4096
+}
+
+// checkTxLimits checks a submitted tx of the given version, serialized size, and runlimit
+// against txVersion, -max-tx-bytes, -max-block-bytes, and -max-tx-runlimit,
+// returning the HTTP status with which to reject it if it exceeds any.
+// It should be called before the tx is run.
+func (n *node) checkTxLimits(ctx context.Context, version int64, size int, runlimit int64) (int, error) {
+	if version != txVersion {
+		return http.StatusBadRequest, fmt.Errorf("tx version %d is not the %d accepted", version, txVersion)
+	}
+	if n.maxTxBytes > 0 && size > n.maxTxBytes {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("tx is %d bytes, more than the %d allowed", size, n.maxTxBytes)
+	}
+	var maxBlockBytes int
+	err := n.doContext(ctx, func() {
+		maxBlockBytes = n.pool.MaxBlockBytes
+	})
+	if err != nil {
+		return http.StatusServiceUnavailable, errors.Wrap(err, "getting block limits")
+	}
+	if maxBlockBytes > 0 && size > maxBlockBytes {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("tx is %d bytes, more than the %d allowed in a block", size, maxBlockBytes)
+	}
+	if n.maxTxRunlimit > 0 && runlimit > n.maxTxRunlimit {
+		return http.StatusBadRequest, fmt.Errorf("tx runlimit %d is more than the %d allowed", runlimit, n.maxTxRunlimit)
+	}
+	return 0, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestTxLimits(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.dev = true // commit without leaving a block timer running
+
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
+	txbits, err := proto.Marshal(&tx.RawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name          string
+		maxTxBytes    int
+		maxTxRunlimit int64
+		maxBlockBytes int
+		unknownLength bool
+		want          int
+	}{
+		{"no limits", 0, 0, 0, false, http.StatusNoContent},
+		{"within limits", len(txbits), tx.Runlimit, len(txbits), false, http.StatusOK}, // already committed
+		{"too big", len(txbits) - 1, 0, 0, false, http.StatusRequestEntityTooLarge},
+		{"too big, unknown length", len(txbits) - 1, 0, 0, true, http.StatusRequestEntityTooLarge},
+		{"runlimit too high", 0, tx.Runlimit - 1, 0, false, http.StatusBadRequest},
+		{"bigger than a block", 0, 0, len(txbits) - 1, false, http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n.maxTxBytes = c.maxTxBytes
+			n.maxTxRunlimit = c.maxTxRunlimit
+			n.do(func() {
+				n.pool.MaxBlockBytes = c.maxBlockBytes
+			})
+
+			req := httptest.NewRequest("POST", "/submit", bytes.NewReader(txbits))
+			if c.unknownLength {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			n.submit(rec, req)
+			if rec.Code != c.want {
+				t.Errorf("got status %d, want %d (%s)", rec.Code, c.want, rec.Body)
+			}
+		})
+	}
+
+	// A tx of another version is refused before it is run.
+	n.maxTxBytes, n.maxTxRunlimit = 0, 0
+	raw := tx.RawTx
+	raw.Version = txVersion + 1
+	bits, err := proto.Marshal(&raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	n.submit(rec, httptest.NewRequest("POST", "/submit", bytes.NewReader(bits)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d submitting tx version %d, want %d (%s)", rec.Code, raw.Version, http.StatusBadRequest, rec.Body)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/chain/txvm/errors"
+)
+
+// unixPrefix begins an -addr naming a Unix domain socket,
+// as in unix:///var/run/txvmbcd.sock.
+const unixPrefix = "unix://"
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// listen returns the server's listener:
+// the socket passed by systemd socket activation, if there is one;
+// otherwise a Unix domain socket at PATH if addr is unix://PATH;
+// otherwise a TCP listener on addr.
+func listen(addr string) (net.Listener, error) {
+	l, err := activatedListener()
+	if err != nil || l != nil {
+		return l, err
+	}
+	if path := strings.TrimPrefix(addr, unixPrefix); path != addr {
+		return listenUnix(path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// activatedListener returns the socket passed to this process by systemd
+// (as described in sd_listen_fds(3)),
+// or nil if there is none.
+func activatedListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds < 1 {
+		return nil, nil
+	}
+	// Keep child processes (such as -admit scripts) from seeing the sockets.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if nfds > 1 {
+		return nil, fmt.Errorf("got %d sockets from systemd, want 1", nfds)
+	}
+
+	f := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	return l, errors.Wrap(err, "using the socket from systemd")
+}
+
+// listenUnix listens on a Unix domain socket at path,
+// first removing a stale socket left there by a server that did not shut down cleanly.
+// It is an error if another server is listening on path.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another server is listening on %s", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "removing stale socket")
+		}
+	}
+	return net.Listen("unix", path)
+}
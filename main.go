@@ -2,16 +2,27 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
-	"sync"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/chain/txvm/errors"
@@ -22,83 +33,517 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var (
-	bbmu sync.Mutex
-	bb   *protocol.BlockBuilder
-)
-
-var blockInterval = 5 * time.Second
-
-var (
-	initialBlock *bc.Block
-	chain        *protocol.Chain
-)
+// subcommands maps the name of each subcommand to its implementation.
+// With no subcommand, txvmbcd runs the server.
+var subcommands = map[string]func(ctx context.Context, args []string) error{
+	"backup":  backupCmd,
+	"ca":      caCmd,
+	"export":  exportCmd,
+	"fsck":    fsckCmd,
+	"import":  importCmd,
+	"indexer": indexerCmd,
+	"restore": restoreCmd,
+	"route":   routeCmd,
+	"standby": standbyCmd,
+	"verify":  verifyCmd,
+}
 
 func main() {
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			err := cmd(ctx, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
 
 	var (
-		addr   = flag.String("addr", "localhost:2423", "server listen address")
-		dbfile = flag.String("db", "", "path to block storage db")
+		addr        = flag.String("addr", "localhost:2423", "server listen address, or unix://PATH for a Unix domain socket (ignored under systemd socket activation)")
+		dbfile      = flag.String("db", "", "path to block storage db")
+		index       = flag.Bool("index", false, "maintain indexes of outputs by asset ID and pubkey")
+		webhooks    = flag.Bool("hooks", false, "enable webhook notifications of committed blocks")
+		registryURL = flag.String("schema-registry", "", "with -hooks, URL of the schema registry for webhooks that choose the avro codec")
+		hooksOpen   = flag.Bool("hooks-open", false, "with -hooks and no -admin-tokens-file, let anyone register webhooks")
+		blooms      = flag.Bool("bloom", false, "maintain a bloom filter of the assets and outputs touched by each block")
+		events      = flag.Bool("events", false, "maintain an index of tx log entries, enabling /events")
+		dev         = flag.Bool("dev", false, "development mode: commit a block immediately on each submit, and enable /dev/issue")
+		readonly    = flag.Bool("readonly", false, "serve blocks, info, and indexes but reject submissions and build no blocks")
+
+		redisAddr    = flag.String("redis", "", "address of a Redis server in which to mirror the -index indexes")
+		redisRebuild = flag.Bool("redis-rebuild", false, "rebuild the Redis mirror from scratch on startup")
+		invariants   = flag.String("invariants", "alert", "with -index, check asset supply after each block and on violation: off, alert (log), or halt (exit)")
+
+		priority = flag.String("priority", "fifo", "order in which pending txs enter a block: fifo, fee, feerate, or txid")
+		feeAsset = flag.String("fee-asset", "", "hex ID of the asset whose retirements count as fees")
+
+		backupDir      = flag.String("backup-dir", "", "directory in which to write periodic db backups")
+		backupInterval = flag.Duration("backup-interval", time.Hour, "with -backup-dir, interval between backups")
+		backupKeep     = flag.Int("backup-keep", 24, "with -backup-dir, number of backups of each chain to keep")
+
+		persistInterval  = flag.Duration("persist-interval", 0, "write the chain state to DBFILE at this interval instead of every 100 blocks (0 for the default)")
+		snapshotInterval = flag.Uint64("snapshot-interval", 0, "write the chain state to DBFILE every this many blocks instead of every 100 (0 for the default)")
+
+		shutdownReportFile = flag.String("shutdown-report", "", "file to which to write a JSON report on shutdown")
+
+		faucetKeyFile = flag.String("faucet-key-file", "", "file containing the hex seed of the key that enables /faucet issuances of a test asset")
+		faucetMax     = flag.Int64("faucet-max", 1000, "largest amount issued by one /faucet request")
+
+		maxTxBytes    = flag.Int("max-tx-bytes", 1<<20, "maximum size in bytes of a submitted tx, bounding request bodies (0 for no limit)")
+		maxTxRunlimit = flag.Int64("max-tx-runlimit", 0, "maximum runlimit of a submitted tx (0 for no limit)")
+		verifyWorkers = flag.Int("verify-workers", 0, "run /submit txs in this many worker goroutines, responding with a submission ID before the tx is run (0 to run each on its request)")
+
+		admitScript = flag.String("admit", "", "executable run on each submitted tx, given its details as JSON on stdin, that admits the tx by exiting 0")
+		blockScript = flag.String("block-script", "", "executable run as each block is built, given its height and timestamp as JSON on stdin, that may print a hex RawTx to put first in the block")
+
+		precommitURL     = flag.String("precommit-url", "", "URL to which to POST each block before committing it; the block is committed only if the response status is 2xx")
+		precommitTimeout = flag.Duration("precommit-timeout", 5*time.Second, "with -precommit-url, how long to wait for the acknowledgment")
+		precommitPolicy  = flag.String("precommit-policy", "retry", "with -precommit-url, what to do with an unacknowledged block: retry (put its txs back in the pool for the next block) or halt (and halt the chain)")
+
+		tlsCert       = flag.String("tls-cert", "", "file containing the server's TLS certificate, to serve HTTPS (requires -tls-key)")
+		tlsKey        = flag.String("tls-key", "", "file containing the key for -tls-cert")
+		tlsClientCA   = flag.String("tls-client-ca", "", "with -tls-cert, require client certificates signed by a CA in this file")
+		tlsExpiryWarn = flag.Duration("tls-expiry-warn", 30*24*time.Hour, "with -tls-cert, warn of TLS certificates expiring within this long")
+
+		corsOrigins = flag.String("cors-origins", "", "comma-separated origins (or *) allowed to make cross-origin requests")
+
+		otlpEndpoint = flag.String("otlp-endpoint", "", "base URL of an OpenTelemetry collector (e.g. http://localhost:4318) to which to export trace spans over OTLP/HTTP")
+		otlpService  = flag.String("otlp-service", "txvmbcd", "with -otlp-endpoint, the service name reported with each span")
+
+		gzipLevel = flag.Int("gzip-level", gzip.DefaultCompression, "gzip compression level for responses to clients that accept it, from 1 (fastest) to 9 (smallest), or 0 for none")
+
+		ui = flag.Bool("ui", false, "serve a web-based block explorer at /ui/")
+
+		checkOnly  = flag.Bool("check-config", false, "validate the configuration, report, and exit without serving")
+		configFile = flag.String("config", "", "YAML file of flag settings, reread on SIGHUP (flags on the command line take precedence)")
+
+		// The flags that SIGHUP reloads.
+		sf = defineSettingsFlags(flag.CommandLine)
+
+		chains chainsFlag
 	)
+	flag.Var(&chains, "chain", "host an additional chain as ID=DBFILE, served under /chains/ID (may be repeated)")
 
 	flag.Parse()
 
-	db, err := sql.Open("sqlite3", *dbfile)
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	if *configFile != "" {
+		cfg, err := readConfigFile(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = applyConfig(flag.CommandLine, cfg, given)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	s, err := sf.load()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
+	s.apply()
 
-	heights := make(chan uint64)
-	bs, err := newBlockStore(db, heights)
-	if err != nil {
-		log.Fatal(err)
+	if _, ok := priorities[*priority]; !ok {
+		log.Fatalf("unknown priority %q", *priority)
+	}
+	poolConfig := txPool{
+		Priority:      *priority,
+		MaxBlockTxs:   s.maxBlockTxs,
+		MaxBlockBytes: s.maxBlockBytes,
+		TTL:           s.poolTTL,
+	}
+	if *feeAsset != "" {
+		feeAssetBytes, err := hex.DecodeString(*feeAsset)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "parsing fee asset"))
+		}
+		if len(feeAssetBytes) != 32 {
+			log.Fatalf("fee asset is %d bytes long, want 32", len(feeAssetBytes))
+		}
+		poolConfig.FeeAsset = bc.HashFromBytes(feeAssetBytes)
 	}
 
-	initialBlock, err = bs.GetBlock(ctx, 1)
-	if err != nil {
-		log.Fatal(err)
+	if _, err := gzip.NewWriterLevel(nil, *gzipLevel); err != nil {
+		log.Fatal(errors.Wrap(err, "checking -gzip-level"))
 	}
 
-	chain, err = protocol.NewChain(ctx, initialBlock, bs, heights)
-	if err != nil {
-		log.Fatal("initializing Chain: ", err)
+	switch *invariants {
+	case "off", "alert", "halt":
+	default:
+		log.Fatalf("unknown -invariants mode %q", *invariants)
+	}
+	var fct *faucet
+	if *faucetKeyFile != "" {
+		prv, pub, err := readFaucetKey(*faucetKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fct = &faucet{prv: prv, pub: pub, max: *faucetMax}
+	}
+
+	if *backupDir != "" && (*backupInterval <= 0 || *backupKeep < 1) {
+		log.Fatal("-backup-interval and -backup-keep must be positive")
+	}
+
+	if *readonly && (*dev || *faucetKeyFile != "") {
+		log.Fatal("-readonly cannot be combined with -dev or -faucet-key-file")
+	}
+
+	if *redisAddr != "" && !*index {
+		log.Fatal("-redis requires -index")
+	}
+
+	if *otlpEndpoint != "" {
+		traceExporter = &tracer{
+			endpoint: *otlpEndpoint,
+			service:  *otlpService,
+			client:   &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	var precommit *precommitHook
+	if *precommitURL != "" {
+		halt, ok := precommitPolicies[*precommitPolicy]
+		if !ok {
+			log.Fatalf("unknown -precommit-policy %q", *precommitPolicy)
+		}
+		precommit = &precommitHook{
+			url:     *precommitURL,
+			timeout: *precommitTimeout,
+			halt:    halt,
+			client:  new(http.Client),
+		}
+	}
+
+	if *hooksOpen && !*webhooks {
+		log.Fatal("-hooks-open requires -hooks")
+	}
+
+	var registry *schemaRegistry
+	if *registryURL != "" {
+		if !*webhooks {
+			log.Fatal("-schema-registry requires -hooks")
+		}
+		registry = &schemaRegistry{url: *registryURL}
+	}
+
+	var tlsConfig *tls.Config
+	if *tlsCert != "" || *tlsKey != "" {
+		if *tlsCert == "" || *tlsKey == "" {
+			log.Fatal("-tls-cert and -tls-key must be given together")
+		}
+		var err error
+		tlsConfig, err = serverTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		certs, err := loadCertExpiries(*tlsCert)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *tlsClientCA != "" {
+			caCerts, err := loadCertExpiries(*tlsClientCA)
+			if err != nil {
+				log.Fatal(err)
+			}
+			certs = append(certs, caCerts...)
+		}
+		if !*checkOnly {
+			watchCertExpiry(ctx, certs, *tlsExpiryWarn)
+		}
+	} else if *tlsClientCA != "" {
+		log.Fatal("-tls-client-ca requires -tls-cert")
+	}
+	if *persistInterval > 0 && *snapshotInterval > 0 {
+		log.Fatal("-persist-interval and -snapshot-interval cannot be used together")
+	}
+
+	if *checkOnly {
+		cfg := serverConfig{
+			addr:        *addr,
+			dbfiles:     make(map[string]string),
+			admitScript: *admitScript,
+			blockScript: *blockScript,
+			backupDir:   *backupDir,
+		}
+		if *index {
+			cfg.redisAddr = *redisAddr
+		}
+		if *dbfile != "" || len(chains) == 0 {
+			cfg.dbfiles[""] = *dbfile
+		}
+		for _, c := range chains {
+			cfg.dbfiles[c.id] = c.dbfile
+		}
+		if problems := checkConfig(ctx, cfg, os.Stdout); problems > 0 {
+			log.Fatalf("%d problem(s) found", problems)
+		}
+		return
+	}
+
+	if *backupDir != "" {
+		err := os.MkdirAll(*backupDir, 0755)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	openNode := func(name, dbfile string) *node {
+		db, err := sql.Open("sqlite3", dbfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, err := newNode(ctx, name, db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		poolConfig.log = n.log
+		*n.pool = poolConfig
+		n.dev = *dev
+		n.readonly = *readonly
+		n.trustedToken = s.trustedToken
+		n.admitScript = *admitScript
+		n.blockScript = *blockScript
+		n.precommit = precommit
+		n.maxTxBytes = *maxTxBytes
+		n.maxTxRunlimit = *maxTxRunlimit
+		if *verifyWorkers > 0 {
+			n.startVerifiers(ctx, *verifyWorkers)
+		}
+		n.flags = flag.CommandLine
+		n.ui = *ui
+		n.faucet = fct
+		n.adminTokens = s.adminTokens
+		n.publishCapacity(ctx)
+		if *persistInterval > 0 {
+			n.startPersister(ctx, *persistInterval)
+		}
+		if *snapshotInterval > 0 {
+			n.startCheckpointer(ctx, *snapshotInterval)
+		}
+		if *backupDir != "" {
+			n.startBackups(ctx, *backupDir, *backupInterval, *backupKeep)
+		}
+		if *index {
+			var mirror *redisMirror
+			if *redisAddr != "" {
+				mirror = newRedisMirror(*redisAddr, "txvmbcd:"+name+":", *redisRebuild)
+			}
+			err = n.startIndexer(ctx, mirror, *invariants)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *webhooks {
+			n.hooksOpen = *hooksOpen
+			err = n.startHooks(ctx, registry)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *blooms {
+			err = n.startBlooms(ctx)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *events {
+			err = n.startEvents(ctx)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		return n
+	}
+
+	// The chain in -db is served at the top level.
+	// It is the only chain, as in earlier versions, when there are no -chain flags.
+	var (
+		defaultNode *node
+		nodes       []*node
+	)
+	if *dbfile != "" || len(chains) == 0 {
+		defaultNode = openNode("", *dbfile)
+		nodes = append(nodes, defaultNode)
+		defaultNode.handle(http.DefaultServeMux, "")
 	}
-	_, err = chain.Recover(ctx)
+	for _, c := range chains {
+		n := openNode(c.id, c.dbfile)
+		nodes = append(nodes, n)
+		n.handle(http.DefaultServeMux, "/chains/"+c.id)
+		n.log.Printf("serving under /chains/%s, initial block ID %x", c.id, n.initialBlock.Hash().Bytes())
+	}
+
+	listener, err := listen(*addr)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	if defaultNode != nil {
+		log.Printf("listening on %s, initial block ID %x", listener.Addr(), defaultNode.initialBlock.Hash().Bytes())
+	} else {
+		log.Printf("listening on %s", listener.Addr())
+	}
 
-	initialBlockID := initialBlock.Hash()
+	var handler http.Handler = gzipHandler(*gzipLevel, http.DefaultServeMux)
+	if *corsOrigins != "" {
+		handler = corsHandler(strings.Split(*corsOrigins, ","), handler)
+	}
 
-	listener, err := net.Listen("tcp", *addr)
+	server := &http.Server{
+		Handler: handler,
+		// Canceling ctx ends pending long polls at shutdown.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	if traceExporter != nil {
+		// The exporter outlives ctx to send the spans of the shutdown.
+		traceCtx, cancelTrace := context.WithCancel(context.Background())
+		traceDone := make(chan struct{})
+		go func() {
+			traceExporter.Run(traceCtx)
+			close(traceDone)
+		}()
+		defer func() {
+			cancelTrace()
+			<-traceDone
+		}()
+	}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigs {
+		if sig != syscall.SIGHUP {
+			log.Printf("got %s, shutting down", sig)
+			break
+		}
+		s, err := reloadSettings(flag.CommandLine, *configFile, given)
+		if err != nil {
+			log.Printf("reloading settings, keeping the old ones: %s", err)
+			continue
+		}
+		s.apply()
+		for _, n := range nodes {
+			n.reload(s)
+		}
+		log.Print("reloaded settings")
+	}
+
+	for _, n := range nodes {
+		n.stop()
+	}
+	cancel()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	err = server.Shutdown(shutdownCtx)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("stopping HTTP server: %s", err)
 	}
 
-	log.Printf("listening on %s, initial block ID %x", listener.Addr(), initialBlockID.Bytes())
+	var reports []*shutdownReport
+	for _, n := range nodes {
+		reports = append(reports, n.shutdown(shutdownCtx))
+	}
+	writeShutdownReports(reports, *shutdownReportFile)
+}
 
-	http.HandleFunc("/submit", submit)
-	http.HandleFunc("/get", get)
-	http.Serve(listener, nil)
+// chainsFlag is the value of the repeatable -chain flag.
+type chainsFlag []struct {
+	id, dbfile string
 }
 
-func submit(w http.ResponseWriter, req *http.Request) {
-	ctx := req.Context()
+var chainIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (c *chainsFlag) String() string {
+	var strs []string
+	for _, ch := range *c {
+		strs = append(strs, ch.id+"="+ch.dbfile)
+	}
+	return strings.Join(strs, ",")
+}
 
+func (c *chainsFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return fmt.Errorf("chain %q is not of the form ID=DBFILE", s)
+	}
+	id := parts[0]
+	if !chainIDRegexp.MatchString(id) {
+		return fmt.Errorf("chain ID %q may contain only letters, digits, underscores, and hyphens", id)
+	}
+	if id == "default" {
+		// Reserved for the chain in -db, e.g. in backup names.
+		return fmt.Errorf("chain ID %q is reserved", id)
+	}
+	for _, ch := range *c {
+		if ch.id == id {
+			return fmt.Errorf("duplicate chain ID %q", id)
+		}
+	}
+	*c = append(*c, struct{ id, dbfile string }{id, parts[1]})
+	return nil
+}
+
+func (n *node) submit(w http.ResponseWriter, req *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	isJSON := mediaType == "application/json"
+
+	maxBody := int64(n.maxTxBytes)
+	if isJSON {
+		maxBody = jsonTxLimit(n.maxTxBytes)
+	}
+	if !limitBody(w, req, maxBody) {
+		return
+	}
 	bits, err := ioutil.ReadAll(req.Body)
+	if err == errBodyTooLarge {
+		readErrf(w, err, maxBody)
+		return
+	}
 	if err != nil {
 		httpErrf(w, http.StatusInternalServerError, "reading request body: %s", err)
 		return
 	}
 
+	if isJSON {
+		bits, err = decodeJSONSubmission(bits)
+		if err != nil {
+			httpErrf(w, http.StatusBadRequest, "parsing request body: %s", err)
+			return
+		}
+	}
+
 	var rawTx bc.RawTx
 	err = proto.Unmarshal(bits, &rawTx)
 	if err != nil {
 		httpErrf(w, http.StatusBadRequest, "parsing request body: %s", err)
 		return
 	}
+	if code, err := n.checkTxLimits(req.Context(), rawTx.Version, len(bits), rawTx.Runlimit); err != nil {
+		httpErrf(w, code, "%s", err)
+		return
+	}
+
+	if n.verifier != nil {
+		n.verifyLater(w, req, bits, rawTx)
+		return
+	}
 
 	tx, err := bc.NewTx(rawTx.Program, rawTx.Version, rawTx.Runlimit)
 	if err != nil {
@@ -106,60 +551,214 @@ func submit(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	bbmu.Lock()
-	defer bbmu.Unlock()
+	n.enqueue(w, req, tx)
+}
+
+// enqueue checks the admission of tx,
+// offers it to the builder goroutine
+// (which adds it to the pool, or in -dev mode commits it immediately),
+// and responds to the /submit request.
+// If tx is already pending or was recently committed,
+// it responds with tx's status instead.
+// A request with an Idempotency-Key header
+// may not reuse the key of a recent request for a different tx.
+func (n *node) enqueue(w http.ResponseWriter, req *http.Request, tx *bc.Tx) {
+	if n.readonly {
+		httpErrf(w, http.StatusForbidden, "read-only node does not accept transactions")
+		return
+	}
 
-	if bb == nil {
-		bb = protocol.NewBlockBuilder()
-		nextBlockTime := time.Now().Add(blockInterval)
+	spanFromContext(req.Context()).set("tx.id", hex.EncodeToString(tx.ID.Bytes()))
+
+	res := n.place(req.Context(), tx, req.Header.Get("Idempotency-Key"))
+	switch {
+	case res.err != nil:
+		httpErrf(w, res.code, "%s", res.err)
+	case res.status != nil:
+		respondJSON(w, res.status)
+	case n.dev:
+		n.infof("committed tx %x in block %d", tx.ID.Bytes(), res.height)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		n.infof("added tx %x to the pool", tx.ID.Bytes())
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
 
-		st := chain.State()
-		if st.Header == nil {
-			err = st.ApplyBlockHeader(initialBlock.BlockHeader)
-			if err != nil {
-				httpErrf(w, http.StatusInternalServerError, "initializing empty state: %s", err)
-				return
-			}
-		}
+// place checks the admission of tx
+// and offers it to the builder goroutine,
+// returning the builder's answer
+// (or the reason tx was not admitted).
+func (n *node) place(ctx context.Context, tx *bc.Tx, key string) submitted {
+	err := n.admit(ctx, tx)
+	if _, ok := err.(errNotAdmitted); ok {
+		return submitted{code: http.StatusForbidden, err: fmt.Errorf("tx %x %s", tx.ID.Bytes(), err)}
+	}
+	if err != nil {
+		return submitted{code: http.StatusInternalServerError, err: fmt.Errorf("checking admission of tx %x: %s", tx.ID.Bytes(), err)}
+	}
+	return n.offer(ctx, tx, key)
+}
 
-		err := bb.Start(chain.State(), bc.Millis(nextBlockTime))
+// jsonSubmission is the JSON form of a /submit request body,
+// for callers (such as browsers) that find binary bodies awkward.
+// Exactly one field holds the serialized RawTx.
+type jsonSubmission struct {
+	Hex    string `json:"hex,omitempty"`
+	Base64 string `json:"base64,omitempty"`
+}
+
+// decodeJSONSubmission returns the serialized RawTx in a jsonSubmission.
+func decodeJSONSubmission(body []byte) ([]byte, error) {
+	var sub jsonSubmission
+	err := json.Unmarshal(body, &sub)
+	if err != nil {
+		return nil, err
+	}
+	return sub.rawTx()
+}
+
+// rawTx returns the serialized RawTx in sub.
+func (sub jsonSubmission) rawTx() ([]byte, error) {
+	switch {
+	case sub.Hex != "" && sub.Base64 != "":
+		return nil, errors.New("only one of hex and base64 may be given")
+	case sub.Hex != "":
+		return hex.DecodeString(sub.Hex)
+	case sub.Base64 != "":
+		return base64.StdEncoding.DecodeString(sub.Base64)
+	}
+	return nil, errors.New("one of hex or base64 is required")
+}
+
+// blockBuilder returns a block builder configured by n's settings.
+func (n *node) blockBuilder() *protocol.BlockBuilder {
+	bb := protocol.NewBlockBuilder()
+	if n.pool.MaxBlockTxs > 0 {
+		bb.MaxBlockTxs = n.pool.MaxBlockTxs
+	}
+	return bb
+}
+
+// buildBlock builds a block with the given timestamp from the pool
+// and commits it to the chain,
+// returning it (or nil if no block was committed).
+// It runs in the builder goroutine.
+func (n *node) buildBlock(ctx context.Context, timestamp time.Time) *bc.UnsignedBlock {
+	var spanErr error
+	ctx, sp := startSpan(ctx, "block.build")
+	defer func() { sp.finish(spanErr) }()
+
+	st := n.chain.State()
+	if st.Header == nil {
+		err := st.ApplyBlockHeader(n.initialBlock.BlockHeader)
 		if err != nil {
-			httpErrf(w, http.StatusInternalServerError, "starting a new tx pool: %s", err)
-			return
+			n.log.Fatal(errors.Wrap(err, "initializing empty state"))
 		}
-		log.Printf("starting new block, will commit at %s", nextBlockTime)
-		time.AfterFunc(blockInterval, func() {
-			bbmu.Lock()
-			defer bbmu.Unlock()
+	}
+	n.checkClock(st.Header)
+	timestamp = n.blockTimestamp(st.Header, timestamp)
 
-			defer func() { bb = nil }()
+	started := time.Now()
+	rec := &buildRecord{
+		StartedMS:  bc.Millis(started),
+		Considered: n.pool.len(),
+	}
+	defer n.recordBuild(ctx, rec)
 
-			unsignedBlock, newSnapshot, err := bb.Build()
-			if err != nil {
-				log.Fatal(errors.Wrap(err, "building new block"))
-			}
-			if len(unsignedBlock.Transactions) == 0 {
-				log.Print("skipping commit of empty block")
-				return
-			}
-			err = chain.CommitAppliedBlock(ctx, &bc.Block{UnsignedBlock: unsignedBlock}, newSnapshot)
-			if err != nil {
-				log.Fatal(errors.Wrap(err, "committing new block"))
+	bb := n.blockBuilder()
+	err := bb.Start(st, bc.Millis(timestamp))
+	if err != nil {
+		err = errors.Wrap(err, "starting a new block")
+		n.log.Print(err)
+		rec.Error = err.Error()
+		spanErr = err
+		return nil
+	}
+	var prefix []*bc.CommitmentsTx
+	if n.blockScript != "" {
+		tx, err := n.blockScriptTx(ctx, st.Header, bc.Millis(timestamp))
+		if err == nil && tx != nil {
+			btx := bc.NewCommitmentsTx(tx)
+			err = errors.Wrapf(bb.AddTx(btx), "adding tx %x", tx.ID.Bytes())
+			if err == nil {
+				prefix = append(prefix, btx)
 			}
-			log.Printf("committed block %d with %d transaction(s)", unsignedBlock.Height, len(unsignedBlock.Transactions))
-		})
+		}
+		if err != nil {
+			n.log.Print(errors.Wrap(err, "block script"))
+		}
 	}
+	rec.Rejected = n.pool.evict(bc.Millis(timestamp), started)
+	fill := n.pool.fill(bb, st, bc.Millis(timestamp), prefix...)
+	rec.Rejected = append(rec.Rejected, fill.Rejected...)
+	if !n.dev {
+		// The next block can be no earlier than a block interval from now.
+		rec.Rejected = append(rec.Rejected, n.pool.evict(bc.Millis(time.Now().Add(blockInterval())), started)...)
+	}
+	rec.Deferred = n.pool.len()
 
-	err = bb.AddTx(bc.NewCommitmentsTx(tx))
+	unsignedBlock, newSnapshot, err := bb.Build()
 	if err != nil {
-		httpErrf(w, http.StatusBadRequest, "adding tx to pool: %s", err)
-		return
+		// Build only applies the block header
+		// (AddTx already applied each tx),
+		// so none of the txs is to blame;
+		// return them to the pool for the next attempt.
+		err = errors.Wrap(err, "building new block")
+		n.log.Printf("%s; will retry at the next block interval", err)
+		n.pool.putBack(fill)
+		rec.Deferred = n.pool.len()
+		n.recordRejected(ctx, rec.Rejected)
+		rec.Error = err.Error()
+		spanErr = err
+		return nil
+	}
+	n.recordRejected(ctx, rec.Rejected)
+	rec.BuildUS = microsSince(started)
+	sp.set("height", unsignedBlock.Height)
+	sp.set("txs", len(unsignedBlock.Transactions))
+	if len(unsignedBlock.Transactions) == len(prefix) {
+		n.infof("skipping commit of empty block")
+		sp.set("skipped", true)
+		return nil
+	}
+	b := &bc.Block{UnsignedBlock: unsignedBlock}
+	if n.precommit != nil {
+		precommitCtx, precommitSpan := startSpan(ctx, "block.precommit")
+		err = n.precommit.notify(precommitCtx, n.name, b)
+		precommitSpan.finish(err)
+		if err != nil {
+			err = errors.Wrapf(err, "precommit hook for block %d", unsignedBlock.Height)
+			n.pool.putBack(fill)
+			rec.Deferred = n.pool.len()
+			rec.Error = err.Error()
+			spanErr = err
+			if n.precommit.halt {
+				n.halted = true
+				n.log.Printf("%s; halting", err)
+			} else {
+				n.log.Printf("%s; will retry at the next block interval", err)
+			}
+			return nil
+		}
 	}
-	log.Printf("added tx %x to the pending block", tx.ID.Bytes())
-	w.WriteHeader(http.StatusNoContent)
+	commitStarted := time.Now()
+	commitCtx, commitSpan := startSpan(ctx, "block.commit")
+	err = n.chain.CommitAppliedBlock(commitCtx, b, newSnapshot)
+	commitSpan.finish(err)
+	if err != nil {
+		n.log.Fatal(errors.Wrap(err, "committing new block"))
+	}
+	n.store.checkpoint(newSnapshot)
+	rec.CommitUS = microsSince(commitStarted)
+	rec.Height = unsignedBlock.Height
+	rec.Included = len(unsignedBlock.Transactions)
+	n.txs.recordBlock(unsignedBlock)
+	n.infof("committed block %d with %d transaction(s), %d left in the pool", unsignedBlock.Height, len(unsignedBlock.Transactions), n.pool.len())
+	return unsignedBlock
 }
 
-func get(w http.ResponseWriter, req *http.Request) {
+func (n *node) get(w http.ResponseWriter, req *http.Request) {
 	wantStr := req.FormValue("height")
 	var (
 		want uint64 = 1
@@ -173,30 +772,69 @@ func get(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	height := chain.Height()
+	// A block requested by height never changes,
+	// but "height=0" (the latest block) does.
+	immutable := want != 0
+
+	height := n.store.FinalHeight()
 	if want == 0 {
 		want = height
 	}
 	if want > height {
 		ctx := req.Context()
-		waiter := chain.BlockWaiter(want)
+		if waitStr := req.FormValue("wait"); waitStr != "" {
+			wait, err := time.ParseDuration(waitStr)
+			if err != nil {
+				httpErrf(w, http.StatusBadRequest, "parsing wait: %s", err)
+				return
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, wait)
+			defer cancel()
+		}
+		waiter := n.store.BlockWaiter(ctx, want)
+		atomic.AddInt64(&n.longPolls, 1)
 		select {
 		case <-waiter:
 			// ok
 		case <-ctx.Done():
+			atomic.AddInt64(&n.longPolls, -1)
 			httpErrf(w, http.StatusRequestTimeout, "timed out")
 			return
 		}
+		atomic.AddInt64(&n.longPolls, -1)
 	}
 
 	ctx := req.Context()
 
-	b, err := chain.GetBlock(ctx, want)
+	hash, err := n.store.BlockHash(ctx, want)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "getting hash of block %d: %s", want, err)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, hash)
+	w.Header().Set("ETag", etag)
+	if immutable {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	b, err := n.chain.GetBlock(ctx, want)
 	if err != nil {
 		httpErrf(w, http.StatusInternalServerError, "getting block %d: %s", want, err)
 		return
 	}
 
+	if req.FormValue("format") == "json" {
+		respondJSON(w, newBlockJSON(n.name, b))
+		return
+	}
+
 	bits, err := b.Bytes()
 	if err != nil {
 		httpErrf(w, http.StatusInternalServerError, "serializing block %d: %s", want, err)
@@ -211,6 +849,17 @@ func get(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// etagMatches tells whether an If-None-Match header value matches etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func httpErrf(w http.ResponseWriter, code int, msgfmt string, args ...interface{}) {
 	http.Error(w, fmt.Sprintf(msgfmt, args...), code)
 	log.Printf(msgfmt, args...)
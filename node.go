@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// node is a single blockchain hosted by the server,
+// with its own database, pending transactions, and optional indexes.
+type node struct {
+	longPolls int64 // number of /get requests waiting for a block, accessed atomically
+	pending   int64 // number of txs in the pool, accessed atomically
+
+	name         string
+	db           *sql.DB
+	initialBlock *bc.Block
+	store        *blockStore
+	chain        *protocol.Chain
+	log          *log.Logger
+	started      time.Time
+
+	// The builder goroutine (see runBuilder) owns pool, txs, closed, and halted.
+	// Other goroutines reach it through submissions and calls.
+	submissions chan *submission
+	calls       chan func()
+	quit        chan struct{} // closed to stop the builder goroutine
+	pool        *txPool
+	txs         *txTracker
+	closed      bool // no more blocks may be built on the timer or submitted
+	halted      bool // like closed, but reversible by an admin
+
+	maxTxBytes    int   // largest submitted tx, if positive
+	maxTxRunlimit int64 // largest runlimit of a submitted tx, if positive
+
+	report   *shutdownReport // set by stop
+	dev      bool            // commit a block on each submit instead of on a timer
+	readonly bool            // reject submissions and build no blocks
+	ui       bool            // serve the explorer at /ui/
+
+	admitScript string         // run on each submission if not empty
+	blockScript string         // run on each block built if not empty
+	precommit   *precommitHook // must acknowledge each block before it is committed, if not nil
+	faucet      *faucet        // enables /faucet if not nil
+	verifier    *verifier      // runs /submit txs asynchronously if not nil
+
+	// A SIGHUP may replace the tokens (see reload).
+	tokensMu     sync.RWMutex
+	trustedToken string       // enables /submit/trusted if not empty
+	adminTokens  []adminToken // enable /admin/... if not nil
+	hooksOpen    bool         // without adminTokens, anyone may manage webhooks
+
+	flags     *flag.FlagSet // the server's flags, for /docs, if not nil
+	endpoints []endpoint    // registered by handle, for /docs
+
+	idx    *indexer      // nil if indexing is not enabled
+	hooks  *hookNotifier // nil if webhooks are not enabled
+	blooms *bloomStore   // nil if bloom filters are not enabled
+	events *eventStore   // nil if the event index is not enabled
+}
+
+// newNode opens the blockchain stored in db,
+// creating it with a new genesis block if db is empty.
+// The name identifies the chain in log messages and may be empty.
+func newNode(ctx context.Context, name string, db *sql.DB) (*node, error) {
+	heights := make(chan uint64, 1)
+	bs, err := newBlockStore(db, heights)
+	if err != nil {
+		return nil, err
+	}
+
+	initialBlock, err := bs.GetBlock(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := protocol.NewChain(ctx, initialBlock, bs, heights)
+	if err != nil {
+		return nil, errors.Wrap(err, "initializing Chain")
+	}
+	_, err = chain.Recover(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "recovering chain state")
+	}
+
+	logger := log.New(log.Writer(), "", log.Flags())
+	if name != "" {
+		logger.SetPrefix("chain " + name + ": ")
+		logger.SetFlags(log.Flags() | log.Lmsgprefix)
+	}
+
+	n := &node{
+		name:         name,
+		db:           db,
+		initialBlock: initialBlock,
+		store:        bs,
+		chain:        chain,
+		log:          logger,
+		started:      time.Now(),
+		submissions:  make(chan *submission),
+		calls:        make(chan func()),
+		quit:         make(chan struct{}),
+		pool:         &txPool{log: logger},
+		txs:          newTxTracker(),
+	}
+	go n.runBuilder()
+	return n, nil
+}
+
+// startIndexer enables output indexing for n.
+// If mirror is not nil, the index is mirrored there.
+// Invariants is "off", "alert", or "halt";
+// see invariantChecker.
+func (n *node) startIndexer(ctx context.Context, mirror *redisMirror, invariants string) error {
+	idx, err := newIndexer(n.db)
+	if err != nil {
+		return err
+	}
+	idx.mirror = mirror
+	if invariants != "off" {
+		idx.checker, err = newInvariantChecker(n.db, invariants == "halt")
+		if err != nil {
+			return err
+		}
+	}
+	n.idx = idx
+	go func() {
+		err := idx.Run(ctx, n.store)
+		if ctx.Err() == nil {
+			n.log.Fatal(errors.Wrap(err, "indexing"))
+		}
+	}()
+	return nil
+}
+
+// startHooks enables webhook notifications for n.
+// Registry, if not nil, is the schema registry for avro notifications.
+func (n *node) startHooks(ctx context.Context, registry *schemaRegistry) error {
+	hooks, err := newHookNotifier(n.db)
+	if err != nil {
+		return err
+	}
+	hooks.registry = registry
+	n.hooks = hooks
+	go func() {
+		err := hooks.Run(ctx, n.store)
+		if ctx.Err() == nil {
+			n.log.Fatal(errors.Wrap(err, "sending notifications"))
+		}
+	}()
+	return nil
+}
+
+// startBlooms enables per-block bloom filters for n.
+func (n *node) startBlooms(ctx context.Context) error {
+	blooms, err := newBloomStore(n.db)
+	if err != nil {
+		return err
+	}
+	n.blooms = blooms
+	go func() {
+		err := blooms.Run(ctx, n.store)
+		if ctx.Err() == nil {
+			n.log.Fatal(errors.Wrap(err, "computing bloom filters"))
+		}
+	}()
+	return nil
+}
+
+// startEvents enables the event index for n.
+func (n *node) startEvents(ctx context.Context) error {
+	events, err := newEventStore(n.db)
+	if err != nil {
+		return err
+	}
+	n.events = events
+	go func() {
+		err := events.Run(ctx, n.store)
+		if ctx.Err() == nil {
+			n.log.Fatal(errors.Wrap(err, "indexing events"))
+		}
+	}()
+	return nil
+}
+
+// startPersister makes n persist its state every interval
+// rather than every so many blocks.
+func (n *node) startPersister(ctx context.Context, interval time.Duration) {
+	go func() {
+		err := n.store.Persist(ctx, n.chain, interval)
+		if ctx.Err() == nil {
+			n.log.Fatal(errors.Wrap(err, "persisting snapshots"))
+		}
+	}()
+}
+
+// startCheckpointer makes n persist its state every blocks blocks
+// rather than every 100.
+func (n *node) startCheckpointer(ctx context.Context, blocks uint64) {
+	n.store.PersistEvery(blocks)
+	go func() {
+		err := n.store.writeCheckpoints(ctx)
+		if ctx.Err() == nil {
+			n.log.Fatal(errors.Wrap(err, "persisting snapshots"))
+		}
+	}()
+}
+
+// handle registers n's HTTP handlers on mux
+// under paths beginning with prefix,
+// recording them in n.endpoints for /docs.
+func (n *node) handle(mux *http.ServeMux, prefix string) {
+	route := func(path, methods, doc string, h http.HandlerFunc) {
+		mux.HandleFunc(prefix+path, h)
+		n.endpoints = append(n.endpoints, endpoint{Path: prefix + path, Methods: methods, Doc: doc})
+	}
+
+	route("/submit", "POST", "submit a serialized RawTx (or, as JSON, its hex or base64 form) for the next block", traced(n.submit))
+	route("/submit/bundle", "POST", "submit a JSON array of txs to be committed in the same block or not at all", traced(n.submitBundle))
+	route("/get", "GET", "get the block at ?height=N (0 for the latest), waiting for it if it is the next one (?format=json for JSON)", n.get)
+	route("/header", "GET", "the serialized header of the block at ?height=N (0 for the latest)", n.header)
+	route("/tx", "GET", "whether the tx with ?id=ID is pending, recently committed, or recently rejected (and why), or (with -verify-workers) the outcome of ?submission=ID", n.txHandler)
+	route("/policy", "GET", "the rules by which txs are admitted to blocks", n.policy)
+	route("/info", "GET", "the chain's IDs and height and the server's version and status", n.info)
+	route("/stats/capacity", "GET", "how full the last ?blocks=N blocks have been", n.statsCapacity)
+	route("/docs", "GET", "this documentation (?format=json for JSON)", n.docs)
+	if n.ui {
+		route("/ui/", "GET", "a web-based block explorer", n.uiHandler)
+	}
+	if n.trustedToken != "" {
+		route("/submit/trusted", "POST", "submit an already-run tx, given the trusted bearer token", traced(n.submitTrusted))
+	}
+	if n.dev {
+		route("/dev/issue", "POST", "commit an issuance of ?amount=N units of the dev asset to ?pubkey=P", n.devIssue)
+	}
+	if n.faucet != nil {
+		route("/faucet", "POST", "submit an issuance of ?amount=N units of the faucet asset to ?pubkey=P", n.faucetIssue)
+	}
+	if n.idx != nil {
+		route("/outputs", "GET", "the unspent outputs with ?assetid=A and ?pubkey=P", n.outputs)
+		route("/state/contracts", "GET", "the unspent contracts with ?seed=S", n.contracts)
+		route("/balance/history", "GET", "the balances of ?pubkey=P as of ?at=HEIGHT or RFC3339 time", n.balanceHistory)
+	}
+	if n.adminTokens != nil {
+		route("/admin/status", "GET", "whether the chain is halted, its height, and its pending txs and long polls (read scope)", n.adminStatus)
+		route("/admin/halt", "POST", "stop block production and submissions (halt scope)", n.adminHalt(true))
+		route("/admin/resume", "POST", "undo /admin/halt (halt scope)", n.adminHalt(false))
+		route("/admin/builds", "GET", "the history of block builds, newest first (read scope)", n.adminBuilds)
+	}
+	if n.hooks != nil {
+		route("/hooks", "POST, DELETE", "register or delete a webhook notified of committed blocks", n.hooksHandler)
+	}
+	if n.blooms != nil {
+		route("/bloom", "GET", "the bloom filter of the assets and outputs touched by the block at ?height=N", n.bloom)
+	}
+	if n.events != nil {
+		route("/events", "GET", "tx log entries filtered by ?type, ?asset, ?pubkey, ?seed, ?from, ?to, and ?limit", n.eventsHandler)
+	}
+}
+
+// follow calls f with each block of s in turn,
+// starting at the given height
+// and waiting for each block that is not yet finalized.
+// It returns only when f returns an error or ctx is canceled.
+func follow(ctx context.Context, s *blockStore, height uint64, f func(*bc.Block) error) error {
+	for ; ; height++ {
+		select {
+		case <-s.BlockWaiter(ctx, height):
+			// ok
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		b, err := s.GetBlock(ctx, height)
+		if err != nil {
+			return errors.Wrapf(err, "getting block %d", height)
+		}
+		err = f(b)
+		if err != nil {
+			return err
+		}
+	}
+}
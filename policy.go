@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// txVersion is the only transaction version the server accepts,
+// though txvm itself runs any version from 3 up.
+const txVersion = 3
+
+// policy describes the rules by which n admits transactions to blocks,
+// so that clients can check a transaction before submitting it.
+type policy struct {
+	// TxVersion is the only transaction version accepted.
+	TxVersion int64 `json:"tx_version"`
+
+	// Priority is the order in which pending transactions are offered to blocks,
+	// and FeeAsset (hex-encoded) is the asset whose retirements count as fees.
+	Priority string `json:"priority"`
+	FeeAsset string `json:"fee_asset,omitempty"`
+
+	// MaxBlockTxs and MaxBlockBytes limit the size of a block.
+	// A MaxBlockBytes of 0 means there is no limit.
+	MaxBlockTxs   int `json:"max_block_txs"`
+	MaxBlockBytes int `json:"max_block_bytes"`
+
+	// BlockIntervalMS is the longest a submitted transaction waits for a block
+	// (0 in -dev mode, where blocks are committed immediately).
+	// A transaction's timerange must include the timestamp of that block.
+	BlockIntervalMS uint64 `json:"block_interval_ms"`
+
+	// MaxNonceWindowMS is how far past the block timestamp a nonce may expire.
+	MaxNonceWindowMS uint64 `json:"max_nonce_window_ms"`
+
+	// MaxBlockWindow is the number of recent blocks whose IDs a transaction may reference.
+	MaxBlockWindow int64 `json:"max_block_window"`
+
+	// PoolTTLMS is how long a transaction may wait for a block before it is evicted
+	// (0 for no limit).
+	PoolTTLMS uint64 `json:"pool_ttl_ms"`
+
+	// MaxTxBytes is the largest serialized transaction accepted
+	// and MaxTxRunlimit the largest runlimit
+	// (0 for no limit).
+	MaxTxBytes    int   `json:"max_tx_bytes"`
+	MaxTxRunlimit int64 `json:"max_tx_runlimit"`
+}
+
+func (n *node) policy(w http.ResponseWriter, req *http.Request) {
+	p, err := n.currentPolicy(req.Context())
+	if err != nil {
+		httpErrf(w, http.StatusServiceUnavailable, "%s", err)
+		return
+	}
+	respondJSON(w, p)
+}
+
+// currentPolicy returns n's policy.
+func (n *node) currentPolicy(ctx context.Context) (*policy, error) {
+	var p policy
+	err := n.doContext(ctx, func() {
+		bb := n.blockBuilder()
+		p = policy{
+			TxVersion:        txVersion,
+			Priority:         n.pool.Priority,
+			MaxBlockTxs:      bb.MaxBlockTxs,
+			MaxBlockBytes:    n.pool.MaxBlockBytes,
+			BlockIntervalMS:  bc.DurationMillis(blockInterval()),
+			MaxNonceWindowMS: bc.DurationMillis(bb.MaxNonceWindow),
+			MaxBlockWindow:   bb.MaxBlockWindow,
+			PoolTTLMS:        bc.DurationMillis(n.pool.TTL),
+			MaxTxBytes:       n.maxTxBytes,
+			MaxTxRunlimit:    n.maxTxRunlimit,
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting block parameters")
+	}
+	if p.Priority == "" {
+		p.Priority = "fifo"
+	}
+	if n.pool.FeeAsset != (bc.Hash{}) {
+		p.FeeAsset = hex.EncodeToString(n.pool.FeeAsset.Bytes())
+	}
+	if n.dev {
+		p.BlockIntervalMS = 0
+	}
+	return &p, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.pool.Priority = "fee"
+	n.pool.MaxBlockTxs = 7
+
+	rec := httptest.NewRecorder()
+	n.policy(rec, httptest.NewRequest("GET", "/policy", nil))
+
+	var got policy
+	err = json.NewDecoder(rec.Body).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := policy{
+		TxVersion:        3,
+		Priority:         "fee",
+		MaxBlockTxs:      7,
+		BlockIntervalMS:  5000,
+		MaxNonceWindowMS: 24 * 60 * 60 * 1000,
+		MaxBlockWindow:   600,
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Once the builder stops,
+	// handlers that consult it fail rather than hang.
+	close(n.quit)
+	id := strings.Repeat("00", 32)
+	for _, c := range []struct {
+		path string
+		h    http.HandlerFunc
+	}{
+		{"/policy", n.policy},
+		{"/docs?format=json", n.docs},
+		{"/tx?id=" + id, n.txHandler},
+	} {
+		rec := httptest.NewRecorder()
+		c.h(rec, httptest.NewRequest("GET", c.path, nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("after stopping, got status %d from %s, want %d", rec.Code, c.path, http.StatusServiceUnavailable)
+		}
+	}
+	_, err = n.checkTxLimits(ctx, txVersion, 100, 0)
+	if err == nil {
+		t.Error("after stopping, got no error from checkTxLimits")
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/chain/txvm/protocol"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/state"
+	"github.com/golang/protobuf/proto"
+)
+
+// txPool holds submitted transactions awaiting inclusion in a block.
+// It belongs to the builder goroutine.
+// Of its configuration fields,
+// only MaxBlockTxs, MaxBlockBytes, and TTL change once the server starts
+// (on SIGHUP; see reload),
+// so other goroutines must read those through node.do.
+type txPool struct {
+	// Priority names the order in which pending transactions are
+	// offered to the block builder. See priorities.
+	Priority string
+
+	// FeeAsset is the asset whose retirements count as fees
+	// for the "fee" and "feerate" priorities.
+	FeeAsset bc.Hash
+
+	// MaxBlockTxs, if positive, overrides the block builder's
+	// limit on the number of transactions in a block.
+	MaxBlockTxs int
+
+	// MaxBlockBytes, if positive, limits the total serialized size
+	// of the transactions in a block.
+	MaxBlockBytes int
+
+	// TTL, if positive, is how long a transaction may wait in the pool
+	// before it is evicted.
+	TTL time.Duration
+
+	log *log.Logger // for reporting dropped txs; the standard logger if nil
+	txs []*pendingTx
+}
+
+func (p *txPool) logf(format string, args ...interface{}) {
+	if p.log != nil {
+		p.log.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// pendingTx is an entry in the pool:
+// a single transaction,
+// or a bundle of transactions that must be included in the same block or not at all.
+type pendingTx struct {
+	txs      []*bc.CommitmentsTx
+	size     int
+	fee      int64
+	runlimit int64
+	added    time.Time
+}
+
+// priorities maps each priority name to a "less" function for
+// sort.SliceStable. A nil function means arrival order.
+var priorities = map[string]func(a, b *pendingTx) bool{
+	"fifo": nil,
+	"fee": func(a, b *pendingTx) bool {
+		return a.fee > b.fee
+	},
+	"feerate": func(a, b *pendingTx) bool {
+		return feerate(a) > feerate(b)
+	},
+
+	// Ordering by tx ID (of a bundle's first tx)
+	// lets proposers sharing a pool build identical blocks.
+	// Fill then moves each tx after any pending tx whose outputs it spends
+	// (see dependencyOrder).
+	"txid": func(a, b *pendingTx) bool {
+		return bytes.Compare(a.txs[0].Tx.ID.Bytes(), b.txs[0].Tx.ID.Bytes()) < 0
+	},
+}
+
+// dependencyOrder returns ptxs reordered as little as possible
+// so that each entry follows any entry whose outputs it spends.
+// Among entries whose dependencies are all placed,
+// the one earliest in ptxs goes first.
+func dependencyOrder(ptxs []*pendingTx) []*pendingTx {
+	producer := make(map[bc.Hash]int) // output ID -> index in ptxs
+	for i, ptx := range ptxs {
+		for _, tx := range ptx.txs {
+			for _, out := range tx.Tx.Outputs {
+				producer[out.ID] = i
+			}
+		}
+	}
+
+	var (
+		waiting    = make([]int, len(ptxs))   // number of unplaced entries each entry depends on
+		dependents = make([][]int, len(ptxs)) // entries that depend on each entry
+	)
+	for i, ptx := range ptxs {
+		seen := make(map[int]bool)
+		for _, tx := range ptx.txs {
+			for _, inp := range tx.Tx.Inputs {
+				if j, ok := producer[inp.ID]; ok && j != i && !seen[j] {
+					seen[j] = true
+					waiting[i]++
+					dependents[j] = append(dependents[j], i)
+				}
+			}
+		}
+	}
+
+	ready := new(intHeap)
+	for i := range ptxs {
+		if waiting[i] == 0 {
+			heap.Push(ready, i)
+		}
+	}
+	result := make([]*pendingTx, 0, len(ptxs))
+	for ready.Len() > 0 {
+		i := heap.Pop(ready).(int)
+		result = append(result, ptxs[i])
+		for _, j := range dependents[i] {
+			waiting[j]--
+			if waiting[j] == 0 {
+				heap.Push(ready, j)
+			}
+		}
+	}
+	if len(result) < len(ptxs) {
+		// A dependency cycle, which valid txs cannot form.
+		// Leave the entries in it where they were, at the end.
+		for i, ptx := range ptxs {
+			if waiting[i] > 0 {
+				result = append(result, ptx)
+			}
+		}
+	}
+	return result
+}
+
+type intHeap []int
+
+func (h intHeap) Len() int            { return len(h) }
+func (h intHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h intHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *intHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+func (h *intHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func feerate(p *pendingTx) float64 {
+	if p.runlimit <= 0 {
+		return 0
+	}
+	return float64(p.fee) / float64(p.runlimit)
+}
+
+// add adds txs to the pool as a single entry.
+// More than one tx makes a bundle.
+func (p *txPool) add(txs ...*bc.Tx) {
+	ptx := &pendingTx{added: time.Now()}
+	for _, tx := range txs {
+		ptx.txs = append(ptx.txs, bc.NewCommitmentsTx(tx))
+		ptx.size += proto.Size(&tx.RawTx)
+		ptx.runlimit += tx.Runlimit
+		for _, r := range tx.Retirements {
+			if r.AssetID == p.FeeAsset {
+				ptx.fee += r.Amount
+			}
+		}
+	}
+	p.txs = append(p.txs, ptx)
+}
+
+func (p *txPool) has(id bc.Hash) bool {
+	for _, ptx := range p.txs {
+		for _, tx := range ptx.txs {
+			if tx.Tx.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// len returns the number of pending transactions,
+// counting each member of a bundle.
+func (p *txPool) len() int {
+	var n int
+	for _, ptx := range p.txs {
+		n += len(ptx.txs)
+	}
+	return n
+}
+
+// fillResult describes what fill did with the pending transactions.
+type fillResult struct {
+	Added    int
+	Deferred int
+	Rejected []rejectedTx
+
+	added []*pendingTx // for putBack
+}
+
+// rejectedTx is a transaction that fill dropped, and why.
+type rejectedTx struct {
+	TxID   string `json:"tx_id"`
+	Reason string `json:"reason"`
+}
+
+// fill adds pending transactions to bb in priority order.
+// Transactions that bb rejects are dropped from the pool,
+// as are those too big for any block.
+// Transactions that don't fit in the block remain in the pool for the next one.
+// A bundle is added, deferred, or dropped as a whole.
+// Since bb cannot take back a transaction,
+// fill backs out a bundle that fails partway
+// by restarting bb with st and timestampMS,
+// which must be what bb was started with,
+// and adding again what it had already added,
+// starting with prefix,
+// which must be the txs (if any) already added to bb.
+func (p *txPool) fill(bb *protocol.BlockBuilder, st *state.Snapshot, timestampMS uint64, prefix ...*bc.CommitmentsTx) fillResult {
+	less, ok := priorities[p.Priority]
+	if !ok && p.Priority != "" {
+		panic(fmt.Sprintf("unknown priority %q", p.Priority))
+	}
+	if less != nil {
+		sort.SliceStable(p.txs, func(i, j int) bool { return less(p.txs[i], p.txs[j]) })
+	}
+	if p.Priority == "txid" {
+		p.txs = dependencyOrder(p.txs)
+	}
+
+	var (
+		deferred []*pendingTx
+		added    = append([]*bc.CommitmentsTx(nil), prefix...)
+		res      fillResult
+		size     int
+	)
+	for _, tx := range prefix {
+		size += proto.Size(&tx.Tx.RawTx)
+	}
+	for _, ptx := range p.txs {
+		if p.MaxBlockBytes > 0 && ptx.size > p.MaxBlockBytes {
+			// It can never fit, so don't keep it waiting.
+			reason := fmt.Sprintf("%d bytes, more than the %d allowed in a block", ptx.size, p.MaxBlockBytes)
+			for _, tx := range ptx.txs {
+				p.logf("dropping tx %x: %s", tx.Tx.ID.Bytes(), reason)
+				res.Rejected = append(res.Rejected, rejectedTx{
+					TxID:   hex.EncodeToString(tx.Tx.ID.Bytes()),
+					Reason: reason,
+				})
+			}
+			continue
+		}
+		if p.MaxBlockBytes > 0 && size+ptx.size > p.MaxBlockBytes {
+			deferred = append(deferred, ptx)
+			continue
+		}
+		i, err := addAll(bb, ptx.txs)
+		if err != nil && i > 0 {
+			err2 := bb.Start(st, timestampMS)
+			if err2 == nil {
+				_, err2 = addAll(bb, added)
+			}
+			if err2 != nil {
+				panic(fmt.Sprintf("restarting block after partial bundle: %s", err2))
+			}
+		}
+		if err == protocol.ErrBlockFull || err == protocol.ErrBlockRunlimit {
+			deferred = append(deferred, ptx)
+			continue
+		}
+		if err != nil {
+			failed := ptx.txs[i].Tx.ID.Bytes()
+			for _, tx := range ptx.txs {
+				reason := err.Error()
+				if tx != ptx.txs[i] {
+					reason = fmt.Sprintf("bundled with tx %x: %s", failed, err)
+				}
+				p.logf("dropping tx %x: %s", tx.Tx.ID.Bytes(), reason)
+				res.Rejected = append(res.Rejected, rejectedTx{
+					TxID:   hex.EncodeToString(tx.Tx.ID.Bytes()),
+					Reason: reason,
+				})
+			}
+			continue
+		}
+		added = append(added, ptx.txs...)
+		res.added = append(res.added, ptx)
+		size += ptx.size
+	}
+	res.Added = len(added) - len(prefix)
+	p.txs = deferred
+	res.Deferred = p.len()
+	return res
+}
+
+// putBack returns to the pool the transactions that fill added to a block
+// that was then not committed.
+// They go ahead of the others,
+// which with the fifo priority is where they were.
+func (p *txPool) putBack(res fillResult) {
+	p.txs = append(res.added, p.txs...)
+}
+
+// addAll adds txs to bb in order,
+// stopping at the first error
+// and returning it with the index of the tx that caused it.
+func addAll(bb *protocol.BlockBuilder, txs []*bc.CommitmentsTx) (int, error) {
+	for i, tx := range txs {
+		err := bb.AddTx(tx)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(txs), nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/state"
+)
+
+func TestPoolFill(t *testing.T) {
+	ctx := context.Background()
+
+	b1, err := protocol.NewInitialBlock(nil, 0, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := state.Empty()
+	err = st.ApplyBlock(b1.UnsignedBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx1 := testIssuance(ctx, t, b1, 10)
+	tx2 := testIssuance(ctx, t, b1, 20)
+
+	byID := []bc.Hash{tx1.ID, tx2.ID}
+	if bytes.Compare(tx1.ID.Bytes(), tx2.ID.Bytes()) > 0 {
+		byID = []bc.Hash{tx2.ID, tx1.ID}
+	}
+
+	cases := []struct {
+		name         string
+		priority     string
+		maxTxs       int
+		maxBytes     func(p *txPool) int
+		wantIncl     []bc.Hash
+		wantPending  int
+		wantRejected int
+	}{
+		{
+			name:     "fifo",
+			priority: "fifo",
+			wantIncl: []bc.Hash{tx1.ID, tx2.ID},
+		},
+		{
+			name:        "fee",
+			priority:    "fee",
+			maxTxs:      1,
+			wantIncl:    []bc.Hash{tx2.ID},
+			wantPending: 1,
+		},
+		{
+			name:     "txid",
+			priority: "txid",
+			wantIncl: byID,
+		},
+		{
+			name:        "max bytes",
+			priority:    "fifo",
+			maxBytes:    func(p *txPool) int { return p.txs[0].size },
+			wantIncl:    []bc.Hash{tx1.ID},
+			wantPending: 1,
+		},
+		{
+			name:     "larger than a block",
+			priority: "fifo",
+			maxBytes: func(p *txPool) int {
+				if p.txs[0].size < p.txs[1].size {
+					return p.txs[0].size - 1
+				}
+				return p.txs[1].size - 1
+			},
+			wantRejected: 2,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &txPool{Priority: c.priority}
+			p.add(tx1)
+			p.add(tx2)
+			p.txs[1].fee = 5
+			if c.maxBytes != nil {
+				p.MaxBlockBytes = c.maxBytes(p)
+			}
+
+			bb := protocol.NewBlockBuilder()
+			if c.maxTxs > 0 {
+				bb.MaxBlockTxs = c.maxTxs
+			}
+			timestamp := bc.Millis(time.Now())
+			err := bb.Start(st, timestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := p.fill(bb, st, timestamp)
+			if res.Added != len(c.wantIncl) {
+				t.Errorf("added %d txs, want %d", res.Added, len(c.wantIncl))
+			}
+			if len(res.Rejected) != c.wantRejected {
+				t.Errorf("rejected %d txs, want %d", len(res.Rejected), c.wantRejected)
+			}
+			if p.len() != c.wantPending {
+				t.Errorf("%d txs left in pool, want %d", p.len(), c.wantPending)
+			}
+
+			ub, _, err := bb.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ub.Transactions) != len(c.wantIncl) {
+				t.Fatalf("got %d txs in block, want %d", len(ub.Transactions), len(c.wantIncl))
+			}
+			for i, tx := range ub.Transactions {
+				if tx.ID != c.wantIncl[i] {
+					t.Errorf("tx %d in block is %x, want %x", i, tx.ID.Bytes(), c.wantIncl[i].Bytes())
+				}
+			}
+		})
+	}
+}
+
+func TestPoolFillBundle(t *testing.T) {
+	ctx := context.Background()
+
+	b1, err := protocol.NewInitialBlock(nil, 0, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := state.Empty()
+	err = st.ApplyBlock(b1.UnsignedBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx1 := testIssuance(ctx, t, b1, 10)
+	tx2 := testIssuance(ctx, t, b1, 20)
+	tx3 := testIssuance(ctx, t, b1, 30)
+	tx4 := testIssuance(ctx, t, b1, 40)
+
+	p := new(txPool)
+	p.add(tx1)
+	p.add(tx2, tx1) // fails partway: tx1 reuses its nonce
+	p.add(tx3, tx4)
+	if p.len() != 5 {
+		t.Fatalf("got pool length %d, want 5", p.len())
+	}
+
+	bb := protocol.NewBlockBuilder()
+	timestamp := bc.Millis(time.Now())
+	err = bb.Start(st, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := p.fill(bb, st, timestamp)
+	if res.Added != 3 {
+		t.Errorf("added %d txs, want 3", res.Added)
+	}
+	if len(res.Rejected) != 2 {
+		t.Errorf("rejected %d txs, want 2", len(res.Rejected))
+	}
+
+	ub, _, err := bb.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bc.Hash{tx1.ID, tx3.ID, tx4.ID}
+	if len(ub.Transactions) != len(want) {
+		t.Fatalf("got %d txs in block, want %d", len(ub.Transactions), len(want))
+	}
+	for i, tx := range ub.Transactions {
+		if tx.ID != want[i] {
+			t.Errorf("tx %d in block is %x, want %x", i, tx.ID.Bytes(), want[i].Bytes())
+		}
+	}
+}
+
+func TestDependencyOrder(t *testing.T) {
+	entry := func(id byte, inputs, outputs []byte) *pendingTx {
+		tx := &bc.Tx{ID: bc.NewHash([32]byte{id})}
+		for _, in := range inputs {
+			tx.Inputs = append(tx.Inputs, bc.Input{ID: bc.NewHash([32]byte{in})})
+		}
+		for _, out := range outputs {
+			tx.Outputs = append(tx.Outputs, bc.Output{ID: bc.NewHash([32]byte{out})})
+		}
+		return &pendingTx{txs: []*bc.CommitmentsTx{{Tx: tx}}}
+	}
+
+	// Sorted by tx ID, but 1 spends an output of 3, and 2 one of 1.
+	ptxs := []*pendingTx{
+		entry(1, []byte{30}, []byte{10}),
+		entry(2, []byte{10}, nil),
+		entry(3, nil, []byte{30}),
+		entry(4, nil, nil),
+	}
+	got := dependencyOrder(ptxs)
+	var gotIDs []byte
+	for _, ptx := range got {
+		gotIDs = append(gotIDs, ptx.txs[0].Tx.ID.Bytes()[0])
+	}
+	if want := []byte{3, 1, 2, 4}; !bytes.Equal(gotIDs, want) {
+		t.Errorf("got order %v, want %v", gotIDs, want)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// precommitHook is the -precommit-url hook,
+// which must acknowledge each block before it is committed.
+type precommitHook struct {
+	url     string
+	timeout time.Duration
+	halt    bool // on failure, halt the chain instead of retrying at the next block interval
+	client  *http.Client
+}
+
+// Values of -precommit-policy.
+var precommitPolicies = map[string]bool{
+	"retry": false,
+	"halt":  true,
+}
+
+// precommitNotice is the JSON body POSTed to the -precommit-url.
+type precommitNotice struct {
+	Chain       string   `json:"chain"`
+	Height      uint64   `json:"height"`
+	BlockID     string   `json:"block_id"`
+	PrevBlockID string   `json:"prev_block_id"`
+	TimestampMS uint64   `json:"timestamp_ms"`
+	TxIDs       []string `json:"tx_ids"`
+	Block       string   `json:"block"` // hex-encoded serialized block
+}
+
+// notify tells the hook that b is about to be committed to the chain named chain
+// and waits for it to acknowledge with a 2xx status.
+func (h *precommitHook) notify(ctx context.Context, chain string, b *bc.Block) error {
+	bits, err := b.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "marshaling block")
+	}
+	notice := precommitNotice{
+		Chain:       chain,
+		Height:      b.Height,
+		BlockID:     hex.EncodeToString(b.Hash().Bytes()),
+		PrevBlockID: hex.EncodeToString(b.PreviousBlockId.Bytes()),
+		TimestampMS: b.TimestampMs,
+		TxIDs:       []string{},
+		Block:       hex.EncodeToString(bits),
+	}
+	for _, tx := range b.Transactions {
+		notice.TxIDs = append(notice.TxIDs, hex.EncodeToString(tx.ID.Bytes()))
+	}
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return errors.Wrap(err, "marshaling precommit notice")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPrecommit(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		ack     bool
+		notices []precommitNotice
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var notice precommitNotice
+		err := json.NewDecoder(req.Body).Decode(&notice)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		notices = append(notices, notice)
+		if !ack {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	for _, halt := range []bool{false, true} {
+		ack = false
+		notices = nil
+
+		db, cleanup := testDB(t)
+		defer cleanup()
+
+		n, err := newNode(ctx, "", db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.precommit = &precommitHook{url: server.URL, timeout: time.Second, halt: halt, client: server.Client()}
+
+		tx := testIssuance(ctx, t, n.initialBlock, 10)
+		txID := hex.EncodeToString(tx.ID.Bytes())
+
+		var (
+			pending int
+			halted  bool
+		)
+		n.do(func() {
+			n.pool.add(tx)
+			if ub := n.buildBlock(ctx, time.Now().Add(time.Second)); ub != nil {
+				t.Errorf("halt=%v: block %d committed without acknowledgment", halt, ub.Height)
+			}
+			pending, halted = n.pool.len(), n.halted
+		})
+		if h := n.chain.Height(); h != 1 {
+			t.Errorf("halt=%v: got height %d after refused block, want 1", halt, h)
+		}
+		if pending != 1 {
+			t.Errorf("halt=%v: got %d pending txs after refused block, want 1", halt, pending)
+		}
+		if halted != halt {
+			t.Errorf("halt=%v: got halted %v after refused block", halt, halted)
+		}
+
+		ack = true
+		n.do(func() {
+			n.halted = false
+			n.buildBlock(ctx, time.Now().Add(2*time.Second))
+		})
+		if h := n.chain.Height(); h != 2 {
+			t.Fatalf("halt=%v: got height %d after acknowledged block, want 2", halt, h)
+		}
+		b, err := n.store.GetBlock(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(notices) != 2 {
+			t.Fatalf("halt=%v: got %d notices, want 2", halt, len(notices))
+		}
+		got := notices[1]
+		if got.Height != 2 || got.BlockID != hex.EncodeToString(b.Hash().Bytes()) {
+			t.Errorf("halt=%v: got notice of block %d %s, want 2 %x", halt, got.Height, got.BlockID, b.Hash().Bytes())
+		}
+		if len(got.TxIDs) != 1 || got.TxIDs[0] != txID {
+			t.Errorf("halt=%v: got tx IDs %v, want [%s]", halt, got.TxIDs, txID)
+		}
+	}
+}
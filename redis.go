@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/gomodule/redigo/redis"
+)
+
+// redisMirror mirrors the output index into Redis
+// for fast lookups under heavy query load.
+// The sqlite index remains authoritative:
+// the mirror is rebuilt from it whenever the two disagree,
+// and queries fall back to it when Redis fails.
+//
+// Each unspent output is stored as JSON under PREFIXoutput:ID,
+// and its ID is a member of the sets
+// PREFIXasset:ASSETID, PREFIXseed:SEED, and PREFIXpubkey:PUBKEY
+// (one for each of its pubkeys).
+// PREFIXheight holds the height of the last mirrored block.
+type redisMirror struct {
+	pool   *redis.Pool
+	prefix string
+
+	mu    sync.Mutex
+	stale bool // protected by mu
+}
+
+// newRedisMirror returns a mirror in the Redis server at addr
+// whose keys all begin with prefix.
+// If rebuild is true,
+// the mirror is rebuilt from scratch on the first call to Sync.
+func newRedisMirror(addr, prefix string, rebuild bool) *redisMirror {
+	return &redisMirror{
+		pool: &redis.Pool{
+			MaxIdle:     8,
+			IdleTimeout: time.Minute,
+			DialContext: func(ctx context.Context) (redis.Conn, error) {
+				return redis.DialContext(ctx, "tcp", addr)
+			},
+		},
+		prefix: prefix,
+		stale:  rebuild,
+	}
+}
+
+func (m *redisMirror) isStale() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stale
+}
+
+func (m *redisMirror) setStale(stale bool) {
+	m.mu.Lock()
+	m.stale = stale
+	m.mu.Unlock()
+}
+
+// Sync rebuilds the mirror from ix
+// if it is stale or its height differs from that of ix.
+func (m *redisMirror) Sync(ctx context.Context, ix *indexer) error {
+	conn, err := m.pool.GetContext(ctx)
+	if err != nil {
+		m.setStale(true)
+		return errors.Wrap(err, "connecting to redis")
+	}
+	defer conn.Close()
+
+	height, err := ix.Height(ctx)
+	if err != nil {
+		return err
+	}
+	if !m.isStale() {
+		mirrorHeight, err := redis.Uint64(conn.Do("GET", m.prefix+"height"))
+		if err == nil && mirrorHeight == height {
+			return nil
+		}
+		if err != nil && err != redis.ErrNil {
+			m.setStale(true)
+			return errors.Wrap(err, "getting redis mirror height")
+		}
+	}
+	return m.rebuild(ctx, conn, ix, height)
+}
+
+func (m *redisMirror) rebuild(ctx context.Context, conn redis.Conn, ix *indexer, height uint64) error {
+	m.setStale(true)
+
+	for cursor := 0; ; {
+		vals, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", m.prefix+"*", "COUNT", 1000))
+		if err != nil {
+			return errors.Wrap(err, "scanning redis mirror")
+		}
+		var keys []interface{}
+		_, err = redis.Scan(vals, &cursor, &keys)
+		if err != nil {
+			return errors.Wrap(err, "parsing redis scan result")
+		}
+		if len(keys) > 0 {
+			_, err = conn.Do("DEL", keys...)
+			if err != nil {
+				return errors.Wrap(err, "clearing redis mirror")
+			}
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+
+	outs, err := ix.queryOutputs(ctx, "o.spent_height IS NULL")
+	if err != nil {
+		return err
+	}
+	conn.Send("MULTI")
+	for _, out := range outs {
+		err = m.sendAdd(conn, out)
+		if err != nil {
+			return err
+		}
+	}
+	conn.Send("SET", m.prefix+"height", height)
+	_, err = conn.Do("EXEC")
+	if err != nil {
+		return errors.Wrap(err, "rebuilding redis mirror")
+	}
+
+	m.setStale(false)
+	return nil
+}
+
+// MirrorBlock copies the changes that indexing the block at the given height made to ix.
+// If the mirror is stale, it is rebuilt instead.
+func (m *redisMirror) MirrorBlock(ctx context.Context, ix *indexer, height uint64) error {
+	if m.isStale() {
+		return m.Sync(ctx, ix)
+	}
+
+	created, err := ix.queryOutputs(ctx, "o.height = $1 AND o.spent_height IS NULL", height)
+	if err != nil {
+		return err
+	}
+	spent, err := ix.queryOutputs(ctx, "o.spent_height = $1", height)
+	if err != nil {
+		return err
+	}
+
+	conn, err := m.pool.GetContext(ctx)
+	if err != nil {
+		m.setStale(true)
+		return errors.Wrap(err, "connecting to redis")
+	}
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	for _, out := range created {
+		err = m.sendAdd(conn, out)
+		if err != nil {
+			return err
+		}
+	}
+	for _, out := range spent {
+		conn.Send("DEL", m.prefix+"output:"+out.OutputID)
+		for _, key := range m.setKeys(out) {
+			conn.Send("SREM", key, out.OutputID)
+		}
+	}
+	conn.Send("SET", m.prefix+"height", height)
+	_, err = conn.Do("EXEC")
+	if err != nil {
+		m.setStale(true)
+		return errors.Wrapf(err, "mirroring block %d", height)
+	}
+	return nil
+}
+
+func (m *redisMirror) sendAdd(conn redis.Conn, out *indexedOutput) error {
+	bits, err := json.Marshal(out)
+	if err != nil {
+		return errors.Wrapf(err, "marshaling output %s", out.OutputID)
+	}
+	conn.Send("SET", m.prefix+"output:"+out.OutputID, bits)
+	for _, key := range m.setKeys(out) {
+		conn.Send("SADD", key, out.OutputID)
+	}
+	return nil
+}
+
+func (m *redisMirror) setKeys(out *indexedOutput) []string {
+	keys := []string{m.prefix + "seed:" + out.Seed}
+	if out.AssetID != "" {
+		keys = append(keys, m.prefix+"asset:"+out.AssetID)
+	}
+	for _, pubkey := range out.Pubkeys {
+		keys = append(keys, m.prefix+"pubkey:"+pubkey)
+	}
+	return keys
+}
+
+// Outputs returns the unspent outputs matching f.
+// At least one field of f must be non-nil.
+func (m *redisMirror) Outputs(ctx context.Context, f outputFilter) ([]*indexedOutput, error) {
+	if m.isStale() {
+		return nil, errors.New("redis mirror is stale")
+	}
+
+	var keys []interface{}
+	if f.AssetID != nil {
+		keys = append(keys, m.prefix+"asset:"+hex.EncodeToString(f.AssetID))
+	}
+	if f.Pubkey != nil {
+		keys = append(keys, m.prefix+"pubkey:"+hex.EncodeToString(f.Pubkey))
+	}
+	if f.Seed != nil {
+		keys = append(keys, m.prefix+"seed:"+hex.EncodeToString(f.Seed))
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("redis mirror cannot answer unfiltered queries")
+	}
+
+	conn, err := m.pool.GetContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to redis")
+	}
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SINTER", keys...))
+	if err != nil {
+		return nil, errors.Wrap(err, "querying redis mirror")
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	outputKeys := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		outputKeys = append(outputKeys, m.prefix+"output:"+id)
+	}
+	vals, err := redis.ByteSlices(conn.Do("MGET", outputKeys...))
+	if err != nil {
+		return nil, errors.Wrap(err, "getting outputs from redis mirror")
+	}
+
+	result := make([]*indexedOutput, 0, len(vals))
+	for i, val := range vals {
+		if val == nil {
+			return nil, errors.Wrapf(errors.New("missing output"), "output %s", ids[i])
+		}
+		var out indexedOutput
+		err = json.Unmarshal(val, &out)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing output %s from redis mirror", ids[i])
+		}
+		result = append(result, &out)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		if a.TxID != b.TxID {
+			return a.TxID < b.TxID
+		}
+		return a.LogPos < b.LogPos
+	})
+	return result, nil
+}
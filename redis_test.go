@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/protocol"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/state"
+	"github.com/chain/txvm/protocol/txbuilder/standard"
+	"github.com/gomodule/redigo/redis"
+)
+
+// fakeRedis is an in-memory redis.Conn
+// implementing the commands used by redisMirror.
+type fakeRedis struct {
+	strs  map[string][]byte
+	sets  map[string]map[string]bool
+	multi bool
+	queue [][]interface{} // commands sent after MULTI
+	fail  bool            // whether EXEC fails
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		strs: make(map[string][]byte),
+		sets: make(map[string]map[string]bool),
+	}
+}
+
+func (c *fakeRedis) Close() error { return nil }
+func (c *fakeRedis) Err() error   { return nil }
+func (c *fakeRedis) Flush() error { return nil }
+
+func (c *fakeRedis) Receive() (interface{}, error) {
+	return nil, errors.New("Receive not supported")
+}
+
+func (c *fakeRedis) Send(cmd string, args ...interface{}) error {
+	switch {
+	case cmd == "MULTI":
+		c.multi = true
+	case c.multi:
+		c.queue = append(c.queue, append([]interface{}{cmd}, args...))
+	default:
+		_, err := c.do(cmd, args)
+		return err
+	}
+	return nil
+}
+
+func (c *fakeRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "":
+		return nil, nil
+	case "MULTI":
+		c.multi = true
+		return "OK", nil
+	case "DISCARD":
+		c.multi, c.queue = false, nil
+		return "OK", nil
+	case "EXEC":
+		queue := c.queue
+		c.multi, c.queue = false, nil
+		if c.fail {
+			return nil, errors.New("fake redis failure")
+		}
+		var result []interface{}
+		for _, q := range queue {
+			r, err := c.do(q[0].(string), q[1:])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, r)
+		}
+		return result, nil
+	}
+	return c.do(cmd, args)
+}
+
+func (c *fakeRedis) do(cmd string, args []interface{}) (interface{}, error) {
+	str := func(v interface{}) string {
+		if b, ok := v.([]byte); ok {
+			return string(b)
+		}
+		return fmt.Sprint(v)
+	}
+
+	switch cmd {
+	case "GET":
+		if v, ok := c.strs[str(args[0])]; ok {
+			return v, nil
+		}
+		return nil, nil
+
+	case "SET":
+		c.strs[str(args[0])] = []byte(str(args[1]))
+		return "OK", nil
+
+	case "MGET":
+		var result []interface{}
+		for _, k := range args {
+			if v, ok := c.strs[str(k)]; ok {
+				result = append(result, v)
+			} else {
+				result = append(result, nil)
+			}
+		}
+		return result, nil
+
+	case "DEL":
+		for _, k := range args {
+			delete(c.strs, str(k))
+			delete(c.sets, str(k))
+		}
+		return int64(len(args)), nil
+
+	case "SADD":
+		key := str(args[0])
+		if c.sets[key] == nil {
+			c.sets[key] = make(map[string]bool)
+		}
+		for _, m := range args[1:] {
+			c.sets[key][str(m)] = true
+		}
+		return int64(len(args) - 1), nil
+
+	case "SREM":
+		key := str(args[0])
+		for _, m := range args[1:] {
+			delete(c.sets[key], str(m))
+		}
+		if len(c.sets[key]) == 0 {
+			delete(c.sets, key)
+		}
+		return int64(len(args) - 1), nil
+
+	case "SINTER":
+		var result []interface{}
+		for m := range c.sets[str(args[0])] {
+			in := true
+			for _, k := range args[1:] {
+				in = in && c.sets[str(k)][m]
+			}
+			if in {
+				result = append(result, []byte(m))
+			}
+		}
+		return result, nil
+
+	case "SCAN":
+		// Everything in one batch:
+		// SCAN cursor MATCH prefix* COUNT n.
+		prefix := strings.TrimSuffix(str(args[2]), "*")
+		var keys []interface{}
+		for k := range c.strs {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, []byte(k))
+			}
+		}
+		for k := range c.sets {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, []byte(k))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}, nil
+	}
+	return nil, fmt.Errorf("unsupported command %s", cmd)
+}
+
+func TestRedisMirror(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	bs, err := newBlockStore(db, make(chan uint64, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, err := bs.GetBlock(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, err := newIndexer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// index builds and indexes the next block, containing txs.
+	st := state.Empty()
+	err = st.ApplyBlock(b1.UnsignedBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := func(txs ...*bc.Tx) {
+		t.Helper()
+		bb := protocol.NewBlockBuilder()
+		err := bb.Start(st, st.Header.TimestampMs+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tx := range txs {
+			err = bb.AddTx(bc.NewCommitmentsTx(tx))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		var ub *bc.UnsignedBlock
+		ub, st, err = bb.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ix.IndexBlock(ctx, &bc.Block{UnsignedBlock: ub})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newFakeRedis()
+	m := &redisMirror{
+		pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }},
+		prefix: "test:",
+	}
+	ix.mirror = m
+
+	_, pub := testKeys(t)
+	assetID := standard.AssetID(2, 1, []ed25519.PublicKey{pub}, nil)
+	filters := []outputFilter{
+		{AssetID: assetID[:]},
+		{Pubkey: pub},
+		{AssetID: assetID[:], Seed: standard.PayToMultisigSeed2[:]},
+	}
+
+	// check compares the mirror with the sqlite index.
+	check := func(height uint64, want int) {
+		t.Helper()
+		if m.isStale() {
+			t.Fatal("mirror is stale")
+		}
+		if got := string(c.strs["test:height"]); got != fmt.Sprint(height) {
+			t.Errorf("got mirror height %s, want %d", got, height)
+		}
+		for _, f := range filters {
+			got, err := m.Outputs(ctx, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ix.mirror = nil
+			sqlite, err := ix.Outputs(ctx, f)
+			ix.mirror = m
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sqlite) != want {
+				t.Fatalf("got %d outputs from sqlite, want %d", len(sqlite), want)
+			}
+			if !reflect.DeepEqual(got, sqlite) {
+				t.Errorf("mirror has %v, sqlite has %v", got, sqlite)
+			}
+		}
+	}
+
+	index(testIssuance(ctx, t, b1, 10))
+	check(2, 1)
+
+	index(testIssuance(ctx, t, b1, 20))
+	check(3, 2)
+
+	// Stand in for a block spending the output created in block 2.
+	index()
+	spent, err := ix.queryOutputs(ctx, "o.height = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("UPDATE outputs SET spent_height = 4 WHERE height = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.MirrorBlock(ctx, ix, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(4, 1)
+	if _, ok := c.strs["test:output:"+spent[0].OutputID]; ok {
+		t.Error("spent output is still in the mirror")
+	}
+
+	// A failed write leaves the mirror stale,
+	// and queries fall back to sqlite.
+	c.fail = true
+	index(testIssuance(ctx, t, b1, 30))
+	if !m.isStale() {
+		t.Fatal("mirror is not stale after a failed write")
+	}
+	if _, err := m.Outputs(ctx, filters[0]); err == nil {
+		t.Error("got no error querying a stale mirror")
+	}
+	outs, err := ix.Outputs(ctx, filters[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outs) != 2 {
+		t.Errorf("got %d outputs from a stale mirror's index, want 2", len(outs))
+	}
+
+	// The next block rebuilds the mirror,
+	// discarding whatever else is under its prefix.
+	c.fail = false
+	c.strs["test:output:junk"] = []byte("{}")
+	c.strs["other:height"] = []byte("7")
+	index()
+	check(6, 2)
+	if _, ok := c.strs["test:output:junk"]; ok {
+		t.Error("rebuild left a stray key in the mirror")
+	}
+	if _, ok := c.strs["other:height"]; !ok {
+		t.Error("rebuild removed a key outside the mirror's prefix")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chain/txvm/errors"
+)
+
+// ringReplicas is the number of points each backend has on a hashRing.
+// More points spread chains more evenly.
+const ringReplicas = 100
+
+// hashRing assigns chain IDs to backends by consistent hashing,
+// so that adding or removing a backend
+// moves only the chains assigned to it.
+type hashRing struct {
+	points   []uint64 // sorted
+	backends map[uint64]string
+}
+
+func newHashRing(backends []string) *hashRing {
+	r := &hashRing{backends: make(map[uint64]string)}
+	for _, b := range backends {
+		for i := 0; i < ringReplicas; i++ {
+			p := ringHash(b + "#" + strconv.Itoa(i))
+			r.points = append(r.points, p)
+			r.backends[p] = b
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+func ringHash(s string) uint64 {
+	h := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// backend returns the backend assigned to the given chain ID:
+// the owner of the first point at or after the ID's hash.
+func (r *hashRing) backend(chainID string) string {
+	h := ringHash(chainID)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.backends[r.points[i]]
+}
+
+// router proxies each /chains/ID/... request
+// to the backend txvmbcd process that its hashRing assigns to chain ID.
+type router struct {
+	ring    *hashRing
+	proxies map[string]*httputil.ReverseProxy
+}
+
+func newRouter(backends []string) (*router, error) {
+	r := &router{
+		ring:    newHashRing(backends),
+		proxies: make(map[string]*httputil.ReverseProxy),
+	}
+	for _, b := range backends {
+		u, err := url.Parse(b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing backend URL %s", b)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("backend %q is not an absolute URL", b)
+		}
+		r.proxies[b] = httputil.NewSingleHostReverseProxy(u)
+	}
+	return r, nil
+}
+
+func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rest := strings.TrimPrefix(req.URL.Path, "/chains/")
+	if rest == req.URL.Path {
+		httpErrf(w, http.StatusNotFound, "only /chains/ID/... requests are routed")
+		return
+	}
+	id := strings.SplitN(rest, "/", 2)[0]
+	if !chainIDRegexp.MatchString(id) {
+		httpErrf(w, http.StatusNotFound, "bad chain ID %q", id)
+		return
+	}
+	r.proxies[r.ring.backend(id)].ServeHTTP(w, req)
+}
+
+// backendsFlag is the value of the repeatable -backend flag.
+type backendsFlag []string
+
+func (b *backendsFlag) String() string {
+	return strings.Join(*b, ",")
+}
+
+func (b *backendsFlag) Set(s string) error {
+	for _, other := range *b {
+		if other == s {
+			return fmt.Errorf("duplicate backend %s", s)
+		}
+	}
+	*b = append(*b, s)
+	return nil
+}
+
+// routeCmd implements "txvmbcd route -backend URL ... [-addr LISTENADDR] [ID ...]".
+// With chain IDs as arguments,
+// it prints the backend assigned to each instead of serving.
+func routeCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("route", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:2423", "router listen address")
+	var backends backendsFlag
+	fs.Var(&backends, "backend", "base URL of a txvmbcd process hosting chains (may be repeated)")
+	fs.Parse(args)
+
+	if len(backends) == 0 {
+		return errors.New("route requires at least one -backend")
+	}
+	r, err := newRouter(backends)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		for _, id := range fs.Args() {
+			fmt.Printf("%s %s\n", id, r.ring.backend(id))
+		}
+		return nil
+	}
+
+	server := &http.Server{
+		Addr:        *addr,
+		Handler:     r,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	log.Printf("routing chains on %s to %d backend(s)", *addr, len(backends))
+	return server.ListenAndServe()
+}
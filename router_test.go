@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	backends := []string{"http://a", "http://b", "http://c", "http://d"}
+	r := newHashRing(backends)
+
+	counts := make(map[string]int)
+	assigned := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("chain%d", i)
+		b := r.backend(id)
+		counts[b]++
+		assigned[id] = b
+	}
+	for _, b := range backends {
+		if counts[b] < 100 {
+			t.Errorf("backend %s got only %d of 1000 chains", b, counts[b])
+		}
+	}
+
+	// Removing a backend moves only its own chains.
+	r = newHashRing(backends[:3])
+	for id, b := range assigned {
+		if got := r.backend(id); b != "http://d" && got != b {
+			t.Errorf("chain %s moved from %s to %s", id, b, got)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	var backends []string
+	for i := 0; i < 3; i++ {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprintf(w, "%d %s", i, req.URL.Path)
+		}))
+		defer server.Close()
+		backends = append(backends, server.URL)
+	}
+
+	r, err := newRouter(backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := httptest.NewServer(r)
+	defer router.Close()
+
+	for _, id := range []string{"x", "y", "z", "w"} {
+		var want string
+		for i, b := range backends {
+			if r.ring.backend(id) == b {
+				want = fmt.Sprintf("%d /chains/%s/info", i, id)
+			}
+		}
+		resp, err := http.Get(router.URL + "/chains/" + id + "/info")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != want {
+			t.Errorf("got %q for chain %s, want %q", body, id, want)
+		}
+	}
+
+	resp, err := http.Get(router.URL + "/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d for /info, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"sync/atomic"
+)
+
+// shutdownReport describes the state in which a node stopped,
+// so that orchestration can verify a clean stop
+// before failing over to a standby.
+type shutdownReport struct {
+	Chain string `json:"chain"`
+
+	// Height is the height of the last committed block.
+	Height uint64 `json:"height"`
+
+	// PendingCommitted is the number of pending transactions
+	// committed in a final block on the way down,
+	// and PendingDropped is the number that remained and were discarded.
+	PendingCommitted int `json:"pending_committed"`
+	PendingDropped   int `json:"pending_dropped"`
+
+	// LongPollsClosed is the number of /get requests
+	// that were waiting for a block when the server stopped.
+	LongPollsClosed int64 `json:"long_polls_closed"`
+
+	// SnapshotHeight is the height of the state snapshot saved on the way down.
+	SnapshotHeight uint64 `json:"snapshot_height"`
+
+	// WALFrames is the number of frames left in the sqlite write-ahead log
+	// after a final checkpoint
+	// (-1 if the db does not use a write-ahead log).
+	WALFrames int `json:"wal_frames"`
+
+	// DBClosed tells whether the db was closed cleanly.
+	DBClosed bool `json:"db_closed"`
+
+	Errors []string `json:"errors,omitempty"`
+}
+
+// stop is the first step in shutting down n.
+// It stops n from accepting transactions and building blocks on its timer,
+// and notes how many long polls are about to be closed.
+func (n *node) stop() {
+	n.do(func() {
+		n.closed = true
+	})
+
+	n.report = &shutdownReport{
+		Chain:           n.name,
+		LongPollsClosed: atomic.LoadInt64(&n.longPolls),
+	}
+}
+
+// shutdown finishes shutting down n after stop:
+// it commits what it can of the pool in a final block,
+// stops the builder goroutine,
+// saves a snapshot of the final state,
+// and closes n's db.
+// The HTTP server and n's background tasks should already be stopped.
+func (n *node) shutdown(ctx context.Context) *shutdownReport {
+	rep := n.report
+	addErr := func(err error) {
+		rep.Errors = append(rep.Errors, err.Error())
+		n.log.Print(err)
+	}
+
+	n.do(func() {
+		if n.pool.len() > 0 {
+			if ub := n.buildBlock(ctx, n.nowTimestamp()); ub != nil {
+				rep.PendingCommitted = len(ub.Transactions)
+			}
+			rep.PendingDropped = n.pool.len()
+		}
+	})
+	close(n.quit)
+
+	rep.Height = n.store.FinalHeight()
+
+	st := n.chain.State()
+	if st.Header != nil {
+		err := n.store.writeSnapshot(ctx, st)
+		if err != nil {
+			addErr(err)
+		} else {
+			rep.SnapshotHeight = st.Height()
+		}
+	}
+
+	var busy, walFrames, checkpointed int
+	err := n.db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &walFrames, &checkpointed)
+	if err != nil {
+		addErr(err)
+	}
+	rep.WALFrames = walFrames
+
+	err = n.db.Close()
+	if err != nil {
+		addErr(err)
+	}
+	rep.DBClosed = err == nil
+
+	return rep
+}
+
+// writeShutdownReports logs each report
+// and, if filename is not empty, writes them all to that file as a JSON array.
+func writeShutdownReports(reports []*shutdownReport, filename string) {
+	for _, rep := range reports {
+		bits, err := json.Marshal(rep)
+		if err != nil {
+			log.Printf("marshaling shutdown report: %s", err)
+			continue
+		}
+		log.Printf("shutdown report: %s", bits)
+	}
+	if filename == "" {
+		return
+	}
+	bits, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		log.Printf("marshaling shutdown reports: %s", err)
+		return
+	}
+	err = ioutil.WriteFile(filename, append(bits, '\n'), 0644)
+	if err != nil {
+		log.Printf("writing shutdown report file: %s", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
+	n.do(func() {
+		n.pool.add(tx)
+	})
+
+	n.stop()
+	rep := n.shutdown(ctx)
+
+	want := shutdownReport{
+		Height:           2,
+		PendingCommitted: 1,
+		SnapshotHeight:   2,
+		WALFrames:        -1,
+		DBClosed:         true,
+	}
+	if len(rep.Errors) > 0 {
+		t.Errorf("got errors %v", rep.Errors)
+	}
+	rep.Errors = nil
+	if !reflect.DeepEqual(*rep, want) {
+		t.Errorf("got %+v, want %+v", *rep, want)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/state"
+	"github.com/chain/txvm/protocol/validation"
+)
+
+// standbyRetry is how long a standby waits after failing to reach its primary.
+var standbyRetry = 5 * time.Second
+
+// standbyCmd implements "txvmbcd standby -primary URL -db DBFILE".
+// It replays the primary's blocks into DBFILE,
+// validating each one and recomputing the state it produces,
+// and exits with an error at the first block that does not check out.
+// It serves nothing.
+func standbyCmd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("standby", flag.ExitOnError)
+	primary := fs.String("primary", "", "base URL of the primary (e.g. http://host:2423 or http://host:2423/chains/ID)")
+	dbfile := fs.String("db", "", "path to the standby's block storage db")
+	wait := fs.Duration("wait", 30*time.Second, "how long each request to the primary waits for a new block")
+	fs.Parse(args)
+
+	if *primary == "" || *dbfile == "" {
+		return errors.New("standby requires -primary and -db")
+	}
+
+	db, err := sql.Open("sqlite3", *dbfile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	s := &standby{
+		primary: strings.TrimSuffix(*primary, "/"),
+		wait:    *wait,
+		client:  &http.Client{Timeout: *wait + 30*time.Second},
+	}
+	n, err := s.open(ctx, db)
+	if err != nil {
+		return err
+	}
+	return s.run(ctx, n)
+}
+
+// standby follows a primary's chain.
+type standby struct {
+	primary string
+	wait    time.Duration
+	client  *http.Client
+}
+
+// open opens the standby's chain in db,
+// starting it with the primary's genesis block if db is new,
+// and checks that the two chains have the same genesis block.
+func (s *standby) open(ctx context.Context, db *sql.DB) (*node, error) {
+	b1, err := s.fetch(ctx, 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the primary's genesis block")
+	}
+	if b1 == nil {
+		return nil, errors.New("primary has no genesis block")
+	}
+	n, err := newNodeFrom(ctx, db, b1)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening standby chain")
+	}
+	return n, nil
+}
+
+// newNodeFrom opens the chain in db
+// starting with the genesis block b1 if db is new,
+// and checks that the chain's genesis block is b1.
+func newNodeFrom(ctx context.Context, db *sql.DB, b1 *bc.Block) (*node, error) {
+	err := initSchema(db)
+	if err != nil {
+		return nil, err
+	}
+	bits, err := b1.Bytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling genesis block for writing to db")
+	}
+	_, err = db.ExecContext(ctx, "INSERT OR IGNORE INTO blocks (height, hash, bits) VALUES (1, $1, $2)", b1.Hash().Bytes(), bits)
+	if err != nil {
+		return nil, errors.Wrap(err, "writing genesis block to db")
+	}
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		return nil, err
+	}
+	if n.initialBlock.Hash() != b1.Hash() {
+		return nil, fmt.Errorf("db genesis block %x differs from %x", n.initialBlock.Hash().Bytes(), b1.Hash().Bytes())
+	}
+	return n, nil
+}
+
+// run verifies and commits the primary's blocks as they appear,
+// until ctx is canceled or a block fails to verify.
+func (s *standby) run(ctx context.Context, n *node) error {
+	log.Printf("verifying %s from height %d", s.primary, n.chain.Height()+1)
+	for {
+		height := n.chain.Height() + 1
+		b, err := s.fetch(ctx, height)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("getting block %d from primary: %s", height, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(standbyRetry):
+			}
+			continue
+		}
+		if b == nil {
+			continue
+		}
+
+		err = n.verifyBlock(ctx, b, height)
+		if err != nil {
+			err = fmt.Errorf("divergence at height %d: %s", height, err)
+			log.Print(err)
+			return err
+		}
+		log.Printf("verified block %d", height)
+	}
+}
+
+// fetch gets the block at height from the primary.
+// It returns nil if the block does not appear within s.wait.
+func (s *standby) fetch(ctx context.Context, height uint64) (*bc.Block, error) {
+	url := fmt.Sprintf("%s/get?height=%d&wait=%s", s.primary, height, s.wait)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response")
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusRequestTimeout:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	b := new(bc.Block)
+	err = b.FromBytes(body)
+	return b, errors.Wrapf(err, "parsing block %d", height)
+}
+
+// verifyBlock checks that b is a valid block at height following n's latest block,
+// applies it to a copy of n's state,
+// checking the state roots it declares against those it produces,
+// and only then commits it.
+func (n *node) verifyBlock(ctx context.Context, b *bc.Block, height uint64) error {
+	if b.Height != height {
+		return fmt.Errorf("got block %d, want %d", b.Height, height)
+	}
+	st := n.chain.State()
+	err := validation.Block(b.UnsignedBlock, st.Header)
+	if err != nil {
+		return err
+	}
+	st = state.Copy(st)
+	err = st.ApplyBlock(b.UnsignedBlock)
+	if err != nil {
+		return err
+	}
+	if got := st.ContractsTree.RootHash(); b.ContractsRoot.Byte32() != got {
+		return fmt.Errorf("block declares contracts root %x, but its state has %x", b.ContractsRoot.Bytes(), got[:])
+	}
+	if got := st.NonceTree.RootHash(); b.NoncesRoot.Byte32() != got {
+		return fmt.Errorf("block declares nonces root %x, but its state has %x", b.NoncesRoot.Bytes(), got[:])
+	}
+	err = n.chain.CommitAppliedBlock(ctx, b, st)
+	if err != nil {
+		return errors.Wrap(err, "committing block")
+	}
+	n.store.checkpoint(st)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+func TestStandby(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primaryDB, cleanup := testDB(t)
+	defer cleanup()
+	primary, err := newNode(ctx, "", primaryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	primary.handle(mux, "")
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, amount := range []int64{10, 20} {
+		primary.do(func() {
+			_, err = primary.commitNow(ctx, testIssuance(ctx, t, primary.initialBlock, amount))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	standbyDB, cleanup2 := testDB(t)
+	defer cleanup2()
+	s := &standby{primary: server.URL, wait: time.Second, client: new(http.Client)}
+	n, err := s.open(ctx, standbyDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.run(ctx, n)
+	}()
+
+	for i := 0; n.chain.Height() < 3; i++ {
+		if i == 100 {
+			t.Fatalf("standby reached only height %d, want 3", n.chain.Height())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := n.chain.State().ContractsTree.RootHash(), primary.chain.State().ContractsTree.RootHash(); got != want {
+		t.Errorf("standby contracts root %x differs from primary's %x", got[:], want[:])
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got error %v from run, want %s", err, context.Canceled)
+	}
+}
+
+func TestStandbyDivergence(t *testing.T) {
+	ctx := context.Background()
+
+	primaryDB, cleanup := testDB(t)
+	defer cleanup()
+	primary, err := newNode(ctx, "", primaryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A primary whose block 2 declares the wrong nonces root.
+	b2 := testBlock(t, primary.initialBlock, testIssuance(ctx, t, primary.initialBlock, 10))
+	badRoot := bc.NewHash([32]byte{1})
+	b2.NoncesRoot = &badRoot
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b := primary.initialBlock
+		if req.FormValue("height") == "2" {
+			b = b2
+		}
+		bits, err := b.Bytes()
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(bits)
+	}))
+	defer server.Close()
+
+	standbyDB, cleanup2 := testDB(t)
+	defer cleanup2()
+	s := &standby{primary: server.URL, wait: time.Second, client: new(http.Client)}
+	n, err := s.open(ctx, standbyDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.run(ctx, n)
+	if err == nil || !strings.Contains(err.Error(), "divergence at height 2") {
+		t.Errorf("got error %v, want divergence at height 2", err)
+	}
+	if h := n.chain.Height(); h != 1 {
+		t.Errorf("standby committed up to height %d, want 1", h)
+	}
+
+	// A standby with its own genesis block.
+	otherDB, cleanup3 := testDB(t)
+	defer cleanup3()
+	_, err = newBlockStore(otherDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.open(ctx, otherDB)
+	if err == nil || !strings.Contains(err.Error(), "genesis block") {
+		t.Errorf("got error %v opening standby with another genesis block, want a genesis block mismatch", err)
+	}
+}
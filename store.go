@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"github.com/chain/txvm/errors"
@@ -13,18 +17,42 @@ import (
 
 type blockStore struct {
 	db      *sql.DB
-	heights chan<- uint64
+	heights chan uint64
+
+	mu      sync.Mutex
+	height  uint64        // highest finalized height, protected by mu
+	changed chan struct{} // closed and replaced when height changes, protected by mu
+
+	// If persistOnly is true,
+	// SaveSnapshot (called by protocol.Chain) does nothing,
+	// and snapshots are written only by Persist or PersistEvery.
+	// Protected by mu.
+	persistOnly bool
+
+	// If every is nonzero,
+	// checkpoint queues each committed snapshot whose height is a multiple of it
+	// in checkpoints, protected by mu,
+	// signaling queued for the writer.
+	every       uint64
+	checkpoints []*state.Snapshot
+	queued      chan struct{}
 }
 
-func newBlockStore(db *sql.DB, heights chan<- uint64) (*blockStore, error) {
-	_, err := db.Exec(schema)
+// newBlockStore opens the block store in db,
+// creating it with a new genesis block if db is empty.
+// Finalized heights are sent to heights (if it is not nil) for protocol.NewChain.
+// The send never blocks: heights should be buffered,
+// and if its consumer falls behind, the oldest unconsumed height is replaced.
+func newBlockStore(db *sql.DB, heights chan uint64) (*blockStore, error) {
+	err := initSchema(db)
 	if err != nil {
-		return nil, errors.Wrap(err, "creating db schema")
+		return nil, err
 	}
 
 	var height uint64
 	err = db.QueryRow("SELECT height FROM blocks ORDER BY height DESC LIMIT 1").Scan(&height)
 	if err == sql.ErrNoRows {
+		height = 1
 		initialBlock, err := protocol.NewInitialBlock(nil, 0, time.Now())
 		if err != nil {
 			return nil, errors.Wrap(err, "producing genesis block")
@@ -44,6 +72,9 @@ func newBlockStore(db *sql.DB, heights chan<- uint64) (*blockStore, error) {
 	return &blockStore{
 		db:      db,
 		heights: heights,
+		height:  height,
+		changed: make(chan struct{}),
+		queued:  make(chan struct{}, 1),
 	}, nil
 }
 
@@ -53,9 +84,13 @@ func (s *blockStore) Height(context.Context) (uint64, error) {
 	return height, err
 }
 
-func (s *blockStore) GetBlock(_ context.Context, height uint64) (*bc.Block, error) {
+func (s *blockStore) GetBlock(ctx context.Context, height uint64) (_ *bc.Block, err error) {
+	_, sp := startSpan(ctx, "store.GetBlock")
+	sp.set("height", height)
+	defer func() { sp.finish(err) }()
+
 	var bits []byte
-	err := s.db.QueryRow("SELECT bits FROM blocks WHERE height = $1", height).Scan(&bits)
+	err = s.db.QueryRow("SELECT bits FROM blocks WHERE height = $1", height).Scan(&bits)
 	if err != nil {
 		return nil, errors.Wrapf(err, "reading block %d from db", height)
 	}
@@ -64,21 +99,70 @@ func (s *blockStore) GetBlock(_ context.Context, height uint64) (*bc.Block, erro
 	return b, errors.Wrapf(err, "parsing block %d", height)
 }
 
-func (s *blockStore) LatestSnapshot(context.Context) (*state.Snapshot, error) {
-	var bits []byte
-	err := s.db.QueryRow("SELECT bits FROM snapshots ORDER BY height DESC LIMIT 1").Scan(&bits)
-	if err == sql.ErrNoRows {
-		return nil, nil
+// BlockHash returns the hash of the block at the given height.
+func (s *blockStore) BlockHash(ctx context.Context, height uint64) ([]byte, error) {
+	var hash []byte
+	err := s.db.QueryRowContext(ctx, "SELECT hash FROM blocks WHERE height = $1", height).Scan(&hash)
+	return hash, errors.Wrapf(err, "reading hash of block %d from db", height)
+}
+
+// LatestSnapshot returns the latest usable snapshot, or nil if there is none.
+// A snapshot is usable if it parses
+// and its header matches the stored block at its height.
+// Unusable snapshots are deleted, with a log message,
+// so that Recover replays blocks from an earlier one
+// instead of failing.
+func (s *blockStore) LatestSnapshot(ctx context.Context) (*state.Snapshot, error) {
+	for {
+		var (
+			height          uint64
+			bits, blockHash []byte
+		)
+		err := s.db.QueryRowContext(ctx, "SELECT s.height, s.bits, b.hash FROM snapshots s LEFT JOIN blocks b ON b.height = s.height ORDER BY s.height DESC LIMIT 1").Scan(&height, &bits, &blockHash)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "getting latest snapshot from db")
+		}
+		st, err := parseSnapshot(height, bits, blockHash)
+		if err == nil {
+			return st, nil
+		}
+		log.Printf("discarding snapshot at height %d: %s", height, err)
+		_, err = s.db.ExecContext(ctx, "DELETE FROM snapshots WHERE height = $1", height)
+		if err != nil {
+			return nil, errors.Wrapf(err, "deleting snapshot at height %d", height)
+		}
 	}
+}
+
+// parseSnapshot parses the snapshot stored at the given height
+// and checks it against the hash of the stored block at that height
+// (nil if there is none).
+func parseSnapshot(height uint64, bits, blockHash []byte) (*state.Snapshot, error) {
+	st := state.Empty()
+	err := st.FromBytes(bits)
 	if err != nil {
-		return nil, errors.Wrap(err, "getting latest snapshot from db")
+		return nil, errors.Wrap(err, "parsing snapshot")
 	}
-	st := state.Empty()
-	err = st.FromBytes(bits)
-	return st, errors.Wrap(err, "parsing latest snapshot")
+	if st.Height() != height {
+		return nil, fmt.Errorf("snapshot has height %d", st.Height())
+	}
+	if blockHash == nil {
+		return nil, errors.New("no block at that height")
+	}
+	if h := st.Header.Hash().Bytes(); !bytes.Equal(h, blockHash) {
+		return nil, fmt.Errorf("snapshot header %x does not match block %x", h, blockHash)
+	}
+	return st, nil
 }
 
-func (s *blockStore) SaveBlock(_ context.Context, b *bc.Block) error {
+func (s *blockStore) SaveBlock(ctx context.Context, b *bc.Block) (err error) {
+	_, sp := startSpan(ctx, "store.SaveBlock")
+	sp.set("height", b.Height)
+	defer func() { sp.finish(err) }()
+
 	h := b.Hash().Bytes()
 	bits, err := b.Bytes()
 	if err != nil {
@@ -88,12 +172,89 @@ func (s *blockStore) SaveBlock(_ context.Context, b *bc.Block) error {
 	return errors.Wrapf(err, "writing block %d to db", b.Height)
 }
 
-func (s *blockStore) FinalizeHeight(_ context.Context, height uint64) error {
-	s.heights <- height
-	return nil
+func (s *blockStore) FinalizeHeight(ctx context.Context, height uint64) error {
+	_, sp := startSpan(ctx, "store.FinalizeHeight")
+	sp.set("height", height)
+	defer sp.finish(nil)
+
+	s.mu.Lock()
+	if height > s.height {
+		s.height = height
+		close(s.changed)
+		s.changed = make(chan struct{})
+	}
+	s.mu.Unlock()
+
+	if s.heights == nil {
+		return ctx.Err()
+	}
+	select {
+	case s.heights <- height:
+	default:
+		// The consumer is behind.
+		// Replace the stale height it has yet to take with this one;
+		// it ignores heights lower than the current one anyway.
+		select {
+		case <-s.heights:
+		default:
+		}
+		select {
+		case s.heights <- height:
+		default:
+		}
+	}
+	return ctx.Err()
+}
+
+// FinalHeight returns the highest finalized height.
+func (s *blockStore) FinalHeight() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.height
+}
+
+// BlockWaiter returns a channel that is closed
+// once the block at the given height has been finalized.
+// Unlike protocol.Chain.BlockWaiter,
+// it gives up (and leaves the channel open) when ctx is canceled,
+// so abandoned waits do not leak goroutines.
+func (s *blockStore) BlockWaiter(ctx context.Context, height uint64) <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		for ctx.Err() == nil {
+			s.mu.Lock()
+			h, changed := s.height, s.changed
+			s.mu.Unlock()
+
+			if h >= height {
+				close(ch)
+				return
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
 }
 
-func (s *blockStore) SaveSnapshot(_ context.Context, snapshot *state.Snapshot) error {
+func (s *blockStore) SaveSnapshot(ctx context.Context, snapshot *state.Snapshot) error {
+	s.mu.Lock()
+	persistOnly := s.persistOnly
+	s.mu.Unlock()
+	if persistOnly {
+		return nil
+	}
+	return s.writeSnapshot(ctx, snapshot)
+}
+
+func (s *blockStore) writeSnapshot(ctx context.Context, snapshot *state.Snapshot) (err error) {
+	_, sp := startSpan(ctx, "store.SaveSnapshot")
+	sp.set("height", snapshot.Height())
+	defer func() { sp.finish(err) }()
+
 	bits, err := snapshot.Bytes()
 	if err != nil {
 		return errors.Wrapf(err, "marshaling snapshot at height %d for writing to db", snapshot.Height())
@@ -102,6 +263,178 @@ func (s *blockStore) SaveSnapshot(_ context.Context, snapshot *state.Snapshot) e
 	return errors.Wrapf(err, "writing snapshot at height %d to db", snapshot.Height())
 }
 
+// Persist writes the in-memory state of c to s
+// every interval (if it has changed),
+// instead of at the block-count intervals chosen by c.
+// This keeps snapshot writes off the commit path altogether,
+// at the cost of replaying up to interval's worth of blocks after a crash.
+// It returns only on error or context cancellation.
+func (s *blockStore) Persist(ctx context.Context, c *protocol.Chain, interval time.Duration) error {
+	s.mu.Lock()
+	s.persistOnly = true
+	s.mu.Unlock()
+
+	var persisted uint64
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		st := c.State()
+		if st.Height() <= persisted {
+			continue
+		}
+		err := s.writeSnapshot(ctx, st)
+		if err != nil {
+			return err
+		}
+		persisted = st.Height()
+	}
+}
+
+// PersistEvery makes s persist the state
+// every time the chain reaches a multiple of blocks,
+// instead of at the block-count intervals chosen by protocol.Chain.
+// From then on,
+// the commit path passes each new snapshot to checkpoint,
+// which queues those at multiples of blocks for writeCheckpoints.
+// As with Persist, the writes happen off the commit path,
+// and after a crash up to blocks blocks are replayed.
+func (s *blockStore) PersistEvery(blocks uint64) {
+	s.mu.Lock()
+	s.persistOnly = true
+	s.every = blocks
+	s.mu.Unlock()
+}
+
+// checkpoint queues snapshot for writing
+// if its height is a multiple of the interval given to PersistEvery.
+// It never blocks.
+func (s *blockStore) checkpoint(snapshot *state.Snapshot) {
+	s.mu.Lock()
+	if s.every == 0 || snapshot.Height()%s.every != 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.checkpoints = append(s.checkpoints, snapshot)
+	s.mu.Unlock()
+
+	select {
+	case s.queued <- struct{}{}:
+	default:
+		// The writer has yet to take an earlier signal,
+		// and will find this snapshot when it does.
+	}
+}
+
+// writeCheckpoints writes the snapshots queued by checkpoint as they arrive.
+// It returns only on error or context cancellation.
+func (s *blockStore) writeCheckpoints(ctx context.Context) error {
+	for {
+		select {
+		case <-s.queued:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err := s.flushCheckpoints(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// flushCheckpoints writes the snapshots queued by checkpoint so far.
+func (s *blockStore) flushCheckpoints(ctx context.Context) error {
+	s.mu.Lock()
+	pending := s.checkpoints
+	s.checkpoints = nil
+	s.mu.Unlock()
+
+	for _, st := range pending {
+		err := s.writeSnapshot(ctx, st)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaVersion is the version of the db schema that this code uses.
+// It is stored in the db as sqlite's user_version.
+// A db with version 0 predates versioning and is treated as version 1.
+var schemaVersion = len(migrations) + 1
+
+// migrations[i] upgrades a db from schema version i+1 to i+2.
+// To change the schema, append a migration here
+// (and update schema to match, for new dbs).
+var migrations = []func(*sql.Tx) error{
+	migrateBuilds,
+	migrateHookCodecs,
+	migrateRejectedTxs,
+}
+
+// initSchema creates the schema in a new db
+// or migrates an existing one to schemaVersion,
+// refusing to open a db with a newer schema.
+func initSchema(db *sql.DB) error {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'blocks'").Scan(&n)
+	if err != nil {
+		return errors.Wrap(err, "checking for existing db schema")
+	}
+	if n == 0 {
+		_, err = db.Exec(schema)
+		if err != nil {
+			return errors.Wrap(err, "creating db schema")
+		}
+		// PRAGMA does not take placeholders.
+		_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion))
+		return errors.Wrap(err, "setting db schema version")
+	}
+
+	version, err := dbSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		version = 1
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("db schema version %d is newer than this server's version %d", version, schemaVersion)
+	}
+	for ; version < schemaVersion; version++ {
+		dbtx, err := db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "beginning db migration")
+		}
+		err = migrations[version-1](dbtx)
+		if err != nil {
+			dbtx.Rollback()
+			return errors.Wrapf(err, "migrating db schema from version %d", version)
+		}
+		_, err = dbtx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1))
+		if err != nil {
+			dbtx.Rollback()
+			return errors.Wrapf(err, "setting db schema version to %d", version+1)
+		}
+		err = dbtx.Commit()
+		if err != nil {
+			return errors.Wrapf(err, "committing db migration to version %d", version+1)
+		}
+	}
+	_, err = db.Exec(schema)
+	return errors.Wrap(err, "updating db schema")
+}
+
+func dbSchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, errors.Wrap(err, "getting db schema version")
+}
+
 const schema = `
 CREATE TABLE IF NOT EXISTS blocks (
   height INTEGER NOT NULL PRIMARY KEY,
@@ -113,4 +446,4 @@ CREATE TABLE IF NOT EXISTS snapshots (
   height INTEGER NOT NULL PRIMARY KEY,
   bits BLOB NOT NULL
 );
-`
+` + buildsSchema + rejectedTxsSchema
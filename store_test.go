@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol/state"
+)
+
+func TestFinalizeHeight(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	heights := make(chan uint64, 1)
+	s, err := newBlockStore(db, heights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := s.FinalHeight(); h != 1 {
+		t.Fatalf("got initial height %d, want 1", h)
+	}
+
+	waiter := s.BlockWaiter(ctx, 3)
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	canceledWaiter := s.BlockWaiter(canceledCtx, 3)
+	cancel()
+
+	// Nothing consumes heights, so these must not block.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for h := uint64(2); h <= 4; h++ {
+			err := s.FinalizeHeight(ctx, h)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("FinalizeHeight blocked")
+	}
+
+	if got := <-heights; got != 4 {
+		t.Errorf("got height %d from channel, want 4", got)
+	}
+	if h := s.FinalHeight(); h != 4 {
+		t.Errorf("got final height %d, want 4", h)
+	}
+
+	select {
+	case <-waiter:
+	case <-time.After(5 * time.Second):
+		t.Fatal("BlockWaiter did not fire")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-canceledWaiter:
+		t.Error("BlockWaiter fired after cancellation")
+	default:
+	}
+}
+
+func TestLatestSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	s, err := newBlockStore(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, err := s.GetBlock(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st1 := state.Empty()
+	err = st1.ApplyBlock(b1.UnsignedBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SaveSnapshot(ctx, st1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot whose block was never stored.
+	b2 := testBlock(t, b1)
+	st2 := state.Copy(st1)
+	err = st2.ApplyBlock(b2.UnsignedBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SaveSnapshot(ctx, st2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot that does not parse.
+	_, err = db.Exec("INSERT INTO snapshots (height, bits) VALUES (3, $1)", []byte("garbage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.LatestSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Height() != 1 {
+		t.Fatalf("got snapshot %v, want the one at height 1", got)
+	}
+
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM snapshots").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d snapshots after repair, want 1", n)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- n.store.Persist(ctx, n.chain, 10*time.Millisecond)
+	}()
+
+	n.do(func() {
+		_, err = n.commitNow(ctx, testIssuance(ctx, t, n.initialBlock, 10))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; ; i++ {
+		st, err := n.store.LatestSnapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st != nil && st.Height() == 2 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("snapshot at height 2 was not persisted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got error %v from Persist, want %s", err, context.Canceled)
+	}
+}
+
+func TestPersistEvery(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.store.PersistEvery(2)
+
+	heights := func() []uint64 {
+		rows, err := db.Query("SELECT height FROM snapshots ORDER BY height")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var res []uint64
+		for rows.Next() {
+			var h uint64
+			if err := rows.Scan(&h); err != nil {
+				t.Fatal(err)
+			}
+			res = append(res, h)
+		}
+		return res
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		n.do(func() {
+			_, err = n.commitNow(ctx, testIssuance(ctx, t, n.initialBlock, 10*i))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The chain is at height 4; expect snapshots at 2 and 4 only,
+	// each of the state at exactly that height.
+	err = n.store.flushCheckpoints(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := heights(); !reflect.DeepEqual(got, []uint64{2, 4}) {
+		t.Fatalf("got snapshots at heights %v, want [2 4]", got)
+	}
+	for _, h := range []uint64{2, 4} {
+		var bits []byte
+		err = db.QueryRow("SELECT bits FROM snapshots WHERE height = $1", h).Scan(&bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := state.Empty()
+		err = st.FromBytes(bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Height() != h {
+			t.Errorf("snapshot stored at height %d is of height %d", h, st.Height())
+		}
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := n.store.writeCheckpoints(canceled); err != context.Canceled {
+		t.Errorf("got error %v from writeCheckpoints, want %s", err, context.Canceled)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chain/txvm/errors"
+)
+
+// tracer records spans and exports them in batches
+// to an OpenTelemetry collector,
+// using OTLP's HTTP transport with JSON encoding.
+type tracer struct {
+	endpoint string // base URL of the collector, e.g. http://localhost:4318
+	service  string // the service.name resource attribute
+	client   *http.Client
+
+	mu      sync.Mutex
+	pending []*span
+	dropped int
+}
+
+// traceExporter is the -otlp-endpoint tracer,
+// or nil if tracing is off.
+// It is set before the server starts.
+var traceExporter *tracer
+
+// Spans are exported every traceFlushInterval.
+// At most maxPendingSpans wait between exports;
+// more are dropped.
+var (
+	traceFlushInterval = 5 * time.Second
+	maxPendingSpans    = 4096
+)
+
+// OTLP span kinds.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+)
+
+// span is an operation being traced.
+// A nil *span, which startSpan returns when tracing is off,
+// is valid and records nothing.
+type span struct {
+	t            *tracer
+	name         string
+	kind         int
+	traceID      [16]byte
+	spanID       [8]byte
+	parentSpanID [8]byte // zero for a root span
+	start, end   time.Time
+	attrs        map[string]interface{}
+	err          error
+}
+
+type spanKey struct{}
+
+// startSpan starts a span named name
+// as a child of the span in ctx, if there is one,
+// and returns a context carrying the new span.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	if traceExporter == nil {
+		return ctx, nil
+	}
+	sp := &span{
+		t:     traceExporter,
+		name:  name,
+		kind:  spanKindInternal,
+		start: time.Now(),
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		sp.traceID = parent.traceID
+		sp.parentSpanID = parent.spanID
+	} else {
+		rand.Read(sp.traceID[:])
+	}
+	rand.Read(sp.spanID[:])
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+// spanFromContext returns the span in ctx,
+// or nil if there is none.
+func spanFromContext(ctx context.Context) *span {
+	sp, _ := ctx.Value(spanKey{}).(*span)
+	if sp == nil || sp.t == nil {
+		// Tracing is off, or this is a remote caller's span.
+		return nil
+	}
+	return sp
+}
+
+// set records an attribute of sp.
+// Val should be a string, bool, or integer.
+func (sp *span) set(key string, val interface{}) {
+	if sp == nil {
+		return
+	}
+	if sp.attrs == nil {
+		sp.attrs = make(map[string]interface{})
+	}
+	sp.attrs[key] = val
+}
+
+// finish ends sp,
+// marking it as failed if err is not nil,
+// and queues it for export.
+func (sp *span) finish(err error) {
+	if sp == nil {
+		return
+	}
+	sp.end = time.Now()
+	sp.err = err
+
+	t := sp.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) >= maxPendingSpans {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, sp)
+}
+
+// parseTraceparent parses a W3C traceparent header,
+// returning a span representing the remote caller.
+func parseTraceparent(s string) (*span, bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil, false
+	}
+	var remote span
+	if _, err := hex.Decode(remote.traceID[:], []byte(parts[1])); err != nil || remote.traceID == ([16]byte{}) {
+		return nil, false
+	}
+	if _, err := hex.Decode(remote.spanID[:], []byte(parts[2])); err != nil || remote.spanID == ([8]byte{}) {
+		return nil, false
+	}
+	return &remote, true
+}
+
+// traced wraps h in a server span named for the request's method and path,
+// continuing the trace in the request's traceparent header if it has one.
+func traced(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if traceExporter == nil {
+			h(w, req)
+			return
+		}
+		ctx := req.Context()
+		if remote, ok := parseTraceparent(req.Header.Get("Traceparent")); ok {
+			ctx = context.WithValue(ctx, spanKey{}, remote)
+		}
+		ctx, sp := startSpan(ctx, req.Method+" "+req.URL.Path)
+		sp.kind = spanKindServer
+		sp.set("http.method", req.Method)
+		sp.set("http.target", req.URL.Path)
+
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h(rec, req.WithContext(ctx))
+
+		sp.set("http.status_code", rec.code)
+		var err error
+		if rec.code >= 500 {
+			err = fmt.Errorf("status %d", rec.code)
+		}
+		sp.finish(err)
+	}
+}
+
+// statusRecorder is an http.ResponseWriter that remembers the status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Run exports the recorded spans every traceFlushInterval
+// until ctx is canceled,
+// and then once more.
+func (t *tracer) Run(ctx context.Context) {
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			t.flush(flushCtx)
+			return
+		}
+		t.flush(ctx)
+	}
+}
+
+// flush exports the pending spans, logging any error.
+func (t *tracer) flush(ctx context.Context) {
+	t.mu.Lock()
+	spans, dropped := t.pending, t.dropped
+	t.pending, t.dropped = nil, 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("dropped %d trace span(s) waiting for export", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	err := t.export(ctx, spans)
+	if err != nil {
+		log.Printf("exporting %d trace span(s): %s", len(spans), err)
+	}
+}
+
+// export POSTs spans to the collector's /v1/traces endpoint.
+func (t *tracer) export(ctx context.Context, spans []*span) error {
+	type keyValue struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+	attrs := func(m map[string]interface{}) []keyValue {
+		var result []keyValue
+		for k, v := range m {
+			var val map[string]interface{}
+			switch v := v.(type) {
+			case string:
+				val = map[string]interface{}{"stringValue": v}
+			case bool:
+				val = map[string]interface{}{"boolValue": v}
+			case int:
+				val = map[string]interface{}{"intValue": strconv.Itoa(v)}
+			case int64:
+				val = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+			case uint64:
+				val = map[string]interface{}{"intValue": strconv.FormatUint(v, 10)}
+			default:
+				val = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+			}
+			result = append(result, keyValue{Key: k, Value: val})
+		}
+		return result
+	}
+
+	var otlpSpans []interface{}
+	for _, sp := range spans {
+		s := map[string]interface{}{
+			"traceId":           hex.EncodeToString(sp.traceID[:]),
+			"spanId":            hex.EncodeToString(sp.spanID[:]),
+			"name":              sp.name,
+			"kind":              sp.kind,
+			"startTimeUnixNano": strconv.FormatInt(sp.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(sp.end.UnixNano(), 10),
+			"attributes":        attrs(sp.attrs),
+		}
+		if sp.parentSpanID != ([8]byte{}) {
+			s["parentSpanId"] = hex.EncodeToString(sp.parentSpanID[:])
+		}
+		if sp.err != nil {
+			s["status"] = map[string]interface{}{"code": 2, "message": sp.err.Error()}
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": attrs(map[string]interface{}{"service.name": t.service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "txvmbcd"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshaling spans")
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(t.endpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
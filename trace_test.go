@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestTrace(t *testing.T) {
+	ctx := context.Background()
+
+	type otlpSpan struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Kind         int    `json:"kind"`
+	}
+	var (
+		mu      sync.Mutex
+		service string
+		spans   = make(map[string]otlpSpan) // by name
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" {
+			http.NotFound(w, req)
+			return
+		}
+		var body struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []struct {
+						Key   string `json:"key"`
+						Value struct {
+							StringValue string `json:"stringValue"`
+						} `json:"value"`
+					} `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		err := json.NewDecoder(req.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range body.ResourceSpans {
+			for _, attr := range rs.Resource.Attributes {
+				if attr.Key == "service.name" {
+					service = attr.Value.StringValue
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, sp := range ss.Spans {
+					spans[sp.Name] = sp
+				}
+			}
+		}
+	}))
+	defer collector.Close()
+
+	traceExporter = &tracer{endpoint: collector.URL, service: "test", client: collector.Client()}
+	defer func() { traceExporter = nil }()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.dev = true
+
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
+	txbits, err := proto.Marshal(&tx.RawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		remoteSpanID = "00f067aa0ba902b7"
+	)
+	req, err := http.NewRequest("POST", server.URL+"/submit", bytes.NewReader(txbits))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Traceparent", "00-"+traceID+"-"+remoteSpanID+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		t.Fatalf("status code %d from POST /submit", resp.StatusCode)
+	}
+
+	// Wait for the handler to finish its span.
+	server.Close()
+	traceExporter.flush(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if service != "test" {
+		t.Errorf("got service name %q, want test", service)
+	}
+	root, ok := spans["POST /submit"]
+	if !ok {
+		t.Fatalf("no span for POST /submit among %v", spans)
+	}
+	if root.ParentSpanID != remoteSpanID {
+		t.Errorf("got parent span %q for POST /submit, want %s", root.ParentSpanID, remoteSpanID)
+	}
+	if root.Kind != spanKindServer {
+		t.Errorf("got kind %d for POST /submit, want %d", root.Kind, spanKindServer)
+	}
+
+	parents := map[string]string{
+		"pool.accept":     "POST /submit",
+		"block.build":     "pool.accept",
+		"block.commit":    "block.build",
+		"store.SaveBlock": "block.commit",
+	}
+	for name, parent := range parents {
+		sp, ok := spans[name]
+		if !ok {
+			t.Errorf("no span for %s", name)
+			continue
+		}
+		if sp.TraceID != traceID {
+			t.Errorf("got trace ID %s for %s, want %s", sp.TraceID, name, traceID)
+		}
+		if sp.ParentSpanID != spans[parent].SpanID {
+			t.Errorf("%s is not a child of %s", name, parent)
+		}
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	cases := []struct {
+		in   string
+		want bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+		{"", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false},
+	}
+	for _, c := range cases {
+		_, ok := parseTraceparent(c.in)
+		if ok != c.want {
+			t.Errorf("parseTraceparent(%q) ok = %v, want %v", c.in, ok, c.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/gob"
+	"net/http"
+	"strings"
+
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txvm"
+	"github.com/golang/protobuf/proto"
+)
+
+func init() {
+	// The concrete types that can appear in a bc.Tx's log and output stacks.
+	gob.Register(txvm.Int(0))
+	gob.Register(txvm.Bytes(nil))
+	gob.Register(txvm.Tuple(nil))
+}
+
+// encodeTrustedTx serializes tx, including the side effects parsed from running it,
+// for submission to /submit/trusted.
+func encodeTrustedTx(tx *bc.Tx) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(tx)
+	return buf.Bytes(), err
+}
+
+// submitTrusted handles /submit/trusted,
+// which accepts a transaction already run and parsed by the submitter
+// (encoded with encodeTrustedTx)
+// and adds it to the pool without running it again.
+// The caller must present the -trusted-token-file token as a bearer token.
+// A submitter that lies about a transaction's effects
+// can make the server build invalid blocks,
+// so this is for trusted internal services only.
+func (n *node) submitTrusted(w http.ResponseWriter, req *http.Request) {
+	n.tokensMu.RLock()
+	trustedToken := n.trustedToken
+	n.tokensMu.RUnlock()
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(trustedToken)) != 1 {
+		httpErrf(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if req.Method != "POST" {
+		httpErrf(w, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+		return
+	}
+
+	maxBody := trustedTxLimit(n.maxTxBytes)
+	if !limitBody(w, req, maxBody) {
+		return
+	}
+	var tx bc.Tx
+	err := gob.NewDecoder(req.Body).Decode(&tx)
+	if err != nil {
+		readErrf(w, err, maxBody)
+		return
+	}
+	if !tx.Finalized {
+		httpErrf(w, http.StatusBadRequest, "tx %x is not finalized", tx.ID.Bytes())
+		return
+	}
+	if code, err := n.checkTxLimits(req.Context(), tx.Version, proto.Size(&tx.RawTx), tx.Runlimit); err != nil {
+		httpErrf(w, code, "tx %x: %s", tx.ID.Bytes(), err)
+		return
+	}
+
+	n.enqueue(w, req, &tx)
+}
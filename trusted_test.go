@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSubmitTrusted(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.dev = true
+	n.trustedToken = "s3cret"
+
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
+	body, err := encodeTrustedTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		token      string
+		wantStatus int
+		wantHeight uint64
+	}{
+		{"", http.StatusUnauthorized, 1},
+		{"wrong", http.StatusUnauthorized, 1},
+		{"s3cret", http.StatusNoContent, 2},
+	} {
+		req, err := http.NewRequest("POST", server.URL+"/submit/trusted", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.wantStatus {
+			t.Errorf("token %q: got status %d, want %d", c.token, resp.StatusCode, c.wantStatus)
+		}
+		if h := n.chain.Height(); h != c.wantHeight {
+			t.Errorf("token %q: got height %d, want %d", c.token, h, c.wantHeight)
+		}
+	}
+
+	// A body too big for -max-tx-bytes is refused before it is decoded,
+	// whether or not its Content-Length is given.
+	n.maxTxBytes = 10
+	for _, r := range []io.Reader{bytes.NewReader(body), io.MultiReader(bytes.NewReader(body))} {
+		req, err := http.NewRequest("POST", server.URL+"/submit/trusted", r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("oversized body (content length %d): got status %d, want %d", req.ContentLength, resp.StatusCode, http.StatusRequestEntityTooLarge)
+		}
+	}
+
+	b2, err := n.chain.GetBlock(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b2.Transactions) != 1 || b2.Transactions[0].ID != tx.ID {
+		t.Errorf("block 2 does not contain tx %x", tx.ID.Bytes())
+	}
+}
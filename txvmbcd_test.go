@@ -12,12 +12,12 @@ import (
 	"net/url"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/chain/txvm/crypto/ed25519"
 	"github.com/chain/txvm/errors"
-	"github.com/chain/txvm/protocol"
 	"github.com/chain/txvm/protocol/bc"
 	"github.com/chain/txvm/protocol/txbuilder"
 	"github.com/chain/txvm/protocol/txbuilder/standard"
@@ -43,25 +43,14 @@ func TestServer(t *testing.T) {
 	}
 	defer db.Close()
 
-	heights := make(chan uint64)
-	bs, err := newBlockStore(db, heights)
+	n, err := newNode(ctx, "", db)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	initialBlock, err = bs.GetBlock(ctx, 1)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	chain, err = protocol.NewChain(ctx, initialBlock, bs, heights)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	http.HandleFunc("/get", get)
-	http.HandleFunc("/submit", submit)
-	server := httptest.NewServer(nil)
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+	server := httptest.NewServer(mux)
 	defer server.Close()
 
 	resp, err := http.Get(server.URL + "/get")
@@ -142,25 +131,7 @@ func TestServer(t *testing.T) {
 		ch <- b2
 	}()
 
-	const prvHex = "87fc07bf5fa9707b4e3cf1f6344d8a4d405a17425918ca5372239ff9e349cbef7996118db4183b89177435e2e0cc21dcb36427e2b09f35a72eeed37fede470c8"
-	prvBits, err := hex.DecodeString(prvHex)
-	if err != nil {
-		t.Fatal(err)
-	}
-	prv := ed25519.PrivateKey(prvBits)
-	pub := prv.Public().(ed25519.PublicKey)
-
-	tpl := txbuilder.NewTemplate(time.Now().Add(time.Minute), nil)
-	tpl.AddIssuance(2, initialBlock.Hash().Bytes(), nil, 1, [][]byte{prv}, nil, []ed25519.PublicKey{pub}, 10, nil, nil)
-	assetID := standard.AssetID(2, 1, []ed25519.PublicKey{pub}, nil)
-	tpl.AddOutput(1, []ed25519.PublicKey{pub}, 10, bc.NewHash(assetID), nil, nil)
-	tpl.Sign(ctx, func(_ context.Context, msg []byte, keyID []byte, path [][]byte) ([]byte, error) {
-		return ed25519.Sign(prv, msg), nil
-	})
-	tx, err := tpl.Tx()
-	if err != nil {
-		t.Fatal(err)
-	}
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
 	txbits, err := proto.Marshal(&tx.RawTx)
 	if err != nil {
 		t.Fatal(err)
@@ -187,6 +158,78 @@ func TestServer(t *testing.T) {
 	}
 }
 
+const testPrvHex = "87fc07bf5fa9707b4e3cf1f6344d8a4d405a17425918ca5372239ff9e349cbef7996118db4183b89177435e2e0cc21dcb36427e2b09f35a72eeed37fede470c8"
+
+func testKeys(t *testing.T) (ed25519.PrivateKey, ed25519.PublicKey) {
+	prvBits, err := hex.DecodeString(testPrvHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prv := ed25519.PrivateKey(prvBits)
+	return prv, prv.Public().(ed25519.PublicKey)
+}
+
+// testIssuance builds a transaction issuing amount units of an asset
+// to the test pubkey on the blockchain with the given initial block.
+func testIssuance(ctx context.Context, t *testing.T, initialBlock *bc.Block, amount int64) *bc.Tx {
+	prv, pub := testKeys(t)
+
+	tpl := txbuilder.NewTemplate(time.Now().Add(time.Minute), nil)
+	tpl.AddIssuance(2, initialBlock.Hash().Bytes(), nil, 1, [][]byte{prv}, nil, []ed25519.PublicKey{pub}, amount, nil, nil)
+	assetID := standard.AssetID(2, 1, []ed25519.PublicKey{pub}, nil)
+	tpl.AddOutput(1, []ed25519.PublicKey{pub}, amount, bc.NewHash(assetID), nil, nil)
+	tpl.Sign(ctx, func(_ context.Context, msg []byte, keyID []byte, path [][]byte) ([]byte, error) {
+		return ed25519.Sign(prv, msg), nil
+	})
+	tx, err := tpl.Tx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestChainsFlag(t *testing.T) {
+	cases := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{args: []string{"a=a.db"}, want: "a=a.db"},
+		{args: []string{"a=a.db", "b-2=/tmp/b=2.db"}, want: "a=a.db,b-2=/tmp/b=2.db"},
+		{args: []string{"a.db"}, wantErr: true},
+		{args: []string{"a="}, wantErr: true},
+		{args: []string{"a/b=a.db"}, wantErr: true},
+		{args: []string{"a=a.db", "a=b.db"}, wantErr: true},
+		{args: []string{"default=a.db"}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(strings.Join(c.args, " "), func(t *testing.T) {
+			var (
+				chains chainsFlag
+				err    error
+			)
+			for _, arg := range c.args {
+				err = chains.Set(arg)
+				if err != nil {
+					break
+				}
+			}
+			if c.wantErr {
+				if err == nil {
+					t.Error("got no error, want one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := chains.String(); got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
 func unwraperr(err error) error {
 	err = errors.Root(err)
 	if err, ok := err.(*url.Error); ok {
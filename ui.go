@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// blockJSON is the JSON form of a block,
+// returned by /get?format=json.
+// Each tx is described as for the -admit script.
+type blockJSON struct {
+	Height      uint64       `json:"height"`
+	BlockID     string       `json:"block_id"`
+	PrevBlockID string       `json:"prev_block_id"`
+	TimestampMS uint64       `json:"timestamp_ms"`
+	Txs         []*admission `json:"txs"`
+}
+
+func newBlockJSON(chain string, b *bc.Block) *blockJSON {
+	res := &blockJSON{
+		Height:      b.Height,
+		BlockID:     hex.EncodeToString(b.Hash().Bytes()),
+		TimestampMS: b.TimestampMs,
+		Txs:         []*admission{},
+	}
+	if b.PreviousBlockId != nil {
+		res.PrevBlockID = hex.EncodeToString(b.PreviousBlockId.Bytes())
+	}
+	for _, tx := range b.Transactions {
+		res.Txs = append(res.Txs, newAdmission(chain, tx))
+	}
+	return res
+}
+
+// uiHandler handles /ui/,
+// a single-page block explorer built on the JSON API.
+func (n *node) uiHandler(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasSuffix(req.URL.Path, "/ui/") {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(uiPage))
+}
+
+// uiPage is the explorer.
+// Its requests are relative to its own URL, .../ui/,
+// so it works under a /chains/ID prefix.
+const uiPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>txvmbcd explorer</title>
+<style>
+body { font-family: sans-serif; max-width: 70em; margin: auto; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { border-bottom: 1px solid #ddd; padding: 0.25em 0.5em; text-align: left; vertical-align: top; }
+code, .id { font-family: monospace; word-break: break-all; }
+#error { color: #a00; }
+nav form { display: inline; }
+nav input { width: 40em; }
+</style>
+</head>
+<body>
+<nav>
+<a href="#/">Chain</a>
+<form id="search"><input id="q" placeholder="block height or tx ID"> <button>Find</button></form>
+</nav>
+<p id="error"></p>
+<div id="main"></div>
+<script>
+"use strict";
+
+const main = document.getElementById("main");
+const errorEl = document.getElementById("error");
+
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, c => ({"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;"})[c]);
+}
+
+async function api(path) {
+  const resp = await fetch("../" + path);
+  if (!resp.ok) {
+    throw new Error(path + ": " + resp.status + " " + (await resp.text()).trim());
+  }
+  return resp.json();
+}
+
+function time(ms) {
+  return new Date(ms).toISOString();
+}
+
+function blockLink(h) {
+  return '<a href="#/block/' + h + '">' + h + '</a>';
+}
+
+function flows(list) {
+  return (list || []).map(f => esc(f.amount) + ' of <span class="id">' + esc(f.asset_id) + '</span>').join("<br>");
+}
+
+async function showChain() {
+  const [info, policy, capacity] = await Promise.all([api("info"), api("policy"), api("stats/capacity?blocks=20")]);
+  let html = "<h2>Chain</h2><table>" +
+    "<tr><th>Initial block ID</th><td class=id>" + esc(info.initial_block_id) + "</td></tr>" +
+    "<tr><th>Height</th><td>" + blockLink(info.height) + "</td></tr>" +
+    "<tr><th>Latest block</th><td class=id>" + esc(info.latest_block_id) + " at " + time(info.latest_block_timestamp_ms) + "</td></tr>" +
+    "<tr><th>Pending txs</th><td>" + esc(info.pool_size) + "</td></tr>" +
+    "<tr><th>Block interval</th><td>" + esc(info.block_interval_ms) + " ms</td></tr>" +
+    "<tr><th>Max block txs</th><td>" + esc(policy.max_block_txs) + "</td></tr>" +
+    "<tr><th>Mean txs per block (last " + esc(capacity.blocks) + ")</th><td>" + esc(capacity.mean_txs.toFixed(2)) + "</td></tr>" +
+    "<tr><th>Server</th><td>" + esc(info.version) + ", up " + esc(info.uptime_secs) + " s" + (info.read_only ? ", read-only" : "") + "</td></tr>" +
+    "</table><h2>Recent blocks</h2><table><tr><th>Height</th><th>Time</th><th>Txs</th><th>ID</th></tr>";
+  const heights = [];
+  for (let h = info.height; h >= 1 && heights.length < 20; h--) {
+    heights.push(h);
+  }
+  const blocks = await Promise.all(heights.map(h => api("get?format=json&wait=0s&height=" + h)));
+  for (const b of blocks) {
+    html += "<tr><td>" + blockLink(b.height) + "</td><td>" + time(b.timestamp_ms) + "</td><td>" + b.txs.length + '</td><td class=id>' + esc(b.block_id) + "</td></tr>";
+  }
+  main.innerHTML = html + "</table>";
+}
+
+async function showBlock(height, highlight) {
+  const b = await api("get?format=json&wait=0s&height=" + height);
+  let html = "<h2>Block " + b.height + "</h2><table>" +
+    "<tr><th>ID</th><td class=id>" + esc(b.block_id) + "</td></tr>" +
+    "<tr><th>Previous</th><td>" + (b.height > 1 ? blockLink(b.height - 1) + ' <span class=id>' + esc(b.prev_block_id) + "</span>" : "") + "</td></tr>" +
+    "<tr><th>Time</th><td>" + time(b.timestamp_ms) + "</td></tr>" +
+    "<tr><th>Next</th><td>" + blockLink(b.height + 1) + "</td></tr>" +
+    "</table><h2>Transactions</h2><table><tr><th>ID</th><th>Runlimit</th><th>Bytes</th><th>Inputs</th><th>Outputs</th><th>Issuances</th><th>Retirements</th></tr>";
+  for (const tx of b.txs) {
+    const style = tx.tx_id === highlight ? ' style="background: #ffc"' : "";
+    html += "<tr" + style + '><td class=id><a href="#/tx/' + esc(tx.tx_id) + '">' + esc(tx.tx_id) + "</a></td><td>" + esc(tx.runlimit) + "</td><td>" + esc(tx.size) + "</td><td>" + esc(tx.inputs) + "</td><td>" + esc(tx.outputs) + "</td><td>" + flows(tx.issuances) + "</td><td>" + flows(tx.retirements) + "</td></tr>";
+  }
+  main.innerHTML = html + "</table>";
+}
+
+async function showTx(id) {
+  const st = await api("tx?id=" + encodeURIComponent(id));
+  if (st.status === "committed") {
+    await showBlock(st.height, st.tx_id);
+    main.insertAdjacentHTML("afterbegin", '<p>Tx <span class=id>' + esc(st.tx_id) + "</span> is committed in block " + blockLink(st.height) + ".</p>");
+    return;
+  }
+  main.innerHTML = '<p>Tx <span class=id>' + esc(st.tx_id) + "</span> is " + esc(st.status) + (st.error ? ": " + esc(st.error) : "") + ".</p>";
+}
+
+async function route() {
+  errorEl.textContent = "";
+  const parts = location.hash.replace(/^#\/?/, "").split("/");
+  try {
+    if (parts[0] === "block") {
+      await showBlock(parts[1]);
+    } else if (parts[0] === "tx") {
+      await showTx(parts[1]);
+    } else {
+      await showChain();
+    }
+  } catch (e) {
+    errorEl.textContent = e.message;
+  }
+}
+
+document.getElementById("search").addEventListener("submit", ev => {
+  ev.preventDefault();
+  const q = document.getElementById("q").value.trim();
+  location.hash = /^[0-9]+$/.test(q) ? "#/block/" + q : "#/tx/" + q;
+});
+window.addEventListener("hashchange", route);
+route();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestUI(t *testing.T) {
+	ctx := context.Background()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.dev = true
+	n.ui = true
+
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	rec := get("/ui/")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "txvmbcd explorer") {
+		t.Fatalf("got status %d and unexpected body from /ui/", rec.Code)
+	}
+
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
+	txID := hex.EncodeToString(tx.ID.Bytes())
+
+	if rec = get("/tx?id=" + txID); rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d for unknown tx, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	txbits, err := proto.Marshal(&tx.RawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/submit", bytes.NewReader(txbits)))
+	if rec.Code/100 != 2 {
+		t.Fatalf("status %d from POST /submit", rec.Code)
+	}
+
+	rec = get("/tx?id=" + txID)
+	var st txStatus
+	err = json.NewDecoder(rec.Body).Decode(&st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Status != "committed" || st.Height != 2 {
+		t.Errorf("got status %s at height %d, want committed at 2", st.Status, st.Height)
+	}
+
+	rec = get("/get?format=json&height=2")
+	var b blockJSON
+	err = json.NewDecoder(rec.Body).Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Height != 2 || b.PrevBlockID != hex.EncodeToString(n.initialBlock.Hash().Bytes()) {
+		t.Errorf("got block %d with previous block %s", b.Height, b.PrevBlockID)
+	}
+	if len(b.Txs) != 1 || b.Txs[0].TxID != txID || len(b.Txs[0].Issuances) != 1 || b.Txs[0].Issuances[0].Amount != 10 {
+		t.Errorf("got txs %+v, want the issuance of 10 in tx %s", b.Txs, txID)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// maxVerifyResults is the number of /submit outcomes a verifier remembers
+// for /tx?submission=ID.
+var maxVerifyResults = 10000
+
+// verifier runs submitted txs
+// (verifying their signatures and other checks)
+// in a pool of worker goroutines off the /submit path.
+// The outcome of each submission is reported by /tx?submission=ID.
+type verifier struct {
+	jobs chan *verifyJob
+
+	mu      sync.Mutex
+	results map[[32]byte]*txStatus // submission ID -> outcome, protected by mu
+	order   [][32]byte             // keys of results, oldest first, protected by mu
+}
+
+type verifyJob struct {
+	id    [32]byte // hash of the serialized RawTx
+	rawTx bc.RawTx
+	key   string // Idempotency-Key header, if any
+}
+
+// startVerifiers makes n's /submit run txs in the given number of worker goroutines,
+// responding before the tx is run,
+// until ctx is canceled.
+func (n *node) startVerifiers(ctx context.Context, workers int) {
+	n.verifier = &verifier{
+		jobs:    make(chan *verifyJob, 64*workers),
+		results: make(map[[32]byte]*txStatus),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-n.verifier.jobs:
+					n.verifier.set(job.id, n.verify(ctx, job))
+				}
+			}
+		}()
+	}
+}
+
+// verify runs the tx in job and offers it to the builder goroutine,
+// returning the outcome.
+func (n *node) verify(ctx context.Context, job *verifyJob) (st *txStatus) {
+	var spanErr error
+	ctx, sp := startSpan(ctx, "verify")
+	defer func() { sp.finish(spanErr) }()
+
+	subID := hex.EncodeToString(job.id[:])
+	tx, err := bc.NewTx(job.rawTx.Program, job.rawTx.Version, job.rawTx.Runlimit)
+	if err != nil {
+		spanErr = errors.Wrap(err, "building tx")
+		return &txStatus{SubmissionID: subID, Status: "rejected", Error: spanErr.Error()}
+	}
+	txID := hex.EncodeToString(tx.ID.Bytes())
+	sp.set("tx.id", txID)
+
+	res := n.place(ctx, tx, job.key)
+	switch {
+	case res.err != nil:
+		spanErr = res.err
+		return &txStatus{SubmissionID: subID, TxID: txID, Status: "rejected", Error: res.err.Error()}
+	case res.status != nil:
+		st = res.status
+	case n.dev:
+		n.infof("committed tx %x in block %d", tx.ID.Bytes(), res.height)
+		st = &txStatus{TxID: txID, Status: "committed", Height: res.height}
+	default:
+		n.infof("added tx %x to the pool", tx.ID.Bytes())
+		st = &txStatus{TxID: txID, Status: "pending"}
+	}
+	st.SubmissionID = subID
+	return st
+}
+
+func (v *verifier) set(id [32]byte, st *txStatus) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.results[id]; !ok {
+		v.order = append(v.order, id)
+	}
+	v.results[id] = st
+	for len(v.order) > maxVerifyResults {
+		delete(v.results, v.order[0])
+		v.order = v.order[1:]
+	}
+}
+
+func (v *verifier) get(id [32]byte) *txStatus {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.results[id]
+}
+
+// verifyLater queues the tx in rawTx
+// (serialized as bits)
+// for a verifier worker
+// and responds to its /submit request with status 202
+// and the submission ID to pass to /tx?submission=ID.
+// A submission already queued or accepted gets its current status;
+// one that was rejected is tried again.
+func (n *node) verifyLater(w http.ResponseWriter, req *http.Request, bits []byte, rawTx bc.RawTx) {
+	if n.readonly {
+		httpErrf(w, http.StatusForbidden, "read-only node does not accept transactions")
+		return
+	}
+
+	id := sha256.Sum256(bits)
+	subID := hex.EncodeToString(id[:])
+	spanFromContext(req.Context()).set("submission.id", subID)
+
+	if st := n.submissionStatus(req.Context(), id); st != nil && st.Status != "rejected" {
+		respondJSON(w, st)
+		return
+	}
+
+	st := &txStatus{SubmissionID: subID, Status: "verifying"}
+	n.verifier.set(id, st)
+	select {
+	case n.verifier.jobs <- &verifyJob{id: id, rawTx: rawTx, key: req.Header.Get("Idempotency-Key")}:
+	default:
+		n.verifier.set(id, &txStatus{SubmissionID: subID, Status: "rejected", Error: "verification queue is full"})
+		httpErrf(w, http.StatusServiceUnavailable, "verification queue is full")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(st)
+}
+
+// submissionStatus returns the outcome of the submission with the given ID,
+// updated if its tx has since been committed or dropped from the pool,
+// or nil if n's verifier does not remember it.
+func (n *node) submissionStatus(ctx context.Context, id [32]byte) *txStatus {
+	st := n.verifier.get(id)
+	if st == nil || st.Status != "pending" {
+		return st
+	}
+	txID, err := hex.DecodeString(st.TxID)
+	if err != nil {
+		return st
+	}
+	cur, err := n.lookup(ctx, bc.HashFromBytes(txID))
+	if err != nil || cur == nil {
+		return st
+	}
+	cur.SubmissionID = st.SubmissionID
+	return cur
+}
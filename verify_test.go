@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestVerifyWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	n, err := newNode(ctx, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.dev = true
+	n.startVerifiers(ctx, 2)
+
+	mux := http.NewServeMux()
+	n.handle(mux, "")
+
+	submit := func(rawTx *bc.RawTx) (int, *txStatus) {
+		bits, err := proto.Marshal(rawTx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", "/submit", bytes.NewReader(bits)))
+		var st txStatus
+		if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+			t.Fatalf("decoding response with status %d: %s", rec.Code, err)
+		}
+		return rec.Code, &st
+	}
+	// await polls /tx?submission=ID until the submission is no longer verifying.
+	await := func(subID string) *txStatus {
+		for i := 0; i < 100; i++ {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", "/tx?submission="+subID, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d from /tx?submission=%s", rec.Code, subID)
+			}
+			var st txStatus
+			if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+				t.Fatal(err)
+			}
+			if st.Status != "verifying" {
+				return &st
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("submission %s still verifying", subID)
+		return nil
+	}
+
+	tx := testIssuance(ctx, t, n.initialBlock, 10)
+	code, st := submit(&tx.RawTx)
+	if code != http.StatusAccepted || st.Status != "verifying" || st.SubmissionID == "" {
+		t.Fatalf("got status %d and %+v, want %d and verifying", code, st, http.StatusAccepted)
+	}
+	st = await(st.SubmissionID)
+	if st.Status != "committed" || st.TxID != hex.EncodeToString(tx.ID.Bytes()) || st.Height != 2 {
+		t.Errorf("got %+v, want tx %x committed at height 2", st, tx.ID.Bytes())
+	}
+
+	code, st = submit(&tx.RawTx)
+	if code != http.StatusOK || st.Status != "committed" {
+		t.Errorf("resubmitting, got status %d and %+v, want %d and committed", code, st, http.StatusOK)
+	}
+
+	bad := tx.RawTx
+	bad.Runlimit = 1
+	code, st = submit(&bad)
+	if code != http.StatusAccepted {
+		t.Fatalf("got status %d for a tx that fails, want %d", code, http.StatusAccepted)
+	}
+	st = await(st.SubmissionID)
+	if st.Status != "rejected" || st.Error == "" {
+		t.Errorf("got %+v for a tx that fails, want rejected with an error", st)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/tx?submission="+hex.EncodeToString(make([]byte, 32)), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown submission, want %d", rec.Code, http.StatusNotFound)
+	}
+}